@@ -12,6 +12,6 @@ func main() {
 		cmd.PrintErr(err)
 		cmd.Println()
 
-		os.Exit(1)
+		os.Exit(root.ExitCode(err))
 	}
 }
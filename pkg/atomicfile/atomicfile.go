@@ -0,0 +1,91 @@
+package atomicfile
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// File is written to a temporary file alongside its destination, and only replaces the destination when committed.
+// A crash or an error part way through therefore never leaves a partially written file at the destination path.
+//
+// Close discards the temporary file if Commit hasn't been called, so it is always safe to defer.
+type File struct {
+	*os.File
+	path string
+	done bool
+}
+
+// Create opens a new temporary file in the same directory as `path`, so that it can be renamed over `path` when committed.
+// The temporary file is created with the same permissions os.Create would use; use Chmod to change them.
+func Create(path string) (*File, error) {
+	dir, name := filepath.Split(path)
+	suffix := make([]byte, 6)
+	for attempts := 0; ; attempts++ {
+		_, err := rand.Read(suffix)
+		if err != nil {
+			return nil, err
+		}
+		tempPath := filepath.Join(dir, "."+name+"."+hex.EncodeToString(suffix)+".tmp")
+		file, err := os.OpenFile(tempPath, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+		if errors.Is(err, os.ErrExist) && attempts < 100 {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &File{File: file, path: path}, nil
+	}
+}
+
+// Path returns the destination path, which the file will have once committed
+func (f *File) Path() string {
+	return f.path
+}
+
+// Commit flushes the file to disk and renames it over the destination path.
+// If anything fails, the temporary file is removed and the destination is left as it was.
+func (f *File) Commit() error {
+	if f.done {
+		return errors.New("the file has already been committed or closed")
+	}
+	f.done = true
+
+	err := f.File.Sync()
+	if err == nil {
+		err = f.File.Close()
+	} else {
+		_ = f.File.Close()
+	}
+	if err == nil {
+		err = os.Rename(f.File.Name(), f.path)
+	}
+	if err != nil {
+		_ = os.Remove(f.File.Name())
+		return err
+	}
+
+	// make the rename itself durable. Not all platforms can sync a directory, so this is best effort
+	if dir, err := os.Open(filepath.Dir(f.path)); err == nil {
+		_ = dir.Sync()
+		_ = dir.Close()
+	}
+	return nil
+}
+
+// Close discards the temporary file, unless it has already been committed
+func (f *File) Close() error {
+	if f.done {
+		return nil
+	}
+	f.done = true
+
+	err := f.File.Close()
+	removeErr := os.Remove(f.File.Name())
+	if err != nil {
+		return err
+	}
+	return removeErr
+}
@@ -0,0 +1,66 @@
+package atomicfile_test
+
+import (
+	"github.com/OctopusDeploy/go-octodiff/pkg/atomicfile"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestCommitReplacesDestination(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "output")
+	assert.Nil(t, os.WriteFile(path, []byte("old contents"), 0666))
+
+	file, err := atomicfile.Create(path)
+	assert.Nil(t, err)
+	defer func() { _ = file.Close() }()
+
+	_, err = file.WriteString("new contents")
+	assert.Nil(t, err)
+
+	// nothing changes until the file is committed
+	contents, _ := os.ReadFile(path)
+	assert.Equal(t, "old contents", string(contents))
+
+	assert.Nil(t, file.Commit())
+	contents, _ = os.ReadFile(path)
+	assert.Equal(t, "new contents", string(contents))
+
+	entries, _ := os.ReadDir(dir)
+	assert.Len(t, entries, 1) // the temporary file has gone
+	assert.Nil(t, file.Close())
+}
+
+func TestCloseDiscardsTemporaryFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "output")
+
+	file, err := atomicfile.Create(path)
+	assert.Nil(t, err)
+	_, err = file.WriteString("abandoned")
+	assert.Nil(t, err)
+	assert.Nil(t, file.Close())
+
+	entries, _ := os.ReadDir(dir)
+	assert.Empty(t, entries)
+	assert.NotNil(t, file.Commit())
+}
+
+func TestCommitKeepsPermissions(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("windows doesn't have unix permissions")
+	}
+	path := filepath.Join(t.TempDir(), "output")
+
+	file, err := atomicfile.Create(path)
+	assert.Nil(t, err)
+	assert.Nil(t, file.Chmod(0750))
+	assert.Nil(t, file.Commit())
+
+	info, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0750), info.Mode().Perm())
+}
@@ -0,0 +1,52 @@
+package cmdutil
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/OctopusDeploy/go-octodiff/pkg/octodiff"
+	"io"
+	"os"
+)
+
+// UnpackArchiveToTempFile writes the unpacked form of the archive at `path` (see octodiff.UnpackArchive) to a temporary file,
+// for the --archive options. The caller must call the returned cleanup func, which closes and removes the file.
+// If any compressed streams can't be unpacked, a warning saying so is written to `warnings`.
+func UnpackArchiveToTempFile(path string, warnings io.Writer) (*os.File, func(), error) {
+	archive, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() { _ = archive.Close() }()
+	archiveInfo, err := archive.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	file, err := os.CreateTemp("", "octodiff-*.tmp")
+	if err != nil {
+		return nil, nil, err
+	}
+	cleanup := func() {
+		_ = file.Close()
+		_ = os.Remove(file.Name())
+	}
+
+	output := bufio.NewWriter(file)
+	summary, err := octodiff.UnpackArchive(archive, archiveInfo.Size(), output)
+	if err == nil {
+		err = output.Flush()
+	}
+	if err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	if summary.KeptStreams > 0 {
+		_, _ = fmt.Fprintf(warnings, "warning: %d of the %d compressed streams in %s can't be unpacked, so changes inside them won't be found. "+
+			"Only streams compressed by Go can be unpacked, not those from zlib-based tools such as zip, gzip or .NET\n",
+			summary.KeptStreams, summary.KeptStreams+summary.UnpackedStreams, path)
+	}
+	return file, cleanup, nil
+}
@@ -0,0 +1,39 @@
+package cmdutil
+
+import (
+	"fmt"
+	"github.com/OctopusDeploy/go-octodiff/pkg/octodiff"
+	"os"
+)
+
+// The values of --progress-format
+const (
+	ProgressFormatAuto = "auto" // a bar when standard output is a terminal, and text otherwise
+	ProgressFormatText = "text" // a line every 10%
+	ProgressFormatBar  = "bar"  // a bar which is redrawn in place
+	ProgressFormatJSON = "json" // a line of JSON for each update; see octodiff.NewJSONProgressReporter
+)
+
+// ProgressFormatUsage describes --progress-format
+const ProgressFormatUsage = "How progress is written to stdout; one of auto, text, bar or json. auto draws a bar when stdout is a terminal, and writes text otherwise. Implies --progress."
+
+// NewProgressReporter creates the reporter for `format`, writing to standard output.
+// Call the returned func once the command is done, to finish off the output.
+func NewProgressReporter(format string) (octodiff.ProgressReporter, func(), error) {
+	if format == ProgressFormatAuto {
+		format = ProgressFormatText
+		if info, err := os.Stdout.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+			format = ProgressFormatBar
+		}
+	}
+	switch format {
+	case ProgressFormatText:
+		return octodiff.NewStdoutProgressReporter(), func() {}, nil
+	case ProgressFormatBar:
+		reporter, finish := octodiff.NewTerminalProgressReporter(os.Stdout)
+		return octodiff.NewThrottledProgressReporter(reporter, octodiff.DefaultProgressInterval), finish, nil
+	case ProgressFormatJSON:
+		return octodiff.NewThrottledProgressReporter(octodiff.NewJSONProgressReporter(os.Stdout), octodiff.DefaultProgressInterval), func() {}, nil
+	}
+	return nil, nil, fmt.Errorf("unknown progress format %s", format)
+}
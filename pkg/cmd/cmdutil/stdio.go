@@ -0,0 +1,73 @@
+package cmdutil
+
+import (
+	"github.com/OctopusDeploy/go-octodiff/pkg/atomicfile"
+	"io"
+	"os"
+)
+
+// StdioPath is given in place of a file path to read from standard input, or write to standard output
+const StdioPath = "-"
+
+// OpenInput opens `path` for reading, or returns standard input if path is "-"
+func OpenInput(path string) (io.ReadCloser, error) {
+	if path == StdioPath {
+		return io.NopCloser(os.Stdin), nil
+	}
+	return os.Open(path)
+}
+
+// Output is a file being written by a command, which only replaces anything at its path once committed.
+// Close discards it if it hasn't been committed, so it is always safe to defer.
+type Output interface {
+	io.Writer
+	Commit() error
+	Close() error
+}
+
+// CreateOutput creates an atomicfile.File for `path`, or returns standard output if path is "-".
+// Anything written to standard output can't be taken back, so committing and closing it do nothing.
+func CreateOutput(path string) (Output, error) {
+	if path == StdioPath {
+		return stdoutOutput{}, nil
+	}
+	return atomicfile.Create(path)
+}
+
+// stdoutOutput deliberately only implements io.Writer, as standard output may be a pipe which can't seek or write at an offset
+type stdoutOutput struct{}
+
+func (stdoutOutput) Write(p []byte) (int, error) {
+	return os.Stdout.Write(p)
+}
+
+func (stdoutOutput) Commit() error {
+	return nil
+}
+
+func (stdoutOutput) Close() error {
+	return nil
+}
+
+// SpoolToTempFile copies `input` to a temporary file, for APIs which need to seek around an input that can only be read once.
+// The caller must call the returned cleanup func, which closes and removes the file.
+func SpoolToTempFile(input io.Reader) (*os.File, func(), error) {
+	file, err := os.CreateTemp("", "octodiff-*.tmp")
+	if err != nil {
+		return nil, nil, err
+	}
+	cleanup := func() {
+		_ = file.Close()
+		_ = os.Remove(file.Name())
+	}
+
+	_, err = io.Copy(file, input)
+	if err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	return file, cleanup, nil
+}
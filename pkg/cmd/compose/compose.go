@@ -0,0 +1,81 @@
+package compose
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/OctopusDeploy/go-octodiff/pkg/cmd/cmdutil"
+	"github.com/OctopusDeploy/go-octodiff/pkg/octodiff"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+type ComposeOptions struct {
+	DeltaFiles []string
+	OutputFile string
+}
+
+func NewCmdCompose() *cobra.Command {
+	composeOpts := &ComposeOptions{}
+	cmd := &cobra.Command{
+		Use:  "compose <delta-file>... --output-file <delta-file>",
+		Long: "Given a chain of delta files (A to B, B to C, ...) in order, produces a single delta from the first basis file to the last new file. Use - to read one of the deltas from standard input, or write the result to standard output.",
+		RunE: func(c *cobra.Command, args []string) error {
+			composeOpts.DeltaFiles = append(composeOpts.DeltaFiles, args...)
+			return composeRun(composeOpts)
+		},
+	}
+
+	flags := cmd.Flags()
+
+	flags.StringVarP(&composeOpts.OutputFile, "output-file", "o", "", "The file to write the composed delta to.")
+
+	return cmd
+}
+
+func composeRun(opts *ComposeOptions) error {
+	if len(opts.DeltaFiles) == 0 {
+		return errors.New("no delta files were specified")
+	}
+	if opts.OutputFile == "" {
+		return errors.New("no output file was specified")
+	}
+
+	deltaReaders := make([]octodiff.DeltaReader, 0, len(opts.DeltaFiles))
+	readingStdin := false
+	for _, deltaFilePath := range opts.DeltaFiles {
+		if deltaFilePath == cmdutil.StdioPath {
+			if readingStdin {
+				return errors.New("only one delta file can be read from standard input")
+			}
+			readingStdin = true
+		}
+		deltaFile, err := cmdutil.OpenInput(deltaFilePath)
+		if errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("delta file %s does not exist or could not be opened", deltaFilePath)
+		}
+		if err != nil {
+			return err
+		}
+		defer func() { _ = deltaFile.Close() }()
+
+		deltaReaders = append(deltaReaders, octodiff.NewBinaryDeltaReader(bufio.NewReader(deltaFile)))
+	}
+
+	outputFile, err := cmdutil.CreateOutput(opts.OutputFile)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = outputFile.Close() }()
+
+	var outputFileWriter = bufio.NewWriter(outputFile)
+	err = octodiff.ComposeDeltaChain(deltaReaders, octodiff.NewBinaryDeltaWriter(outputFileWriter))
+	if err != nil {
+		return err
+	}
+	err = outputFileWriter.Flush()
+	if err != nil {
+		return err
+	}
+	return outputFile.Commit()
+}
@@ -2,26 +2,38 @@ package delta
 
 import (
 	"bufio"
+	"bytes"
 	"errors"
+	"fmt"
+	"github.com/OctopusDeploy/go-octodiff/pkg/atomicfile"
+	"github.com/OctopusDeploy/go-octodiff/pkg/cmd/cmdutil"
 	"github.com/OctopusDeploy/go-octodiff/pkg/octodiff"
 	"github.com/spf13/cobra"
 	"io"
 	"os"
+	"path/filepath"
 )
 
 type DeltaOptions struct {
-	SignatureFile string
-	NewFile       string
-	DeltaFile     string
-	Progress      bool
+	SignatureFile  string
+	NewFile        string
+	DeltaFile      string
+	Format         string
+	Recursive      bool
+	Archive        bool
+	Progress       bool
+	ProgressFormat string
 }
 
 func NewCmdDelta() *cobra.Command {
 	deltaOpts := &DeltaOptions{}
 	cmd := &cobra.Command{
 		Use:  "delta <signature-file> <new-file> [<delta-file>]",
-		Long: "Given a signature file and a new file, creates a delta file",
+		Long: "Given a signature file and a new file, creates a delta file. Use - to read either input from standard input, or write the delta to standard output.",
 		RunE: func(c *cobra.Command, args []string) error {
+			if c.Flags().Changed("progress-format") {
+				deltaOpts.Progress = true
+			}
 			// pick up positional arguments if not explicitly specified using --basis-file and --signature-file
 			argOffset := 0
 			if deltaOpts.SignatureFile == "" && len(args) > argOffset {
@@ -42,11 +54,16 @@ func NewCmdDelta() *cobra.Command {
 
 	flags := cmd.Flags()
 
-	flags.StringVarP(&deltaOpts.SignatureFile, "signature-file", "", "", "The file containing the signature from the basis file.")
+	flags.StringVarP(&deltaOpts.SignatureFile, "signature-file", "", "", "The file containing the signature from the basis file. May be an octodiff or rdiff signature.")
 	flags.StringVarP(&deltaOpts.NewFile, "new-file", "", "", "The file to create the delta from.")
 	flags.StringVarP(&deltaOpts.DeltaFile, "delta-file", "", "", "The file to write the delta to.")
 
+	flags.StringVarP(&deltaOpts.Format, "format", "", "octodiff", "The format to write the delta in; one of octodiff, vcdiff or rdiff.")
+
+	flags.BoolVarP(&deltaOpts.Recursive, "recursive", "r", false, "The new file is a directory, and the signature is from signature --recursive; create one delta file covering every file and directory in it.")
+	flags.BoolVarP(&deltaOpts.Archive, "archive", "", false, "The new file is a zip or gzip file, and the signature is from signature --archive; diff its unpacked contents. Apply the delta with patch --archive. Only entries compressed by Go can be unpacked; those from zlib-based tools such as zip, gzip or .NET's ZipFile are diffed as they are, with a warning.")
 	flags.BoolVarP(&deltaOpts.Progress, "progress", "", false, "Whether progress should be written to stdout")
+	flags.StringVarP(&deltaOpts.ProgressFormat, "progress-format", "", cmdutil.ProgressFormatAuto, cmdutil.ProgressFormatUsage)
 
 	return cmd
 }
@@ -62,58 +79,237 @@ func deltaRun(opts *DeltaOptions) error {
 	if newFilePath == "" {
 		return errors.New("No new file was specified")
 	}
+	if opts.Format != "octodiff" && opts.Format != "vcdiff" && opts.Format != "rdiff" {
+		return fmt.Errorf("unknown delta format %s", opts.Format)
+	}
 
-	signatureFile, err := os.Open(signatureFilePath)
-	if errors.Is(err, os.ErrNotExist) {
-		return errors.New("signature file does not exist or could not be opened")
+	if opts.Recursive {
+		if newFilePath == cmdutil.StdioPath {
+			return errors.New("--recursive needs a directory, which can't be read from standard input")
+		}
+		if opts.Format != "octodiff" {
+			return errors.New("--recursive deltas can only be written in the octodiff format")
+		}
+		newFilePath = filepath.Clean(newFilePath) // so that "dir/" gets a delta file next to it, not inside it
+	}
+
+	if opts.Archive {
+		if opts.Recursive {
+			return errors.New("--archive can't be combined with --recursive")
+		}
+		if newFilePath == cmdutil.StdioPath {
+			return errors.New("--archive needs to seek within the new file, so it can't be read from standard input")
+		}
+	}
+
+	if signatureFilePath == cmdutil.StdioPath && newFilePath == cmdutil.StdioPath {
+		return errors.New("only one of the signature file and new file can be read from standard input")
+	}
+	if deltaFilePath == "" {
+		if newFilePath == cmdutil.StdioPath {
+			return errors.New("no delta file was specified; use - to write it to standard output")
+		}
+		deltaFilePath = newFilePath + ".octodelta"
+	} else {
+		// mkdir_p on the signature file path directory? why?
+	}
+	if deltaFilePath == cmdutil.StdioPath && opts.Progress {
+		return errors.New("progress can't be written to standard output along with the delta")
+	}
+	if opts.Recursive {
+		return directoryDeltaRun(opts, signatureFilePath, newFilePath, deltaFilePath)
+	}
+
+	var signatureFileReader io.Reader
+	var signatureFileLength int64
+	if signatureFilePath == cmdutil.StdioPath {
+		// the signature reader needs to know the length up front, and signatures are small, so just read it all
+		signature, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+		signatureFileReader = bytes.NewReader(signature)
+		signatureFileLength = int64(len(signature))
+	} else {
+		signatureFile, err := os.Open(signatureFilePath)
+		if errors.Is(err, os.ErrNotExist) {
+			return errors.New("signature file does not exist or could not be opened")
+		}
+		if err != nil {
+			return err
+		}
+		defer func() { _ = signatureFile.Close() }()
+		signatureFileInfo, err := signatureFile.Stat()
+		if err != nil {
+			return err
+		}
+		signatureFileReader = bufio.NewReaderSize(signatureFile, 4*1024*1024)
+		signatureFileLength = signatureFileInfo.Size()
 	}
+
+	deltaFile, err := cmdutil.CreateOutput(deltaFilePath)
 	if err != nil {
 		return err
 	}
-	defer func() { _ = signatureFile.Close() }()
-	signatureFileInfo, err := signatureFile.Stat()
+	defer func() { _ = deltaFile.Close() }()
+
+	var progressReporter = octodiff.NopProgressReporter()
+	if opts.Progress {
+		var finishProgress func()
+		progressReporter, finishProgress, err = cmdutil.NewProgressReporter(opts.ProgressFormat)
+		if err != nil {
+			return err
+		}
+		defer finishProgress()
+	}
+
+	var deltaFileWriter = bufio.NewWriter(deltaFile)
+	var deltaWriter octodiff.DeltaWriter = octodiff.NewBinaryDeltaWriter(deltaFileWriter)
+	switch opts.Format {
+	case "vcdiff":
+		deltaWriter = octodiff.NewVcdiffDeltaWriter(deltaFileWriter)
+	case "rdiff":
+		deltaWriter = octodiff.NewRdiffDeltaWriter(deltaFileWriter)
+	}
+
+	// A new file on standard input can be streamed straight through, as long as we can go back and fill in its hash
+	// at the start of the delta afterwards (rdiff deltas don't have one). Otherwise it has to be saved somewhere first,
+	// as DeltaBuilder needs to seek within it.
+	deltaFileOnDisk, _ := deltaFile.(*atomicfile.File)
+	if newFilePath == cmdutil.StdioPath && (opts.Format == "rdiff" || (opts.Format == "octodiff" && deltaFileOnDisk != nil)) {
+		delta := octodiff.NewStreamingDeltaBuilder()
+		delta.ProgressReporter = progressReporter
+		hash, err := delta.Build(bufio.NewReaderSize(os.Stdin, 4*1024*1024), -1, signatureFileReader, signatureFileLength, deltaWriter)
+		if err != nil {
+			return err
+		}
+		err = deltaFileWriter.Flush()
+		if err != nil {
+			return err
+		}
+		if binaryDeltaWriter, ok := deltaWriter.(*octodiff.BinaryDeltaWriter); ok {
+			err = binaryDeltaWriter.WriteExpectedHash(deltaFileOnDisk, hash)
+			if err != nil {
+				return err
+			}
+		}
+		return deltaFile.Commit()
+	}
+
+	var newFile *os.File
+	if newFilePath == cmdutil.StdioPath {
+		var cleanup func()
+		newFile, cleanup, err = cmdutil.SpoolToTempFile(bufio.NewReaderSize(os.Stdin, 4*1024*1024))
+		if err != nil {
+			return err
+		}
+		defer cleanup()
+	} else if opts.Archive {
+		var cleanup func()
+		newFile, cleanup, err = cmdutil.UnpackArchiveToTempFile(newFilePath, os.Stderr)
+		if errors.Is(err, os.ErrNotExist) {
+			return errors.New("new file does not exist or could not be opened")
+		}
+		if err != nil {
+			return err
+		}
+		defer cleanup()
+	} else {
+		newFile, err = os.Open(newFilePath)
+		if errors.Is(err, os.ErrNotExist) {
+			return errors.New("new file does not exist or could not be opened")
+		}
+		if err != nil {
+			return err
+		}
+		defer func() { _ = newFile.Close() }()
+	}
+
+	newFileInfo, err := newFile.Stat()
 	if err != nil {
 		return err
 	}
 
-	newFile, err := os.Open(newFilePath)
-	if errors.Is(err, os.ErrNotExist) {
-		return errors.New("new file does not exist or could not be opened")
-	}
+	delta := octodiff.NewDeltaBuilder()
+	delta.ProgressReporter = progressReporter
+
+	// not using bufIo over newFile because we seek all over the place internally and bufio.Reader is not a ReadSeeker
+	err = delta.Build(newFile, newFileInfo.Size(), signatureFileReader, signatureFileLength, deltaWriter)
 	if err != nil {
 		return err
 	}
-	defer func() { _ = newFile.Close() }()
 
-	newFileInfo, err := newFile.Stat()
+	err = deltaFileWriter.Flush()
 	if err != nil {
 		return err
 	}
+	return deltaFile.Commit()
+}
 
-	if deltaFilePath == "" {
-		deltaFilePath = newFilePath + ".octodelta"
-	} else {
-		// mkdir_p on the signature file path directory? why?
+func directoryDeltaRun(opts *DeltaOptions, signatureFilePath string, newDirectoryPath string, deltaFilePath string) error {
+	newDirectoryInfo, err := os.Stat(newDirectoryPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return errors.New("new directory does not exist or could not be opened")
+	}
+	if err != nil {
+		return err
+	}
+	if !newDirectoryInfo.IsDir() {
+		return fmt.Errorf("%s is not a directory", newDirectoryPath)
 	}
 
-	deltaFile, err := os.Create(deltaFilePath)
+	signatureFile, err := cmdutil.OpenInput(signatureFilePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return errors.New("signature file does not exist or could not be opened")
+	}
 	if err != nil {
 		return err
 	}
-	defer func() { _ = deltaFile.Close() }()
+	defer func() { _ = signatureFile.Close() }()
 
-	delta := octodiff.NewDeltaBuilder()
+	signatureFileLength := int64(-1) // unknown when reading from standard input
+	if file, ok := signatureFile.(*os.File); ok {
+		signatureFileInfo, err := file.Stat()
+		if err != nil {
+			return err
+		}
+		signatureFileLength = signatureFileInfo.Size()
+	}
+
+	var progressReporter = octodiff.NopProgressReporter()
 	if opts.Progress {
-		delta.ProgressReporter = octodiff.NewStdoutProgressReporter()
+		var finishProgress func()
+		progressReporter, finishProgress, err = cmdutil.NewProgressReporter(opts.ProgressFormat)
+		if err != nil {
+			return err
+		}
+		defer finishProgress()
 	}
 
-	// not using bufIo over newFile because we seek all over the place internally and bufio.Reader is not a ReadSeeker
-	var signatureFileReader io.Reader = bufio.NewReaderSize(signatureFile, 4*1024*1024)
-	var deltaFileWriter = bufio.NewWriter(deltaFile)
-	err = delta.Build(newFile, newFileInfo.Size(), signatureFileReader, signatureFileInfo.Size(), octodiff.NewBinaryDeltaWriter(deltaFileWriter))
+	signatureReader := octodiff.NewDirectorySignatureReader()
+	signatureReader.ProgressReporter = progressReporter
+	signature, err := signatureReader.ReadDirectorySignature(bufio.NewReaderSize(signatureFile, 4*1024*1024), signatureFileLength)
+	if err != nil {
+		return err
+	}
+
+	deltaFile, err := cmdutil.CreateOutput(deltaFilePath)
 	if err != nil {
 		return err
 	}
+	defer func() { _ = deltaFile.Close() }()
+
+	delta := octodiff.NewDirectoryDeltaBuilder()
+	delta.ProgressReporter = progressReporter
 
-	return deltaFileWriter.Flush()
+	deltaFileWriter := bufio.NewWriter(deltaFile)
+	err = delta.Build(os.DirFS(newDirectoryPath), signature, deltaFileWriter)
+	if err != nil {
+		return err
+	}
+	err = deltaFileWriter.Flush()
+	if err != nil {
+		return err
+	}
+	return deltaFile.Commit()
 }
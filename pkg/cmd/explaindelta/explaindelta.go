@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"encoding/hex"
 	"errors"
+	"github.com/OctopusDeploy/go-octodiff/pkg/cmd/cmdutil"
 	"github.com/OctopusDeploy/go-octodiff/pkg/octodiff"
 	"github.com/spf13/cobra"
 	"io"
@@ -18,7 +19,7 @@ func NewCmdExplainDelta() *cobra.Command {
 	deltaOpts := &ExplainDeltaOptions{}
 	cmd := &cobra.Command{
 		Use:  "explain-delta <delta-file>",
-		Long: "Prints instructions from a delta file; useful when debugging.",
+		Long: "Prints instructions from a delta file; useful when debugging. Use - to read the delta from standard input.",
 		RunE: func(c *cobra.Command, args []string) error {
 			// pick up positional arguments if not explicitly specified using --basis-file and --signature-file
 			argOffset := 0
@@ -44,7 +45,7 @@ func explainDeltaRun(cmd *cobra.Command, opts *ExplainDeltaOptions) error {
 		return errors.New("no delta file was specified")
 	}
 
-	deltaFile, err := os.Open(deltaFilePath)
+	deltaFile, err := cmdutil.OpenInput(deltaFilePath)
 	if errors.Is(err, os.ErrNotExist) {
 		return errors.New("delta file does not exist or could not be opened")
 	}
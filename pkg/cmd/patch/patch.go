@@ -3,6 +3,9 @@ package patch
 import (
 	"bufio"
 	"errors"
+	"fmt"
+	"github.com/OctopusDeploy/go-octodiff/pkg/atomicfile"
+	"github.com/OctopusDeploy/go-octodiff/pkg/cmd/cmdutil"
 	"github.com/OctopusDeploy/go-octodiff/pkg/octodiff"
 	"github.com/spf13/cobra"
 	"io"
@@ -10,19 +13,30 @@ import (
 )
 
 type PatchOptions struct {
-	BasisFile        string
-	DeltaFile        string
-	NewFile          string
-	Progress         bool
-	SkipVerification bool
+	BasisFile           string
+	DeltaFile           string
+	NewFile             string
+	ReverseDeltaFile    string
+	Format              string
+	Parallelism         int
+	InPlace             bool
+	Recursive           bool
+	Archive             bool
+	PreservePermissions bool
+	Progress            bool
+	ProgressFormat      string
+	SkipVerification    bool
 }
 
 func NewCmdPatch() *cobra.Command {
 	patchOpts := &PatchOptions{}
 	cmd := &cobra.Command{
-		Use:  "patch <basis-file> <delta-file> <new-file>",
-		Long: "Given a basis file, and a delta, produces the new file.",
+		Use:  "patch <basis-file> <delta-file> [<new-file>]",
+		Long: "Given a basis file, and a delta, produces the new file. Use - to read the delta from standard input, or write the new file to standard output.",
 		RunE: func(c *cobra.Command, args []string) error {
+			if c.Flags().Changed("progress-format") {
+				patchOpts.Progress = true
+			}
 			// pick up positional arguments if not explicitly specified using --basis-file and --signature-file
 			argOffset := 0
 			if patchOpts.BasisFile == "" && len(args) > argOffset {
@@ -46,7 +60,15 @@ func NewCmdPatch() *cobra.Command {
 	flags.StringVarP(&patchOpts.BasisFile, "basis-file", "", "", "The file that the delta was created for.")
 	flags.StringVarP(&patchOpts.DeltaFile, "delta-file", "", "", "The delta to apply to the basis file.")
 	flags.StringVarP(&patchOpts.NewFile, "new-file", "", "", "The file to write the result to.")
+	flags.StringVarP(&patchOpts.ReverseDeltaFile, "reverse-delta", "", "", "Also write a delta which turns the new file back into the basis file, for rollback.")
+	flags.StringVarP(&patchOpts.Format, "format", "", "octodiff", "The format of the delta file; one of octodiff, vcdiff or rdiff.")
+	flags.IntVarP(&patchOpts.Parallelism, "parallelism", "", 1, "The number of copy commands to apply concurrently. Values above 1 write the new file out of order.")
+	flags.BoolVarP(&patchOpts.InPlace, "in-place", "", false, "Overwrite the basis file with the new file, rather than writing a separate new file. If this fails part way through, the basis file is left unusable.")
+	flags.BoolVarP(&patchOpts.Recursive, "recursive", "r", false, "The basis is a directory, and the delta is from delta --recursive; update the directory in place. Every file that is kept is verified before anything in the directory is changed.")
+	flags.BoolVarP(&patchOpts.Archive, "archive", "", false, "The basis is a zip or gzip file, and the delta is from delta --archive; apply the delta to its unpacked contents and pack the result.")
+	flags.BoolVarP(&patchOpts.PreservePermissions, "preserve-permissions", "", false, "Give the new file the same permissions as the basis file.")
 	flags.BoolVarP(&patchOpts.Progress, "progress", "", false, "Whether progress should be written to stdout.")
+	flags.StringVarP(&patchOpts.ProgressFormat, "progress-format", "", cmdutil.ProgressFormatAuto, cmdutil.ProgressFormatUsage)
 	flags.BoolVarP(&patchOpts.SkipVerification, "skip-verification", "", false, "Skip checking whether the basis file is the same as the file used to produce the signature that created the delta.")
 
 	return cmd
@@ -62,13 +84,71 @@ func patchRun(opts *PatchOptions) error {
 	if deltaFilePath == "" {
 		return errors.New("no delta file was specified")
 	}
+	if opts.Recursive {
+		return directoryPatchRun(opts, basisFilePath, deltaFilePath)
+	}
 	newFilePath := opts.NewFile
+	if opts.InPlace {
+		if newFilePath != "" {
+			return errors.New("a new file can't be specified with --in-place")
+		}
+		if opts.ReverseDeltaFile != "" || opts.Parallelism > 1 {
+			return errors.New("--in-place can't be combined with --reverse-delta or --parallelism")
+		}
+		newFilePath = basisFilePath
+	}
 	if newFilePath == "" {
 		return errors.New("no new file was specified")
 
 	}
+	if opts.Format != "octodiff" && opts.Format != "vcdiff" && opts.Format != "rdiff" {
+		return fmt.Errorf("unknown delta format %s", opts.Format)
+	}
+	if opts.Parallelism > 1 && opts.ReverseDeltaFile != "" {
+		return errors.New("--parallelism can't be combined with --reverse-delta")
+	}
+	if basisFilePath == cmdutil.StdioPath {
+		return errors.New("the basis file can't be read from standard input, as patching needs to seek within it")
+	}
+	if newFilePath == cmdutil.StdioPath {
+		if opts.Progress {
+			return errors.New("progress can't be written to standard output along with the new file")
+		}
+		if opts.ReverseDeltaFile == cmdutil.StdioPath {
+			return errors.New("only one of the new file and reverse delta can be written to standard output")
+		}
+		if opts.Parallelism > 1 {
+			return errors.New("--parallelism can't be used when writing the new file to standard output")
+		}
+		if opts.ReverseDeltaFile != "" && !opts.SkipVerification {
+			// with a reverse delta we verify by reading the new file back, which can't be done with standard output
+			return errors.New("--reverse-delta with the new file written to standard output requires --skip-verification")
+		}
+	}
+
+	var progressReporter = octodiff.NopProgressReporter()
+	if opts.Progress {
+		var finishProgress func()
+		var err error
+		progressReporter, finishProgress, err = cmdutil.NewProgressReporter(opts.ProgressFormat)
+		if err != nil {
+			return err
+		}
+		defer finishProgress()
+	}
+
+	if opts.Archive {
+		if opts.InPlace || opts.ReverseDeltaFile != "" || opts.Parallelism > 1 {
+			return errors.New("--archive can't be combined with --in-place, --reverse-delta or --parallelism")
+		}
+		return archivePatchRun(opts, basisFilePath, deltaFilePath, newFilePath, progressReporter)
+	}
 	// open files
-	basisFile, err := os.Open(basisFilePath)
+	basisFileFlag := os.O_RDONLY
+	if opts.InPlace {
+		basisFileFlag = os.O_RDWR
+	}
+	basisFile, err := os.OpenFile(basisFilePath, basisFileFlag, 0)
 	if errors.Is(err, os.ErrNotExist) {
 		return errors.New("basis file does not exist or could not be opened")
 	}
@@ -77,7 +157,7 @@ func patchRun(opts *PatchOptions) error {
 	}
 	defer func() { _ = basisFile.Close() }()
 
-	deltaFile, err := os.Open(deltaFilePath)
+	deltaFile, err := cmdutil.OpenInput(deltaFilePath)
 	if errors.Is(err, os.ErrNotExist) {
 		return errors.New("delta file does not exist or could not be opened")
 	}
@@ -86,46 +166,258 @@ func patchRun(opts *PatchOptions) error {
 	}
 	defer func() { _ = deltaFile.Close() }()
 
-	var deltaFileStream io.Reader = bufio.NewReader(deltaFile)
-	deltaReader := octodiff.NewBinaryDeltaReader(deltaFileStream)
+	deltaReader, err := newDeltaReader(opts.Format, deltaFile, progressReporter)
+	if err != nil {
+		return err
+	}
 
-	{ // nested lexical scope to contain the actual file writing, so we can ensure we flush/close properly
-		newFile, err := os.Create(newFilePath)
+	if opts.InPlace {
+		if !opts.SkipVerification {
+			// find out whether the result can be verified before overwriting the basis file, rather than after
+			_, err = deltaReader.ExpectedHash()
+			if err != nil {
+				return verificationError(err)
+			}
+		}
+		err = octodiff.ApplyDeltaInPlace(basisFile, deltaReader)
+		if err != nil || opts.SkipVerification {
+			return err
+		}
+		_, err = basisFile.Seek(0, io.SeekStart)
 		if err != nil {
 			return err
 		}
-		// we can't buffer IO for basisFile because it seeks all over the place
-		newFileOutputStream := bufio.NewWriter(newFile)
+		return verificationError(octodiff.VerifyNewFile(bufio.NewReaderSize(basisFile, 4*1024*1024), deltaReader))
+	}
+
+	// the new file is written to a temporary file, which only replaces newFilePath once it has been verified
+	newFile, err := cmdutil.CreateOutput(newFilePath)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = newFile.Close() }()         // discards the temporary file if we don't get as far as committing it
+	newFileOnDisk, _ := newFile.(*atomicfile.File) // nil when writing to standard output
+
+	if opts.PreservePermissions && newFileOnDisk != nil {
+		basisFileInfo, err := basisFile.Stat()
+		if err != nil {
+			return err
+		}
+		err = newFileOnDisk.Chmod(basisFileInfo.Mode().Perm())
+		if err != nil {
+			return err
+		}
+	}
+
+	var reverseDeltaFile cmdutil.Output
+	if opts.ReverseDeltaFile != "" {
+		reverseDeltaFile, err = cmdutil.CreateOutput(opts.ReverseDeltaFile)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = reverseDeltaFile.Close() }()
+	}
+
+	// the new file is hashed as it's written where possible, rather than reading it all back afterwards
+	verifyWhileWriting := !opts.SkipVerification && reverseDeltaFile == nil
+
+	// we can't buffer IO for basisFile because it seeks all over the place
+	newFileOutputStream := bufio.NewWriter(newFile)
+	if reverseDeltaFile == nil {
+		options := octodiff.ApplyDeltaOptions{Parallelism: opts.Parallelism, Verify: verifyWhileWriting}
+		if newFileOnDisk != nil {
+			// given the file itself, ApplyDeltaWithOptions decides how best to write to it
+			err = octodiff.ApplyDeltaWithOptions(basisFile, deltaReader, newFileOnDisk.File, options)
+		} else {
+			err = octodiff.ApplyDeltaWithOptions(basisFile, deltaReader, newFileOutputStream, options)
+		}
+	} else {
+		err = applyDeltaAndReverse(basisFile, deltaReader, newFileOutputStream, reverseDeltaFile)
+	}
+	if err != nil {
+		return verificationError(err)
+	}
+	err = newFileOutputStream.Flush()
+	if err != nil {
+		return err
+	}
+
+	if !opts.SkipVerification && !verifyWhileWriting {
+		// read the temporary file back to verify the hash
+		_, err = newFileOnDisk.Seek(0, io.SeekStart)
+		if err != nil {
+			return err
+		}
+		err = octodiff.VerifyNewFile(bufio.NewReaderSize(newFileOnDisk, 4*1024*1024), deltaReader)
+		if err != nil {
+			return verificationError(err)
+		}
+	}
+
+	err = newFile.Commit()
+	if err != nil || reverseDeltaFile == nil {
+		return err
+	}
+	return reverseDeltaFile.Commit()
+}
+
+// newDeltaReader creates a reader for a delta in `format`, which reports progress through the delta file
+func newDeltaReader(format string, deltaFile io.Reader, progressReporter octodiff.ProgressReporter) (octodiff.DeltaReader, error) {
+	deltaFileLength := int64(-1) // unknown when reading from standard input
+	if file, ok := deltaFile.(*os.File); ok {
+		deltaFileInfo, err := file.Stat()
+		if err != nil {
+			return nil, err
+		}
+		deltaFileLength = deltaFileInfo.Size()
+	}
 
-		err = octodiff.ApplyDelta(
-			basisFile,
-			deltaReader,
-			newFileOutputStream)
+	deltaFileStream := bufio.NewReader(deltaFile)
+	switch format {
+	case "vcdiff":
+		deltaReader := octodiff.NewVcdiffDeltaReader(deltaFileStream)
+		deltaReader.ProgressReporter = progressReporter
+		deltaReader.DeltaLength = deltaFileLength
+		return deltaReader, nil
+	case "rdiff":
+		deltaReader := octodiff.NewRdiffDeltaReader(deltaFileStream)
+		deltaReader.ProgressReporter = progressReporter
+		deltaReader.DeltaLength = deltaFileLength
+		return deltaReader, nil
+	}
+	deltaReader := octodiff.NewBinaryDeltaReader(deltaFileStream)
+	deltaReader.ProgressReporter = progressReporter
+	deltaReader.DeltaLength = deltaFileLength
+	return deltaReader, nil
+}
+
+// archivePatchRun applies a delta between unpacked archives to the unpacked basis file, and packs the result into the new file
+func archivePatchRun(opts *PatchOptions, basisFilePath string, deltaFilePath string, newFilePath string, progressReporter octodiff.ProgressReporter) error {
+	unpackedBasisFile, cleanup, err := cmdutil.UnpackArchiveToTempFile(basisFilePath, io.Discard) // delta has already warned about anything kept as it is
+	if errors.Is(err, os.ErrNotExist) {
+		return errors.New("basis file does not exist or could not be opened")
+	}
+	if err != nil {
+		return err
+	}
+	defer cleanup()
 
-		flushErr := newFileOutputStream.Flush()
-		_ = newFile.Close()
-		if flushErr != nil {
-			return flushErr
+	deltaFile, err := cmdutil.OpenInput(deltaFilePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return errors.New("delta file does not exist or could not be opened")
+	}
+	if err != nil {
+		return err
+	}
+	defer func() { _ = deltaFile.Close() }()
+	deltaReader, err := newDeltaReader(opts.Format, deltaFile, progressReporter)
+	if err != nil {
+		return err
+	}
+
+	// the unpacked new file is verified as it's written, before any of it is packed into the new file
+	unpackedNewFile, err := os.CreateTemp("", "octodiff-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = unpackedNewFile.Close()
+		_ = os.Remove(unpackedNewFile.Name())
+	}()
+	unpackedNewFileStream := bufio.NewWriter(unpackedNewFile)
+	err = octodiff.ApplyDeltaWithOptions(unpackedBasisFile, deltaReader, unpackedNewFileStream, octodiff.ApplyDeltaOptions{Verify: !opts.SkipVerification})
+	if err != nil {
+		return verificationError(err)
+	}
+	err = unpackedNewFileStream.Flush()
+	if err != nil {
+		return err
+	}
+	_, err = unpackedNewFile.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	newFile, err := cmdutil.CreateOutput(newFilePath)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = newFile.Close() }()
+	if newFileOnDisk, ok := newFile.(*atomicfile.File); ok && opts.PreservePermissions {
+		basisFileInfo, err := os.Stat(basisFilePath)
+		if err != nil {
+			return err
 		}
+		err = newFileOnDisk.Chmod(basisFileInfo.Mode().Perm())
 		if err != nil {
 			return err
 		}
 	}
 
-	if opts.SkipVerification {
-		return nil
+	newFileStream := bufio.NewWriter(newFile)
+	err = octodiff.PackArchive(bufio.NewReaderSize(unpackedNewFile, 4*1024*1024), newFileStream)
+	if err != nil {
+		return err
+	}
+	err = newFileStream.Flush()
+	if err != nil {
+		return err
+	}
+	return newFile.Commit()
+}
+
+// verificationError adds a hint to the error returned when a delta has nothing to verify against
+func verificationError(err error) error {
+	if errors.Is(err, octodiff.ErrNoExpectedHash) {
+		return fmt.Errorf("%w; use --skip-verification for deltas from other tools", err)
+	}
+	var verificationErr *octodiff.VerificationError
+	if errors.As(err, &verificationErr) {
+		return fmt.Errorf("%w (expected %x, got %x)", err, verificationErr.ExpectedHash, verificationErr.ActualHash)
+	}
+	return err
+}
+
+func directoryPatchRun(opts *PatchOptions, basisDirectoryPath string, deltaFilePath string) error {
+	if opts.NewFile != "" {
+		return errors.New("--recursive updates the basis directory in place, so a new file can't be specified")
+	}
+	if opts.Format != "octodiff" {
+		return errors.New("--recursive deltas can only be in the octodiff format")
+	}
+	if opts.ReverseDeltaFile != "" || opts.Parallelism > 1 || opts.InPlace || opts.Archive || opts.PreservePermissions || opts.Progress || opts.SkipVerification {
+		return errors.New("--recursive can't be combined with --reverse-delta, --parallelism, --in-place, --archive, --preserve-permissions, --progress or --skip-verification")
 	}
 
-	// re-open the file to verify the hash
+	basisDirectoryInfo, err := os.Stat(basisDirectoryPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return errors.New("basis directory does not exist or could not be opened")
+	}
+	if err != nil {
+		return err
+	}
+	if !basisDirectoryInfo.IsDir() {
+		return fmt.Errorf("%s is not a directory", basisDirectoryPath)
+	}
 
-	newFileRead, err := os.Open(newFilePath)
+	deltaFile, err := cmdutil.OpenInput(deltaFilePath)
 	if errors.Is(err, os.ErrNotExist) {
-		return errors.New("new file does not exist or could not be opened for verification")
+		return errors.New("delta file does not exist or could not be opened")
+	}
+	if err != nil {
+		return err
 	}
+	defer func() { _ = deltaFile.Close() }()
+
+	deltaReader := octodiff.NewDirectoryDeltaReader(bufio.NewReader(deltaFile))
+	return verificationError(octodiff.ApplyDirectoryDelta(basisDirectoryPath, deltaReader))
+}
+
+func applyDeltaAndReverse(basisFile io.ReadSeeker, deltaReader octodiff.DeltaReader, output io.Writer, reverseDeltaFile io.Writer) error {
+	reverseDeltaFileWriter := bufio.NewWriter(reverseDeltaFile)
+	err := octodiff.ApplyDeltaAndReverse(basisFile, deltaReader, output, octodiff.NewBinaryDeltaWriter(reverseDeltaFileWriter))
 	if err != nil {
 		return err
 	}
-	defer func() { _ = newFileRead.Close() }()
-	newFileReadStream := bufio.NewReaderSize(newFileRead, 4*1024*1024)
-	return octodiff.VerifyNewFile(newFileReadStream, deltaReader)
+	return reverseDeltaFileWriter.Flush()
 }
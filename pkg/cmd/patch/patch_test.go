@@ -0,0 +1,67 @@
+package patch_test
+
+import (
+	"github.com/OctopusDeploy/go-octodiff/pkg/cmd/root"
+	"github.com/OctopusDeploy/go-octodiff/pkg/octodiff"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func runOctodiff(args ...string) error {
+	cmd := root.NewCmdRoot()
+	cmd.SetArgs(args)
+	cmd.SetOut(io.Discard)
+	cmd.SetErr(io.Discard)
+	return cmd.Execute()
+}
+
+func writeFile(t *testing.T, path string, contents []byte) {
+	err := os.WriteFile(path, contents, 0600)
+	assert.Nil(t, err)
+}
+
+// TestPatchVerifiesFilesOnDisk patches one file on disk into another, verifying by default, which on Linux copies
+// with copy_file_range and reads the new file back to hash it
+func TestPatchVerifiesFilesOnDisk(t *testing.T) {
+	dir := t.TempDir()
+	basisFilePath := filepath.Join(dir, "basis")
+	signatureFilePath := filepath.Join(dir, "basis.octosig")
+	newFilePath := filepath.Join(dir, "new")
+	deltaFilePath := filepath.Join(dir, "new.octodelta")
+	patchedFilePath := filepath.Join(dir, "patched")
+
+	basis := make([]byte, 4*1024*1024)
+	rand.New(rand.NewSource(1)).Read(basis)
+	newFile := append([]byte(nil), basis...)
+	copy(newFile[1024*1024:], "changed in the middle")
+	newFile = append(newFile, "and added at the end"...)
+	writeFile(t, basisFilePath, basis)
+	writeFile(t, newFilePath, newFile)
+
+	err := runOctodiff("signature", basisFilePath, signatureFilePath)
+	assert.Nil(t, err)
+	err = runOctodiff("delta", signatureFilePath, newFilePath, deltaFilePath)
+	assert.Nil(t, err)
+
+	err = runOctodiff("patch", basisFilePath, deltaFilePath, patchedFilePath)
+	assert.Nil(t, err)
+	patched, err := os.ReadFile(patchedFilePath)
+	assert.Nil(t, err)
+	assert.Equal(t, newFile, patched)
+
+	// the basis file has changed since the signature was taken, in a part which the delta copies
+	basis[3*1024*1024]++
+	writeFile(t, basisFilePath, basis)
+	err = os.Remove(patchedFilePath)
+	assert.Nil(t, err)
+
+	err = runOctodiff("patch", basisFilePath, deltaFilePath, patchedFilePath)
+	assert.ErrorIs(t, err, octodiff.ErrVerificationFailed)
+	assert.Equal(t, root.ExitCodeVerificationFailed, root.ExitCode(err))
+	_, err = os.Stat(patchedFilePath)
+	assert.True(t, os.IsNotExist(err), "a new file that fails verification shouldn't be kept")
+}
@@ -0,0 +1,33 @@
+package root
+
+import (
+	"errors"
+	"github.com/OctopusDeploy/go-octodiff/pkg/octodiff"
+)
+
+// Exit codes for the different kinds of failure, so scripts can tell them apart without parsing messages
+const (
+	ExitCodeError                = 1 // anything not covered below, such as bad arguments or missing files
+	ExitCodeCorruptInput         = 2 // a signature or delta file is damaged, or doesn't match the basis file
+	ExitCodeUnsupportedInput     = 3 // a signature or delta file uses a newer format or an algorithm we don't support
+	ExitCodeVerificationFailed   = 4 // the patched file didn't have the hash recorded in the delta
+	ExitCodeResourceLimitReached = 5 // applying the delta would have gone beyond one of its limits
+)
+
+// ExitCode returns the process exit code to use when a command fails with `err`
+func ExitCode(err error) int {
+	var copyOutOfRange *octodiff.CopyOutOfRangeError
+	switch {
+	case errors.Is(err, octodiff.ErrVerificationFailed):
+		return ExitCodeVerificationFailed
+	case errors.Is(err, octodiff.ErrLimitExceeded):
+		return ExitCodeResourceLimitReached
+	case errors.Is(err, octodiff.ErrUnsupportedVersion), errors.Is(err, octodiff.ErrUnsupportedAlgorithm):
+		return ExitCodeUnsupportedInput
+	case errors.Is(err, octodiff.ErrCorruptHeader), errors.Is(err, octodiff.ErrCorruptCommand),
+		errors.Is(err, octodiff.ErrTruncatedChunk), errors.As(err, &copyOutOfRange):
+		return ExitCodeCorruptInput
+	default:
+		return ExitCodeError
+	}
+}
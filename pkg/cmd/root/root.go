@@ -1,10 +1,12 @@
 package root
 
 import (
+	"github.com/OctopusDeploy/go-octodiff/pkg/cmd/compose"
 	"github.com/OctopusDeploy/go-octodiff/pkg/cmd/delta"
 	"github.com/OctopusDeploy/go-octodiff/pkg/cmd/explaindelta"
 	"github.com/OctopusDeploy/go-octodiff/pkg/cmd/patch"
 	"github.com/OctopusDeploy/go-octodiff/pkg/cmd/signature"
+	"github.com/OctopusDeploy/go-octodiff/pkg/cmd/validatedelta"
 	"github.com/spf13/cobra"
 )
 
@@ -17,6 +19,8 @@ func NewCmdRoot() *cobra.Command {
 	cmd.AddCommand(delta.NewCmdDelta())
 	cmd.AddCommand(patch.NewCmdPatch())
 	cmd.AddCommand(explaindelta.NewCmdExplainDelta())
+	cmd.AddCommand(compose.NewCmdCompose())
+	cmd.AddCommand(validatedelta.NewCmdValidateDelta())
 
 	return cmd
 }
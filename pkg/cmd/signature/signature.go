@@ -4,26 +4,35 @@ import (
 	"bufio"
 	"errors"
 	"fmt"
+	"github.com/OctopusDeploy/go-octodiff/pkg/cmd/cmdutil"
 	"github.com/OctopusDeploy/go-octodiff/pkg/octodiff"
 	"github.com/spf13/cobra"
 	"io"
 	"os"
+	"path/filepath"
 )
 
 type SignatureOptions struct {
-	BasisFile     string
-	SignatureFile string
-	ChunkSize     int
-	Progress      bool
+	BasisFile      string
+	SignatureFile  string
+	ChunkSize      int
+	Format         string
+	Recursive      bool
+	Archive        bool
+	Progress       bool
+	ProgressFormat string
 }
 
 func NewCmdSignature() *cobra.Command {
 	signatureOpts := &SignatureOptions{}
 	cmd := &cobra.Command{
 		Use:     "signature <basis-file> [<signature-file>]",
-		Long:    "Given a basis file, creates a signature file",
+		Long:    "Given a basis file, creates a signature file. Use - to read the basis file from standard input or write the signature to standard output. With --recursive, the basis is a directory, and the signature describes every file in it.",
 		Aliases: []string{"sig"},
 		RunE: func(c *cobra.Command, args []string) error {
+			if c.Flags().Changed("progress-format") {
+				signatureOpts.Progress = true
+			}
 			// pick up positional arguments if not explicitly specified using --basis-file and --signature-file
 			argOffset := 0
 			if signatureOpts.BasisFile == "" && len(args) > argOffset {
@@ -47,7 +56,12 @@ func NewCmdSignature() *cobra.Command {
 		fmt.Sprintf("Maximum bytes per chunk. Defaults to %d. Min of %d, max of %d.",
 			octodiff.SignatureDefaultChunkSize, octodiff.SignatureMinimumChunkSize, octodiff.SignatureMaximumChunkSize))
 
+	flags.StringVarP(&signatureOpts.Format, "format", "", "octodiff", "The format to write the signature in; either octodiff or rdiff.")
+
+	flags.BoolVarP(&signatureOpts.Recursive, "recursive", "r", false, "The basis is a directory; create one signature file covering every file and directory in it.")
+	flags.BoolVarP(&signatureOpts.Archive, "archive", "", false, "The basis is a zip or gzip file; sign its unpacked contents, so that deltas created with delta --archive only include what changed inside it. See delta --archive for which archives can be unpacked.")
 	flags.BoolVarP(&signatureOpts.Progress, "progress", "", false, "Whether progress should be written to stdout")
+	flags.StringVarP(&signatureOpts.ProgressFormat, "progress-format", "", cmdutil.ProgressFormatAuto, cmdutil.ProgressFormatUsage)
 
 	return cmd
 }
@@ -59,8 +73,54 @@ func signatureRun(opts *SignatureOptions) error {
 	if basisFilePath == "" {
 		return errors.New("No basis file was specified")
 	}
+	if opts.Format != "octodiff" && opts.Format != "rdiff" {
+		return fmt.Errorf("unknown signature format %s", opts.Format)
+	}
+
+	if opts.Recursive {
+		if basisFilePath == cmdutil.StdioPath {
+			return errors.New("--recursive needs a directory, which can't be read from standard input")
+		}
+		if opts.Format != "octodiff" {
+			return errors.New("--recursive signatures can only be written in the octodiff format")
+		}
+		basisFilePath = filepath.Clean(basisFilePath) // so that "dir/" gets a signature file next to it, not inside it
+	}
+	if opts.Archive {
+		if opts.Recursive {
+			return errors.New("--archive can't be combined with --recursive")
+		}
+		if basisFilePath == cmdutil.StdioPath {
+			return errors.New("--archive needs to seek within the basis file, so it can't be read from standard input")
+		}
+	}
+
+	if signatureFilePath == "" {
+		if basisFilePath == cmdutil.StdioPath {
+			return errors.New("no signature file was specified; use - to write it to standard output")
+		}
+		signatureFilePath = basisFilePath + ".octosig"
+	} else {
+		// mkdir_p on the signature file path directory? why?
+	}
+	if signatureFilePath == cmdutil.StdioPath && opts.Progress {
+		return errors.New("progress can't be written to standard output along with the signature")
+	}
+	if opts.Recursive {
+		return directorySignatureRun(opts, basisFilePath, signatureFilePath)
+	}
 
-	basisFile, err := os.Open(basisFilePath)
+	var basisFile io.ReadCloser
+	var err error
+	if opts.Archive {
+		var cleanup func()
+		basisFile, cleanup, err = cmdutil.UnpackArchiveToTempFile(basisFilePath, os.Stderr)
+		if err == nil {
+			defer cleanup()
+		}
+	} else {
+		basisFile, err = cmdutil.OpenInput(basisFilePath)
+	}
 	if errors.Is(err, os.ErrNotExist) {
 		return errors.New("basis file does not exist or could not be opened")
 	}
@@ -69,26 +129,29 @@ func signatureRun(opts *SignatureOptions) error {
 	}
 	defer func() { _ = basisFile.Close() }()
 
-	basisFileInfo, err := basisFile.Stat()
-	if err != nil {
-		return err
+	basisFileLength := int64(-1) // unknown when reading from standard input
+	if file, ok := basisFile.(*os.File); ok {
+		basisFileInfo, err := file.Stat()
+		if err != nil {
+			return err
+		}
+		basisFileLength = basisFileInfo.Size()
 	}
 
-	if signatureFilePath == "" {
-		signatureFilePath = basisFilePath + ".octosig"
-	} else {
-		// mkdir_p on the signature file path directory? why?
-	}
-
-	signatureFile, err := os.Create(signatureFilePath)
+	signatureFile, err := cmdutil.CreateOutput(signatureFilePath)
 	if err != nil {
 		return err
 	}
 	defer func() { _ = signatureFile.Close() }()
 
-	signatureBuilder := octodiff.NewSignatureBuilder()
+	var progressReporter = octodiff.NopProgressReporter()
 	if opts.Progress {
-		signatureBuilder.ProgressReporter = octodiff.NewStdoutProgressReporter()
+		var finishProgress func()
+		progressReporter, finishProgress, err = cmdutil.NewProgressReporter(opts.ProgressFormat)
+		if err != nil {
+			return err
+		}
+		defer finishProgress()
 	}
 
 	// For a 4.5 gb ISO file on my dev laptop (March 2023) C# octodiff takes 16 seconds to generate a signature.
@@ -97,9 +160,64 @@ func signatureRun(opts *SignatureOptions) error {
 	// bufio on the writer is even more important. The above 8-second signature generation takes 40 seconds without it, but unlike the reader, write buffer size doesn't affect things noticeably
 	var basisFileReader io.Reader = bufio.NewReaderSize(basisFile, 4*1024*1024)
 	var signatureFileWriter = bufio.NewWriter(signatureFile)
-	err = signatureBuilder.Build(basisFileReader, basisFileInfo.Size(), signatureFileWriter)
+	if opts.Format == "rdiff" {
+		signatureBuilder := octodiff.NewRdiffSignatureBuilder()
+		signatureBuilder.BlockLength = opts.ChunkSize
+		signatureBuilder.ProgressReporter = progressReporter
+		err = signatureBuilder.Build(basisFileReader, basisFileLength, signatureFileWriter)
+	} else {
+		signatureBuilder := octodiff.NewSignatureBuilder()
+		signatureBuilder.ChunkSize = opts.ChunkSize
+		signatureBuilder.ProgressReporter = progressReporter
+		err = signatureBuilder.Build(basisFileReader, basisFileLength, signatureFileWriter)
+	}
+	if err != nil {
+		return err
+	}
+	err = signatureFileWriter.Flush()
+	if err != nil {
+		return err
+	}
+	return signatureFile.Commit()
+}
+
+func directorySignatureRun(opts *SignatureOptions, basisDirectoryPath string, signatureFilePath string) error {
+	basisDirectoryInfo, err := os.Stat(basisDirectoryPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return errors.New("basis directory does not exist or could not be opened")
+	}
+	if err != nil {
+		return err
+	}
+	if !basisDirectoryInfo.IsDir() {
+		return fmt.Errorf("%s is not a directory", basisDirectoryPath)
+	}
+
+	signatureFile, err := cmdutil.CreateOutput(signatureFilePath)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = signatureFile.Close() }()
+
+	signatureBuilder := octodiff.NewDirectorySignatureBuilder()
+	signatureBuilder.SignatureBuilder.ChunkSize = opts.ChunkSize
+	if opts.Progress {
+		var finishProgress func()
+		signatureBuilder.ProgressReporter, finishProgress, err = cmdutil.NewProgressReporter(opts.ProgressFormat)
+		if err != nil {
+			return err
+		}
+		defer finishProgress()
+	}
+
+	signatureFileWriter := bufio.NewWriter(signatureFile)
+	err = signatureBuilder.Build(os.DirFS(basisDirectoryPath), signatureFileWriter)
+	if err != nil {
+		return err
+	}
+	err = signatureFileWriter.Flush()
 	if err != nil {
 		return err
 	}
-	return signatureFileWriter.Flush()
+	return signatureFile.Commit()
 }
@@ -0,0 +1,82 @@
+package validatedelta
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/OctopusDeploy/go-octodiff/pkg/cmd/cmdutil"
+	"github.com/OctopusDeploy/go-octodiff/pkg/octodiff"
+	"github.com/spf13/cobra"
+	"io"
+	"os"
+)
+
+type ValidateDeltaOptions struct {
+	DeltaFile string
+	BasisFile string
+}
+
+func NewCmdValidateDelta() *cobra.Command {
+	validateOpts := &ValidateDeltaOptions{}
+	cmd := &cobra.Command{
+		Use:  "validate-delta <delta-file> [--basis <basis-file>]",
+		Long: "Checks that a delta file is well-formed without applying it. If a basis file is given, also checks that all copy commands are within it. Use - to read the delta from standard input.",
+		RunE: func(c *cobra.Command, args []string) error {
+			// pick up positional arguments if not explicitly specified using --delta-file
+			if validateOpts.DeltaFile == "" && len(args) > 0 {
+				validateOpts.DeltaFile = args[0]
+			}
+			return validateDeltaRun(c, validateOpts)
+		},
+	}
+
+	flags := cmd.Flags()
+
+	flags.StringVarP(&validateOpts.DeltaFile, "delta-file", "", "", "The delta file to validate.")
+	flags.StringVarP(&validateOpts.BasisFile, "basis", "", "", "The basis file the delta will be applied to.")
+
+	return cmd
+}
+
+func validateDeltaRun(cmd *cobra.Command, opts *ValidateDeltaOptions) error {
+	deltaFilePath := opts.DeltaFile
+	if deltaFilePath == "" {
+		return errors.New("no delta file was specified")
+	}
+
+	basisFileLength := int64(-1)
+	if opts.BasisFile != "" {
+		basisFileInfo, err := os.Stat(opts.BasisFile)
+		if errors.Is(err, os.ErrNotExist) {
+			return errors.New("basis file does not exist or could not be opened")
+		}
+		if err != nil {
+			return err
+		}
+		basisFileLength = basisFileInfo.Size()
+	}
+
+	deltaFile, err := cmdutil.OpenInput(deltaFilePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return errors.New("delta file does not exist or could not be opened")
+	}
+	if err != nil {
+		return err
+	}
+	defer func() { _ = deltaFile.Close() }()
+
+	var deltaFileReader io.Reader = bufio.NewReaderSize(deltaFile, 4*1024*1024)
+	result, err := octodiff.ValidateDelta(deltaFileReader, basisFileLength)
+	if err != nil {
+		return err
+	}
+
+	for _, problem := range result.Problems {
+		cmd.Println(problem.String())
+	}
+	if !result.Valid() {
+		return fmt.Errorf("the delta file is not valid; %d problem(s) found", len(result.Problems))
+	}
+	cmd.Printf("The delta file is valid: %d commands producing %d bytes\n", result.CommandCount, result.NewFileLength)
+	return nil
+}
@@ -0,0 +1,440 @@
+// Package deltaserver serves files over HTTP with delta support, so that clients holding an older version of a file
+// only need to download what has changed.
+//
+// A GET request for a path serves the file in full, the same as http.FileServer, and a GET request with the query
+// parameter "signature" serves an octodiff signature of it. To fetch a file as a delta, a client POSTs the signature of
+// the version it already has. The response is either an octodiff delta (with the content type DeltaContentType) which
+// turns that version into the current one, or the whole file if that would be smaller.
+//
+// Whole files carry an ETag derived from the size and modification time of the file, and signatures and deltas carry
+// ETags derived from that, and GET requests with a matching If-None-Match header get 304 Not Modified. So a client
+// can make a HEAD request to find out whether the version it has is current before POSTing its signature. Signatures
+// and deltas are kept in a cache, so serving the same version of a file to many clients only builds each one once.
+// Deltas too big to keep in memory are written to a temporary file while they're served, and aren't cached.
+package deltaserver
+
+import (
+	"bufio"
+	"bytes"
+	"container/list"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/OctopusDeploy/go-octodiff/pkg/octodiff"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+const (
+	// SignatureContentType is the content type of signatures, whether served by the handler or POSTed to it
+	SignatureContentType = "application/vnd.octodiff.signature"
+	// DeltaContentType is the content type of delta responses. Anything else is the whole file
+	DeltaContentType = "application/vnd.octodiff.delta"
+)
+
+// DefaultCacheSize is the default number of bytes of signatures and deltas the handler keeps in memory
+const DefaultCacheSize = 64 * 1024 * 1024
+
+// DefaultMaxSignatureSize is the default limit on the size of POSTed signatures, which is enough for a file of around
+// 10GB with the default chunk size
+const DefaultMaxSignatureSize = 128 * 1024 * 1024
+
+// DefaultMaxDeltaMemory is the default size beyond which a delta is written to a temporary file rather than kept in memory
+const DefaultMaxDeltaMemory = 8 * 1024 * 1024
+
+// Handler is an http.Handler serving the files in a file system. See the package documentation for the requests it accepts.
+// Paths are taken from the URL path, so use http.StripPrefix to serve the files from somewhere other than the root.
+type Handler struct {
+	// CacheSize is how many bytes of signatures and deltas are kept in memory; nothing is cached if zero
+	CacheSize int64
+
+	// MaxSignatureSize is the largest signature a client may POST. Larger ones get 413 Request Entity Too Large
+	MaxSignatureSize int64
+
+	// MaxDeltaMemory is how big a delta can get before it's written to a temporary file rather than kept in memory
+	MaxDeltaMemory int64
+
+	// MaxConcurrentBuilds is how many signatures and deltas may be built at once, by default the number of CPUs.
+	// Other requests for them wait their turn. Zero means no limit
+	MaxConcurrentBuilds int
+
+	fsys     fs.FS
+	initOnce sync.Once
+	cache    *cache
+	builds   chan struct{} // holds a value for each build in progress; nil if there's no limit
+}
+
+// NewHandler creates a Handler serving the files in `fsys`, such as os.DirFS(directory)
+func NewHandler(fsys fs.FS) *Handler {
+	return &Handler{
+		CacheSize:           DefaultCacheSize,
+		MaxSignatureSize:    DefaultMaxSignatureSize,
+		MaxDeltaMemory:      DefaultMaxDeltaMemory,
+		MaxConcurrentBuilds: runtime.GOMAXPROCS(0),
+		fsys:                fsys,
+	}
+}
+
+var _ http.Handler = (*Handler)(nil)
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.initOnce.Do(func() {
+		h.cache = newCache(h.CacheSize)
+		if h.MaxConcurrentBuilds > 0 {
+			h.builds = make(chan struct{}, h.MaxConcurrentBuilds)
+		}
+	})
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead && r.Method != http.MethodPost {
+		w.Header().Set("Allow", "GET, HEAD, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	if path == "" || !fs.ValidPath(path) {
+		http.NotFound(w, r)
+		return
+	}
+	file, err := h.open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer func() { _ = file.Close() }()
+
+	switch {
+	case r.Method == http.MethodPost:
+		h.serveDelta(w, r, file)
+	case r.URL.Query().Has("signature"):
+		h.serveSignature(w, r, file)
+	default:
+		w.Header().Set("ETag", file.etag)
+		http.ServeContent(w, r, path, file.info.ModTime(), file)
+	}
+}
+
+func (h *Handler) serveSignature(w http.ResponseWriter, r *http.Request, file *servedFile) {
+	etag := file.tag("signature")
+	if notModified(w, r, etag) {
+		return
+	}
+
+	signature, ok := h.cache.get(etag)
+	if !ok {
+		finishBuild, ok := h.startBuild(w, r)
+		if !ok {
+			return
+		}
+		var output bytes.Buffer
+		err := octodiff.NewSignatureBuilder().Build(file, file.info.Size(), &output)
+		finishBuild()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		signature = output.Bytes()
+		h.cache.put(etag, signature)
+	}
+	w.Header().Set("Content-Type", SignatureContentType)
+	w.Header().Set("ETag", etag)
+	writeBody(w, r, signature)
+}
+
+func (h *Handler) serveDelta(w http.ResponseWriter, r *http.Request, file *servedFile) {
+	signature, err := io.ReadAll(http.MaxBytesReader(w, r.Body, h.MaxSignatureSize))
+	var maxBytesError *http.MaxBytesError
+	if errors.As(err, &maxBytesError) {
+		http.Error(w, fmt.Sprintf("the signature is larger than the limit of %d bytes", h.MaxSignatureSize), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !bytes.HasPrefix(signature, octodiff.BinarySignatureHeader) {
+		// the delta builder accepts rdiff signatures too, but clients sending them expect rdiff deltas, which aren't served
+		http.Error(w, "the request body is not an octodiff signature", http.StatusBadRequest)
+		return
+	}
+	signatureHash := sha1.Sum(signature)
+	etag := file.tag("delta-" + hex.EncodeToString(signatureHash[:]))
+
+	delta, ok := h.cache.get(etag)
+	if !ok {
+		finishBuild, ok := h.startBuild(w, r)
+		if !ok {
+			return
+		}
+		spooled, err := h.buildDelta(file, signature)
+		finishBuild()
+		var formatError *octodiff.FormatError
+		if errors.As(err, &formatError) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if spooled != nil {
+			defer spooled.close()
+			if spooled.file != nil { // too big to keep, so it's served from the temporary file
+				serveSpooledDelta(w, etag, spooled)
+				return
+			}
+			delta = spooled.memory.Bytes()
+		}
+		h.cache.put(etag, delta)
+	}
+
+	if delta == nil { // the whole file is smaller than the delta
+		_, err = file.Seek(0, io.SeekStart)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("ETag", file.etag)
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Length", fmt.Sprint(file.info.Size()))
+		_, _ = io.Copy(w, file)
+		return
+	}
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Content-Type", DeltaContentType)
+	writeBody(w, r, delta)
+}
+
+func serveSpooledDelta(w http.ResponseWriter, etag string, spooled *spooledDelta) {
+	delta, err := spooled.reader()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Content-Type", DeltaContentType)
+	w.Header().Set("Content-Length", fmt.Sprint(spooled.size))
+	_, _ = io.Copy(w, delta)
+}
+
+// startBuild waits until fewer than MaxConcurrentBuilds signatures and deltas are being built, and returns a func to
+// call once this one is done. It returns false if the client goes away first, in which case there's no one to reply to.
+func (h *Handler) startBuild(w http.ResponseWriter, r *http.Request) (func(), bool) {
+	if h.builds == nil {
+		return func() {}, true
+	}
+	select {
+	case h.builds <- struct{}{}:
+		return func() { <-h.builds }, true
+	case <-r.Context().Done():
+		http.Error(w, r.Context().Err().Error(), http.StatusServiceUnavailable)
+		return nil, false
+	}
+}
+
+// errDeltaTooLarge stops building a delta once it's as big as the file it describes
+var errDeltaTooLarge = errors.New("the delta is larger than the new file")
+
+// buildDelta returns a delta which turns the file `signature` was made from into `file`,
+// or nil if it would be at least as big as `file` itself. The caller must close the delta.
+func (h *Handler) buildDelta(file *servedFile, signature []byte) (*spooledDelta, error) {
+	output := &spooledDelta{maxMemory: h.MaxDeltaMemory, limit: file.info.Size()}
+	err := octodiff.NewDeltaBuilder().Build(file, file.info.Size(), bytes.NewReader(signature), int64(len(signature)), octodiff.NewBinaryDeltaWriter(output))
+	if err != nil {
+		output.close()
+		if errors.Is(err, errDeltaTooLarge) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return output, nil
+}
+
+// spooledDelta holds a delta as it's built: in memory up to `maxMemory` bytes, and in a temporary file beyond that.
+// Writes fail with errDeltaTooLarge once it would be `limit` bytes or more.
+type spooledDelta struct {
+	memory     bytes.Buffer
+	file       *os.File // nil while the delta is in memory
+	fileStream *bufio.Writer
+	size       int64
+	maxMemory  int64
+	limit      int64
+}
+
+func (d *spooledDelta) Write(p []byte) (int, error) {
+	if d.size+int64(len(p)) >= d.limit {
+		return 0, errDeltaTooLarge
+	}
+	if d.file == nil && d.size+int64(len(p)) > d.maxMemory {
+		file, err := os.CreateTemp("", "octodiff-delta-*.tmp")
+		if err != nil {
+			return 0, err
+		}
+		d.file = file
+		d.fileStream = bufio.NewWriter(file)
+		_, err = d.fileStream.Write(d.memory.Bytes())
+		if err != nil {
+			return 0, err
+		}
+		d.memory = bytes.Buffer{}
+	}
+	var n int
+	var err error
+	if d.file != nil {
+		n, err = d.fileStream.Write(p)
+	} else {
+		n, err = d.memory.Write(p)
+	}
+	d.size += int64(n)
+	return n, err
+}
+
+// reader returns the delta written to the temporary file, from the start
+func (d *spooledDelta) reader() (io.Reader, error) {
+	err := d.fileStream.Flush()
+	if err != nil {
+		return nil, err
+	}
+	_, err = d.file.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+	return bufio.NewReader(d.file), nil
+}
+
+// close removes the temporary file, if there is one
+func (d *spooledDelta) close() {
+	if d.file != nil {
+		_ = d.file.Close()
+		_ = os.Remove(d.file.Name())
+	}
+}
+
+// notModified writes a 304 Not Modified response if the request's If-None-Match header includes `etag`
+func notModified(w http.ResponseWriter, r *http.Request, etag string) bool {
+	for _, match := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		match = strings.TrimPrefix(strings.TrimSpace(match), "W/") // If-None-Match uses weak comparison
+		if match == etag || match == "*" {
+			w.Header().Set("ETag", etag)
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
+
+func writeBody(w http.ResponseWriter, r *http.Request, body []byte) {
+	w.Header().Set("Content-Length", fmt.Sprint(len(body)))
+	if r.Method != http.MethodHead {
+		_, _ = w.Write(body)
+	}
+}
+
+// servedFile is a file being served, which can be seeked for the delta builder and http.ServeContent
+type servedFile struct {
+	io.ReadSeeker
+	io.Closer
+	info fs.FileInfo
+	etag string
+}
+
+func (h *Handler) open(path string) (*servedFile, error) {
+	file, err := h.fsys.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err == nil && !info.Mode().IsRegular() {
+		err = fs.ErrNotExist // directories and the like aren't served
+	}
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+
+	served := &servedFile{
+		Closer: file,
+		info:   info,
+		etag:   fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size()),
+	}
+	if seeker, ok := file.(io.ReadSeeker); ok {
+		served.ReadSeeker = seeker
+		return served, nil
+	}
+	contents, err := io.ReadAll(file) // file systems such as embed.FS return files which can't seek
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	served.ReadSeeker = bytes.NewReader(contents)
+	return served, nil
+}
+
+// tag returns the ETag of something derived from the file, such as its signature
+func (f *servedFile) tag(kind string) string {
+	return strings.TrimSuffix(f.etag, `"`) + "-" + kind + `"`
+}
+
+// cache holds signatures and deltas by ETag, discarding the least recently used once they add up to more than maxSize bytes.
+// A nil value is cached for a delta which would be bigger than the file.
+type cache struct {
+	mu      sync.Mutex
+	maxSize int64
+	size    int64
+	entries map[string]*list.Element
+	order   *list.List // most recently used first
+}
+
+type cacheEntry struct {
+	key   string
+	value []byte
+}
+
+func newCache(maxSize int64) *cache {
+	return &cache{
+		maxSize: maxSize,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+func (c *cache) get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+	return element.Value.(*cacheEntry).value, true
+}
+
+func (c *cache) put(key string, value []byte) {
+	size := int64(len(key) + len(value))
+	if size > c.maxSize {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.entries[key]; ok { // built twice by concurrent requests
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&cacheEntry{key: key, value: value})
+	c.size += size
+	for c.size > c.maxSize {
+		oldest := c.order.Remove(c.order.Back()).(*cacheEntry)
+		delete(c.entries, oldest.key)
+		c.size -= int64(len(oldest.key) + len(oldest.value))
+	}
+}
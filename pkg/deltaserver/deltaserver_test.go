@@ -0,0 +1,249 @@
+package deltaserver_test
+
+import (
+	"bytes"
+	"github.com/OctopusDeploy/go-octodiff/pkg/deltaserver"
+	"github.com/OctopusDeploy/go-octodiff/pkg/octodiff"
+	"github.com/OctopusDeploy/go-octodiff/pkg/test"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func buildSignature(input []byte) []byte {
+	var output bytes.Buffer
+	err := octodiff.NewSignatureBuilder().Build(bytes.NewReader(input), int64(len(input)), &output)
+	if err != nil {
+		panic(err) // should never fail under tests
+	}
+	return output.Bytes()
+}
+
+// versions returns two versions of a file, where the second has a few changes
+func versions() ([]byte, []byte) {
+	v1 := test.GenerateTestData(256 * 1024)
+	v2 := append([]byte(nil), v1...)
+	v2[100] = 0xaa
+	v2[100000] = 0xab
+	v2 = append(v2, "and some more at the end"...)
+	return v1, v2
+}
+
+func newServer(t *testing.T, handler *deltaserver.Handler) *httptest.Server {
+	server := httptest.NewServer(http.StripPrefix("/files", handler))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func newTestServer(t *testing.T, files fstest.MapFS) *httptest.Server {
+	return newServer(t, deltaserver.NewHandler(files))
+}
+
+func request(t *testing.T, method string, url string, body []byte, header http.Header) (*http.Response, []byte) {
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	assert.Nil(t, err)
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	resp, err := http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	defer func() { _ = resp.Body.Close() }()
+	responseBody, err := io.ReadAll(resp.Body)
+	assert.Nil(t, err)
+	return resp, responseBody
+}
+
+func TestFetchingADelta(t *testing.T) {
+	v1, v2 := versions()
+	server := newTestServer(t, fstest.MapFS{"app/package.bin": {Data: v2, ModTime: time.Unix(1700000000, 0)}})
+
+	head, _ := request(t, http.MethodHead, server.URL+"/files/app/package.bin", nil, nil)
+	assert.Equal(t, http.StatusOK, head.StatusCode)
+	etag := head.Header.Get("ETag")
+	assert.NotEmpty(t, etag)
+
+	resp, delta := request(t, http.MethodPost, server.URL+"/files/app/package.bin", buildSignature(v1), nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, deltaserver.DeltaContentType, resp.Header.Get("Content-Type"))
+	assert.NotEmpty(t, resp.Header.Get("ETag"))
+	assert.NotEqual(t, etag, resp.Header.Get("ETag"))
+	assert.Less(t, len(delta), 10*1024)
+
+	var output bytes.Buffer
+	err := octodiff.ApplyDeltaWithOptions(bytes.NewReader(v1), octodiff.NewBinaryDeltaReader(bytes.NewReader(delta)), &output, octodiff.ApplyDeltaOptions{Verify: true})
+	assert.Nil(t, err)
+	assert.Equal(t, v2, output.Bytes())
+}
+
+func TestFetchingTheWholeFileWhenItIsSmallerThanADelta(t *testing.T) {
+	_, v2 := versions()
+	server := newTestServer(t, fstest.MapFS{"package.bin": {Data: v2}})
+	head, _ := request(t, http.MethodHead, server.URL+"/files/package.bin", nil, nil)
+
+	// nothing in common with the current version
+	resp, body := request(t, http.MethodPost, server.URL+"/files/package.bin", buildSignature(bytes.Repeat([]byte{0xaa}, 4096)), nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/octet-stream", resp.Header.Get("Content-Type"))
+	assert.Equal(t, head.Header.Get("ETag"), resp.Header.Get("ETag"))
+	assert.Equal(t, v2, body)
+
+	// the same goes for a client with no version at all
+	resp, body = request(t, http.MethodPost, server.URL+"/files/package.bin", buildSignature(nil), nil)
+	assert.Equal(t, "application/octet-stream", resp.Header.Get("Content-Type"))
+	assert.Equal(t, v2, body)
+}
+
+func TestGettingFilesAndSignatures(t *testing.T) {
+	_, v2 := versions()
+	server := newTestServer(t, fstest.MapFS{"package.bin": {Data: v2}})
+
+	resp, body := request(t, http.MethodGet, server.URL+"/files/package.bin", nil, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, v2, body)
+	fileETag := resp.Header.Get("ETag")
+
+	resp, body = request(t, http.MethodGet, server.URL+"/files/package.bin?signature", nil, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, deltaserver.SignatureContentType, resp.Header.Get("Content-Type"))
+	assert.Equal(t, buildSignature(v2), body)
+	signatureETag := resp.Header.Get("ETag")
+	assert.NotEqual(t, fileETag, signatureETag)
+
+	resp, body = request(t, http.MethodGet, server.URL+"/files/package.bin", nil, http.Header{"If-None-Match": {fileETag}})
+	assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+	assert.Empty(t, body)
+
+	resp, body = request(t, http.MethodGet, server.URL+"/files/package.bin?signature", nil, http.Header{"If-None-Match": {`"other", W/` + signatureETag}})
+	assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+	assert.Empty(t, body)
+}
+
+func TestETagsChangeWithTheFile(t *testing.T) {
+	v1, v2 := versions()
+	files := fstest.MapFS{"package.bin": {Data: v1, ModTime: time.Unix(1700000000, 0)}}
+	server := newTestServer(t, files)
+
+	resp, _ := request(t, http.MethodGet, server.URL+"/files/package.bin", nil, nil)
+	etag := resp.Header.Get("ETag")
+
+	files["package.bin"] = &fstest.MapFile{Data: v2, ModTime: time.Unix(1700000100, 0)}
+	resp, body := request(t, http.MethodGet, server.URL+"/files/package.bin", nil, http.Header{"If-None-Match": {etag}})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NotEqual(t, etag, resp.Header.Get("ETag"))
+	assert.Equal(t, v2, body)
+}
+
+func TestSignaturesAndDeltasAreCached(t *testing.T) {
+	v1, v2 := versions()
+	modTime := time.Unix(1700000000, 0)
+	files := fstest.MapFS{"package.bin": {Data: v2, ModTime: modTime}}
+	cached := deltaserver.NewHandler(files)
+	cachedServer := newServer(t, cached)
+	uncached := deltaserver.NewHandler(files)
+	uncached.CacheSize = 0
+	uncachedServer := newServer(t, uncached)
+
+	_, signature := request(t, http.MethodGet, cachedServer.URL+"/files/package.bin?signature", nil, nil)
+	_, delta := request(t, http.MethodPost, cachedServer.URL+"/files/package.bin", buildSignature(v1), nil)
+
+	// change the file without changing its ETag, which only the uncached handler notices
+	changed := append([]byte(nil), v2...)
+	changed[200000]++
+	files["package.bin"] = &fstest.MapFile{Data: changed, ModTime: modTime}
+
+	_, body := request(t, http.MethodGet, cachedServer.URL+"/files/package.bin?signature", nil, nil)
+	assert.Equal(t, signature, body)
+	_, body = request(t, http.MethodPost, cachedServer.URL+"/files/package.bin", buildSignature(v1), nil)
+	assert.Equal(t, delta, body)
+
+	_, body = request(t, http.MethodGet, uncachedServer.URL+"/files/package.bin?signature", nil, nil)
+	assert.NotEqual(t, signature, body)
+	_, body = request(t, http.MethodPost, uncachedServer.URL+"/files/package.bin", buildSignature(v1), nil)
+	assert.NotEqual(t, delta, body)
+}
+
+func TestBadRequests(t *testing.T) {
+	_, v2 := versions()
+	handler := deltaserver.NewHandler(fstest.MapFS{"dir/package.bin": {Data: v2}})
+	handler.MaxSignatureSize = 1024
+	server := newServer(t, handler)
+
+	resp, _ := request(t, http.MethodGet, server.URL+"/files/dir/missing.bin", nil, nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	resp, _ = request(t, http.MethodGet, server.URL+"/files/dir", nil, nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	resp, _ = request(t, http.MethodGet, server.URL+"/files/dir/../dir/package.bin", nil, nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp, _ = request(t, http.MethodPut, server.URL+"/files/dir/package.bin", nil, nil)
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+	assert.Equal(t, "GET, HEAD, POST", resp.Header.Get("Allow"))
+
+	resp, body := request(t, http.MethodPost, server.URL+"/files/dir/package.bin", []byte("not a signature"), nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.True(t, strings.Contains(string(body), "signature"), string(body))
+
+	// deltas built from rdiff signatures can't be applied by clients, so they aren't built
+	var rdiffSignature bytes.Buffer
+	err := octodiff.NewRdiffSignatureBuilder().Build(bytes.NewReader(v2[:512]), 512, &rdiffSignature)
+	assert.Nil(t, err)
+	resp, body = request(t, http.MethodPost, server.URL+"/files/dir/package.bin", rdiffSignature.Bytes(), nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.True(t, strings.Contains(string(body), "octodiff signature"), string(body))
+
+	resp, _ = request(t, http.MethodPost, server.URL+"/files/dir/package.bin", buildSignature(v2), nil)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+}
+
+func TestLargeDeltasAreSpooledToDisk(t *testing.T) {
+	v1, v2 := versions()
+	files := fstest.MapFS{"package.bin": {Data: v2}}
+	inMemory := newTestServer(t, files)
+	handler := deltaserver.NewHandler(files)
+	handler.MaxDeltaMemory = 16
+	spooled := newServer(t, handler)
+	tempDir := t.TempDir()
+	t.Setenv("TMPDIR", tempDir)
+
+	_, expected := request(t, http.MethodPost, inMemory.URL+"/files/package.bin", buildSignature(v1), nil)
+	resp, delta := request(t, http.MethodPost, spooled.URL+"/files/package.bin", buildSignature(v1), nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, deltaserver.DeltaContentType, resp.Header.Get("Content-Type"))
+	assert.Equal(t, expected, delta)
+
+	tempFiles, err := os.ReadDir(tempDir)
+	assert.Nil(t, err)
+	assert.Empty(t, tempFiles)
+}
+
+func TestConcurrentBuildsAreLimited(t *testing.T) {
+	v1, v2 := versions()
+	handler := deltaserver.NewHandler(fstest.MapFS{"package.bin": {Data: v2}})
+	handler.CacheSize = 0
+	handler.MaxConcurrentBuilds = 1
+	server := newServer(t, handler)
+	signature := buildSignature(v1)
+
+	var wg sync.WaitGroup
+	deltas := make([][]byte, 8)
+	for i := range deltas {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			resp, delta := request(t, http.MethodPost, server.URL+"/files/package.bin", signature, nil)
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+			deltas[i] = delta
+		}(i)
+	}
+	wg.Wait()
+	for _, delta := range deltas[1:] {
+		assert.Equal(t, deltas[0], delta)
+	}
+}
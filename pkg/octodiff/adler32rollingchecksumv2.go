@@ -29,6 +29,8 @@ func (_ *Adler32RollingChecksumV2) Rotate(checksum uint32, remove byte, add byte
 	b := checksum >> 16 & 0xffff
 	a := checksum & 0xffff
 
+	// a subtraction below zero wraps around rather than staying modulo 65521, so this doesn't always agree with
+	// Calculate. It is kept as it was ported, as it must roll the same way as the C# library; see testdata/csharp
 	a = ((a - uint32(remove) + uint32(add)) % modulus) & 0xffff
 	b = ((b - (uint32(chunkSize) * uint32(remove)) + a - 1) % modulus) & 0xffff
 
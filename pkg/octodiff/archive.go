@@ -0,0 +1,487 @@
+package octodiff
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"sort"
+)
+
+// Archives such as zip and gzip files compress their contents, so a small change to what's in them changes most of
+// the bytes of the archive, and a delta between two versions finds little in common. UnpackArchive gets around this
+// by writing an unpacked form of the archive, in which each compressed stream is replaced by its uncompressed
+// contents along with how to compress them again. Signatures and deltas of unpacked archives work like those of any
+// other file, but find the unchanged contents, and PackArchive turns the result back into a byte-identical archive.
+//
+// A stream is only unpacked if compressing its contents again reproduces it exactly, which in practice means it was
+// compressed by Go's compress/flate (as archive/zip and compress/gzip use) at one of its standard levels. Archives made
+// by zlib-based tools, such as Info-ZIP's zip, GNU gzip and .NET's ZipFile, compress differently, so their streams
+// can't be unpacked; UnpackArchive reports how many streams it had to keep, so callers can say so. Anything that isn't
+// unpacked, including files which aren't archives we recognise, is kept as it is, so at worst the delta is the same as
+// a plain one. Tar files aren't compressed, so there's nothing to unpack, and .tar.gz files are unpacked as gzip files.
+//
+// The unpacked form starts with BinaryUnpackedArchiveHeader, followed by segments which are joined to make the archive:
+//
+//	'R'  int64 length, then that many bytes to copy as they are
+//	'Z'  int8 compress/flate level, uint32 CRC-32 of the compressed stream, int64 compressed length,
+//	     int64 uncompressed length, then that many bytes to compress
+//
+// All integers are little-endian.
+const (
+	archiveSegmentRaw     = byte('R')
+	archiveSegmentDeflate = byte('Z')
+)
+
+// archiveCompressionLevels are the compress/flate levels we try to reproduce a stream with, most likely first;
+// archive/zip uses level 5, and compress/gzip defaults to 6
+var archiveCompressionLevels = []int{5, 6, 9, 1, 2, 3, 4, 7, 8, flate.NoCompression, flate.HuffmanOnly}
+
+var (
+	zipLocalFileHeader = []byte("PK\x03\x04")
+	gzipHeader         = []byte{0x1f, 0x8b, 0x08}
+)
+
+// archiveSegment is part of an archive which is either copied as it is, or compressed from its unpacked contents
+type archiveSegment struct {
+	offset             int64 // in the archive
+	length             int64
+	deflated           bool
+	level              int    // for deflated segments, the compress/flate level which reproduces them
+	crc                uint32 // for deflated segments, the CRC-32 of the compressed stream
+	uncompressedLength int64
+}
+
+// ArchiveSummary says what UnpackArchive did with the compressed streams in an archive
+type ArchiveSummary struct {
+	UnpackedStreams int // streams replaced by their uncompressed contents
+	KeptStreams     int // streams kept compressed, as compressing their contents again didn't reproduce them
+}
+
+// UnpackArchive writes the unpacked form of `archive` to `output`. See PackArchive for turning it back again.
+func UnpackArchive(archive io.ReaderAt, archiveLength int64, output io.Writer) (ArchiveSummary, error) {
+	summary := ArchiveSummary{}
+	segments, err := planArchive(archive, archiveLength, &summary)
+	if err != nil {
+		return summary, err
+	}
+	return summary, writeUnpackedArchive(archive, segments, output)
+}
+
+func writeUnpackedArchive(archive io.ReaderAt, segments []archiveSegment, output io.Writer) error {
+	_, err := output.Write(BinaryUnpackedArchiveHeader)
+	if err != nil {
+		return err
+	}
+	_, err = output.Write(BinaryVersion)
+	if err != nil {
+		return err
+	}
+	_, err = output.Write(BinaryEndOfMetadata)
+	if err != nil {
+		return err
+	}
+
+	for _, segment := range segments {
+		section := io.NewSectionReader(archive, segment.offset, segment.length)
+		if !segment.deflated {
+			_, err = output.Write([]byte{archiveSegmentRaw})
+			if err != nil {
+				return err
+			}
+			err = binary.Write(output, binary.LittleEndian, segment.length)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(output, section)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		_, err = output.Write([]byte{archiveSegmentDeflate})
+		if err != nil {
+			return err
+		}
+		err = binary.Write(output, binary.LittleEndian, struct {
+			Level              int8
+			CRC                uint32
+			CompressedLength   int64
+			UncompressedLength int64
+		}{int8(segment.level), segment.crc, segment.length, segment.uncompressedLength})
+		if err != nil {
+			return err
+		}
+		n, err := io.Copy(output, flate.NewReader(bufio.NewReader(section)))
+		if err != nil {
+			return err
+		}
+		if n != segment.uncompressedLength {
+			return errors.New("the archive changed while it was being unpacked")
+		}
+	}
+	return nil
+}
+
+// planArchive splits `archive` into segments, unpacking every compressed stream which we can reproduce, and counts
+// the streams in `summary`. Problems finding the streams just mean there's less to unpack, so only errors from reading
+// the archive are returned.
+func planArchive(archive io.ReaderAt, archiveLength int64, summary *ArchiveSummary) ([]archiveSegment, error) {
+	magic := make([]byte, 4)
+	n, err := archive.ReadAt(magic, 0)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	magic = magic[:n]
+	err = nil
+
+	compressors := &archiveCompressors{}
+	var streams []archiveSegment
+	switch {
+	case bytes.HasPrefix(magic, zipLocalFileHeader):
+		streams, summary.KeptStreams, err = findZipStreams(archive, archiveLength, compressors)
+	case bytes.HasPrefix(magic, gzipHeader):
+		streams, summary.KeptStreams, err = findGzipStreams(archive, archiveLength, compressors)
+	}
+	if err != nil {
+		return nil, err
+	}
+	summary.UnpackedStreams = len(streams)
+
+	// fill the gaps between the streams with raw segments
+	var segments []archiveSegment
+	offset := int64(0)
+	for _, stream := range streams {
+		if stream.offset > offset {
+			segments = append(segments, archiveSegment{offset: offset, length: stream.offset - offset})
+		}
+		segments = append(segments, stream)
+		offset = stream.offset + stream.length
+	}
+	if offset < archiveLength {
+		segments = append(segments, archiveSegment{offset: offset, length: archiveLength - offset})
+	}
+	return segments, nil
+}
+
+// findZipStreams returns the compressed entries of a zip file which can be unpacked, in order, and how many others
+// there are. Entries are found using the zip's central directory, so any data descriptors after them are left as they are.
+func findZipStreams(archive io.ReaderAt, archiveLength int64, compressors *archiveCompressors) ([]archiveSegment, int, error) {
+	// newer versions of Go return a usable reader along with an error for zips with unsafe paths,
+	// which doesn't matter here as nothing is extracted
+	reader, _ := zip.NewReader(archive, archiveLength)
+	if reader == nil {
+		return nil, 0, nil
+	}
+
+	var streams []archiveSegment
+	kept := 0
+	for _, file := range reader.File {
+		if file.Method != zip.Deflate || file.CompressedSize64 == 0 {
+			continue
+		}
+		offset, err := file.DataOffset()
+		if err != nil || offset < 0 || file.CompressedSize64 > uint64(archiveLength-offset) {
+			kept++
+			continue
+		}
+		stream, err := findDeflateStream(archive, offset, int64(file.CompressedSize64), compressors)
+		if err != nil {
+			return nil, 0, err
+		}
+		if stream.deflated && stream.length == int64(file.CompressedSize64) {
+			streams = append(streams, stream)
+		} else {
+			kept++
+		}
+	}
+
+	// the central directory can list entries in any order, and can even list them twice
+	sort.Slice(streams, func(i, j int) bool { return streams[i].offset < streams[j].offset })
+	result := streams[:0]
+	end := int64(0)
+	for _, stream := range streams {
+		if stream.offset >= end {
+			result = append(result, stream)
+			end = stream.offset + stream.length
+		}
+	}
+	return result, kept, nil
+}
+
+// findGzipStreams returns the compressed data of each member of a gzip file which can be unpacked,
+// and how many others there are
+func findGzipStreams(archive io.ReaderAt, archiveLength int64, compressors *archiveCompressors) ([]archiveSegment, int, error) {
+	const gzipTrailerLength = 8 // CRC-32 and length of the uncompressed data
+
+	var streams []archiveSegment
+	kept := 0
+	offset := int64(0)
+	for offset < archiveLength {
+		headerLength, ok := gzipHeaderLength(io.NewSectionReader(archive, offset, archiveLength-offset))
+		if !ok {
+			break // anything after the last member is left as it is
+		}
+		stream, err := findDeflateStream(archive, offset+headerLength, archiveLength-offset-headerLength, compressors)
+		if err != nil {
+			return nil, 0, err
+		}
+		if stream.length < 0 {
+			kept++
+			break // we can't tell where the member ends
+		}
+		if stream.deflated {
+			streams = append(streams, stream)
+		} else {
+			kept++
+		}
+		offset = stream.offset + stream.length + gzipTrailerLength
+	}
+	return streams, kept, nil
+}
+
+// gzipHeaderLength reads the header of a gzip member, as described in RFC 1952, and returns its length
+func gzipHeaderLength(input io.Reader) (int64, bool) {
+	const (
+		flagHeaderCRC = 1 << 1
+		flagExtra     = 1 << 2
+		flagName      = 1 << 3
+		flagComment   = 1 << 4
+	)
+	counter := &countingReader{reader: input}
+	reader := bufio.NewReader(counter)
+	header := make([]byte, 10)
+	_, err := io.ReadFull(reader, header)
+	if err != nil || !bytes.HasPrefix(header, gzipHeader) {
+		return 0, false
+	}
+	flags := header[3]
+	if flags&flagExtra != 0 {
+		var extraLength uint16
+		err = binary.Read(reader, binary.LittleEndian, &extraLength)
+		if err == nil {
+			_, err = reader.Discard(int(extraLength))
+		}
+	}
+	if err == nil && flags&flagName != 0 {
+		_, err = reader.ReadBytes(0)
+	}
+	if err == nil && flags&flagComment != 0 {
+		_, err = reader.ReadBytes(0)
+	}
+	if err == nil && flags&flagHeaderCRC != 0 {
+		_, err = reader.Discard(2)
+	}
+	if err != nil {
+		return 0, false
+	}
+	return counter.offset - int64(reader.Buffered()), true
+}
+
+// findDeflateStream decompresses the deflate stream at `offset`, which is no more than `maxLength` bytes long,
+// and compresses it again to see whether it can be unpacked. The stream's length is -1 if it couldn't be decompressed.
+func findDeflateStream(archive io.ReaderAt, offset int64, maxLength int64, compressors *archiveCompressors) (archiveSegment, error) {
+	stream := archiveSegment{offset: offset, length: -1}
+
+	// flate reads one byte at a time from an io.ByteReader, so it doesn't read past the end of the stream
+	counter := &countingReader{reader: io.NewSectionReader(archive, offset, maxLength)}
+	input := bufio.NewReader(counter)
+	decompressor := flate.NewReader(input)
+
+	candidates := make([]*matchingWriter, len(archiveCompressionLevels))
+	writers := make([]*flate.Writer, len(archiveCompressionLevels))
+	for i, level := range archiveCompressionLevels {
+		candidates[i] = &matchingWriter{expected: bufio.NewReader(io.NewSectionReader(archive, offset, maxLength))}
+		writers[i] = compressors.writer(level, candidates[i])
+	}
+
+	buffer := make([]byte, 32*1024)
+	for {
+		n, err := decompressor.Read(buffer)
+		if n > 0 {
+			stream.uncompressedLength += int64(n)
+			for i, writer := range writers {
+				if !candidates[i].mismatched {
+					_, _ = writer.Write(buffer[:n]) // a mismatch is recorded in the candidate
+				}
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		var corrupt flate.CorruptInputError
+		if errors.As(err, &corrupt) || err == io.ErrUnexpectedEOF {
+			return stream, nil // not a deflate stream we can read, so it's left as it is
+		}
+		if err != nil {
+			return stream, err
+		}
+	}
+	stream.length = counter.offset - int64(input.Buffered())
+
+	for i, writer := range writers {
+		candidate := candidates[i]
+		if !candidate.mismatched && writer.Close() == nil && !candidate.mismatched && candidate.written == stream.length {
+			stream.deflated = true
+			stream.level = archiveCompressionLevels[i]
+			break
+		}
+	}
+	if stream.deflated {
+		checksum := crc32.NewIEEE()
+		_, err := io.Copy(checksum, io.NewSectionReader(archive, offset, stream.length))
+		if err != nil {
+			return stream, err
+		}
+		stream.crc = checksum.Sum32()
+	}
+	return stream, nil
+}
+
+// archiveCompressors keeps a compress/flate writer for each level, as they're expensive to create
+type archiveCompressors struct {
+	writers map[int]*flate.Writer
+}
+
+func (c *archiveCompressors) writer(level int, output io.Writer) *flate.Writer {
+	if c.writers == nil {
+		c.writers = map[int]*flate.Writer{}
+	}
+	writer := c.writers[level]
+	if writer == nil {
+		writer, _ = flate.NewWriter(output, level) // the levels are all valid
+		c.writers[level] = writer
+		return writer
+	}
+	writer.Reset(output)
+	return writer
+}
+
+var errArchiveMismatch = errors.New("the compressed stream doesn't match the archive")
+
+// matchingWriter checks that what's written to it is the same as `expected`
+type matchingWriter struct {
+	expected   io.Reader
+	written    int64
+	mismatched bool
+	buffer     []byte
+}
+
+func (w *matchingWriter) Write(p []byte) (int, error) {
+	if w.mismatched {
+		return 0, errArchiveMismatch
+	}
+	if cap(w.buffer) < len(p) {
+		w.buffer = make([]byte, len(p))
+	}
+	expected := w.buffer[:len(p)]
+	_, err := io.ReadFull(w.expected, expected)
+	if err != nil || !bytes.Equal(p, expected) {
+		w.mismatched = true
+		return 0, errArchiveMismatch
+	}
+	w.written += int64(len(p))
+	return len(p), nil
+}
+
+// PackArchive turns the unpacked form of an archive written by UnpackArchive back into the archive.
+// Each compressed stream is checked against the length and CRC-32 of the original, in case this version of Go
+// compresses differently from the one which unpacked it.
+func PackArchive(unpacked io.Reader, output io.Writer) error {
+	const file = "unpacked archive"
+	input := &countingReader{reader: unpacked}
+
+	header := make([]byte, len(BinaryUnpackedArchiveHeader)+len(BinaryVersion)+len(BinaryEndOfMetadata))
+	_, err := io.ReadFull(input, header)
+	if err != nil {
+		return truncatedOrError(err, file, input.offset)
+	}
+	if !bytes.HasPrefix(header, BinaryUnpackedArchiveHeader) {
+		return newFormatError(file, 0, ErrCorruptHeader, "the unpacked archive appears to be corrupt")
+	}
+	versionOffset := len(BinaryUnpackedArchiveHeader)
+	if !bytes.Equal(header[versionOffset:versionOffset+len(BinaryVersion)], BinaryVersion) {
+		return newFormatError(file, int64(versionOffset), ErrUnsupportedVersion, "the unpacked archive uses a newer file format than this program can handle")
+	}
+	if !bytes.HasSuffix(header, BinaryEndOfMetadata) {
+		return newFormatError(file, int64(versionOffset+len(BinaryVersion)), ErrCorruptHeader, "the unpacked archive appears to be corrupt")
+	}
+
+	var compressors archiveCompressors
+	for {
+		segmentOffset := input.offset
+		var segmentType [1]byte
+		_, err = io.ReadFull(input, segmentType[:])
+		if err == io.EOF {
+			return nil // there's nothing more, so we've read every segment
+		}
+		if err != nil {
+			return truncatedOrError(err, file, input.offset)
+		}
+
+		switch segmentType[0] {
+		case archiveSegmentRaw:
+			var length int64
+			err = binary.Read(input, binary.LittleEndian, &length)
+			if err != nil {
+				return truncatedOrError(err, file, input.offset)
+			}
+			if length < 0 {
+				return newFormatError(file, segmentOffset, ErrCorruptCommand, "the unpacked archive appears to be corrupt; a segment has a negative length")
+			}
+			_, err = io.CopyN(output, input, length)
+			if err != nil {
+				return truncatedOrError(err, file, input.offset)
+			}
+
+		case archiveSegmentDeflate:
+			var fields struct {
+				Level              int8
+				CRC                uint32
+				CompressedLength   int64
+				UncompressedLength int64
+			}
+			err = binary.Read(input, binary.LittleEndian, &fields)
+			if err != nil {
+				return truncatedOrError(err, file, input.offset)
+			}
+			if fields.Level < flate.HuffmanOnly || fields.Level > flate.BestCompression || fields.UncompressedLength < 0 {
+				return newFormatError(file, segmentOffset, ErrCorruptCommand, "the unpacked archive appears to be corrupt; a compressed segment has level %d and length %d", fields.Level, fields.UncompressedLength)
+			}
+			checksum := crc32.NewIEEE()
+			counter := &countingWriter{writer: io.MultiWriter(output, checksum)}
+			writer := compressors.writer(int(fields.Level), counter)
+			_, err = io.CopyN(writer, input, fields.UncompressedLength)
+			if err != nil {
+				return truncatedOrError(err, file, input.offset)
+			}
+			err = writer.Close()
+			if err != nil {
+				return err
+			}
+			if counter.offset != fields.CompressedLength || checksum.Sum32() != fields.CRC {
+				return newFormatError(file, segmentOffset, ErrVerificationFailed, "compressing the unpacked archive again didn't reproduce the original; it may have been unpacked by a different version of Go")
+			}
+
+		default:
+			return newFormatError(file, segmentOffset, ErrCorruptCommand, "the unpacked archive appears to be corrupt; found unknown segment type 0x%02x", segmentType[0])
+		}
+	}
+}
+
+// countingWriter keeps track of how much has been written
+type countingWriter struct {
+	writer io.Writer
+	offset int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.writer.Write(p)
+	w.offset += int64(n)
+	return n, err
+}
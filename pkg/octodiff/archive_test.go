@@ -0,0 +1,221 @@
+package octodiff_test
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"github.com/OctopusDeploy/go-octodiff/pkg/octodiff"
+	"github.com/OctopusDeploy/go-octodiff/pkg/test"
+	"github.com/stretchr/testify/assert"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// textFile returns `lines` lines of made up text, which compresses like the config files and scripts in a package
+func textFile(lines int) []byte {
+	words := []string{"octopus", "deploy", "release", "package", "tentacle", "worker", "variable", "step", "runbook", "tenant"}
+	random := rand.New(rand.NewSource(42))
+	var result bytes.Buffer
+	for i := 0; i < lines; i++ {
+		for j := 0; j < 8; j++ {
+			result.WriteString(words[random.Intn(len(words))])
+			result.WriteByte(' ')
+		}
+		result.WriteByte('\n')
+	}
+	return result.Bytes()
+}
+
+type archiveEntry struct {
+	name string
+	data []byte
+}
+
+func buildZip(entries ...archiveEntry) []byte {
+	var output bytes.Buffer
+	writer := zip.NewWriter(&output)
+	for _, entry := range entries {
+		file, err := writer.Create(entry.name)
+		if err != nil {
+			panic(err) // should never fail under tests
+		}
+		_, err = file.Write(entry.data)
+		if err != nil {
+			panic(err) // should never fail under tests
+		}
+	}
+	err := writer.Close()
+	if err != nil {
+		panic(err) // should never fail under tests
+	}
+	return output.Bytes()
+}
+
+func buildTarGz(entries ...archiveEntry) []byte {
+	var output bytes.Buffer
+	compressor := gzip.NewWriter(&output)
+	writer := tar.NewWriter(compressor)
+	for _, entry := range entries {
+		err := writer.WriteHeader(&tar.Header{Name: entry.name, Mode: 0644, Size: int64(len(entry.data))})
+		if err != nil {
+			panic(err) // should never fail under tests
+		}
+		_, err = writer.Write(entry.data)
+		if err != nil {
+			panic(err) // should never fail under tests
+		}
+	}
+	err := writer.Close()
+	if err == nil {
+		err = compressor.Close()
+	}
+	if err != nil {
+		panic(err) // should never fail under tests
+	}
+	return output.Bytes()
+}
+
+func unpackArchive(archive []byte) []byte {
+	unpacked, _ := unpackArchiveWithSummary(archive)
+	return unpacked
+}
+
+func unpackArchiveWithSummary(archive []byte) ([]byte, octodiff.ArchiveSummary) {
+	var output bytes.Buffer
+	summary, err := octodiff.UnpackArchive(bytes.NewReader(archive), int64(len(archive)), &output)
+	if err != nil {
+		panic(err) // should never fail under tests
+	}
+	return output.Bytes(), summary
+}
+
+func packArchive(unpacked []byte) ([]byte, error) {
+	var output bytes.Buffer
+	err := octodiff.PackArchive(bytes.NewReader(unpacked), &output)
+	return output.Bytes(), err
+}
+
+// changedArchives returns a basis and new archive built by `build`, with a word changed near the start of one of the files
+func changedArchives(build func(...archiveEntry) []byte) ([]byte, []byte) {
+	script := textFile(500)
+	config := textFile(20000)
+	changedConfig := append([]byte(nil), config...)
+	copy(changedConfig[1000:], "changed")
+	return build(archiveEntry{"deploy.sh", script}, archiveEntry{"config.txt", config}),
+		build(archiveEntry{"deploy.sh", script}, archiveEntry{"config.txt", changedConfig})
+}
+
+func TestArchiveDeltas(t *testing.T) {
+	archiveTypes := map[string]func(...archiveEntry) []byte{"zip": buildZip, "tar.gz": buildTarGz}
+	for name, build := range archiveTypes {
+		t.Run(name, func(t *testing.T) {
+			basis, newFile := changedArchives(build)
+			plainDelta := buildDelta(newFile, buildSignature(basis))
+
+			unpackedBasis := unpackArchive(basis)
+			unpackedNewFile := unpackArchive(newFile)
+			archiveDelta := buildDelta(unpackedNewFile, buildSignature(unpackedBasis))
+			// everything compressed after the change is different, but only a couple of chunks of what was compressed
+			assert.Less(t, len(archiveDelta)*10, len(plainDelta))
+
+			unpackedResult, err := applyAndVerify(unpackedBasis, octodiff.NewBinaryDeltaReader(bytes.NewReader(archiveDelta)))
+			assert.Nil(t, err)
+			result, err := packArchive(unpackedResult)
+			assert.Nil(t, err)
+			assert.True(t, bytes.Equal(newFile, result))
+		})
+	}
+}
+
+func TestArchiveUnpacksCompressedStreams(t *testing.T) {
+	archive := buildZip(archiveEntry{"config.txt", textFile(2000)})
+
+	unpacked, summary := unpackArchiveWithSummary(archive)
+	assert.Greater(t, len(unpacked), 2*len(archive))
+	assert.Equal(t, octodiff.ArchiveSummary{UnpackedStreams: 1}, summary)
+
+	result, err := packArchive(unpacked)
+	assert.Nil(t, err)
+	assert.True(t, bytes.Equal(archive, result))
+}
+
+func TestArchiveKeepsStreamsItCantReproduce(t *testing.T) {
+	// flushing part way through makes a stream which compressing the contents in one go won't reproduce
+	var archive bytes.Buffer
+	compressor := gzip.NewWriter(&archive)
+	_, _ = compressor.Write(textFile(100))
+	_ = compressor.Flush()
+	_, _ = compressor.Write(textFile(100))
+	_ = compressor.Close()
+
+	unpacked, summary := unpackArchiveWithSummary(archive.Bytes())
+	assert.Equal(t, len("OCTOARCHIVE\x01>>>R")+8+archive.Len(), len(unpacked))
+	assert.Equal(t, octodiff.ArchiveSummary{KeptStreams: 1}, summary)
+
+	result, err := packArchive(unpacked)
+	assert.Nil(t, err)
+	assert.True(t, bytes.Equal(archive.Bytes(), result))
+}
+
+func TestArchiveKeepsStreamsFromZlibTools(t *testing.T) {
+	// see testdata/archives/README.md for how these were made
+	tests := []struct {
+		file    string
+		streams int
+	}{
+		{"zip-cli.zip", 2},
+		{"gzip-cli.txt.gz", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			archive, err := os.ReadFile(filepath.Join("testdata", "archives", tt.file))
+			assert.Nil(t, err)
+
+			unpacked, summary := unpackArchiveWithSummary(archive)
+			assert.Equal(t, octodiff.ArchiveSummary{KeptStreams: tt.streams}, summary)
+
+			result, err := packArchive(unpacked)
+			assert.Nil(t, err)
+			assert.True(t, bytes.Equal(archive, result))
+		})
+	}
+}
+
+func TestArchiveLeavesOtherFilesAlone(t *testing.T) {
+	for _, file := range [][]byte{test.TestData(), nil, []byte("PK\x03\x04 but not a zip"), {0x1f, 0x8b, 0x08}} {
+		unpacked := unpackArchive(file)
+		if len(file) > 0 {
+			assert.Equal(t, "OCTOARCHIVE\x01>>>R", string(unpacked[:16]))
+		}
+
+		result, err := packArchive(unpacked)
+		assert.Nil(t, err)
+		assert.True(t, bytes.Equal(file, result))
+	}
+}
+
+func TestPackArchiveChecksTheCompressedStreams(t *testing.T) {
+	unpacked := unpackArchive(buildZip(archiveEntry{"config.txt", textFile(100)}))
+	// change the CRC-32 of the first compressed stream
+	index := bytes.IndexByte(unpacked[15:], 'Z') + 15
+	unpacked[index+2] ^= 0xff
+
+	_, err := packArchive(unpacked)
+	assert.ErrorIs(t, err, octodiff.ErrVerificationFailed)
+}
+
+func TestPackArchiveRejectsCorruptFiles(t *testing.T) {
+	unpacked := unpackArchive(buildZip(archiveEntry{"config.txt", textFile(100)}))
+
+	_, err := packArchive(unpacked[:len(unpacked)-1])
+	assert.ErrorIs(t, err, octodiff.ErrTruncatedChunk)
+
+	_, err = packArchive(append(append([]byte(nil), unpacked...), 'X'))
+	assert.ErrorIs(t, err, octodiff.ErrCorruptCommand)
+
+	_, err = packArchive([]byte("OCTODELTAXX\x01>>>"))
+	assert.ErrorIs(t, err, octodiff.ErrCorruptHeader)
+}
@@ -3,8 +3,6 @@ package octodiff
 import (
 	"bytes"
 	"encoding/binary"
-	"errors"
-	"fmt"
 	"io"
 )
 
@@ -22,19 +20,30 @@ type DeltaReader interface {
 }
 
 type BinaryDeltaReader struct {
-	input io.Reader
+	input *countingReader
 
 	expectedHash    []byte
 	hashAlgorithm   HashAlgorithm
 	hasReadMetadata bool
 
 	ProgressReporter ProgressReporter
+	// DeltaLength is the size of the delta, which progress through it is reported against; -1 or zero if it isn't known
+	DeltaLength int64
+
+	// Limits, if set, are checked against each command before it is applied. See DeltaLimits
+	Limits *DeltaLimits
+
+	// BufferSize is the size of the buffer data commands are read into by Apply; 4MB if zero
+	BufferSize int
+	// BufferPool, if set, supplies the buffer instead. See BufferPool
+	BufferPool *BufferPool
 }
 
 func NewBinaryDeltaReader(input io.Reader) *BinaryDeltaReader {
 	return &BinaryDeltaReader{
-		input:            input,
+		input:            &countingReader{reader: input},
 		ProgressReporter: NopProgressReporter(),
+		BufferSize:       defaultReadBufferSize,
 	}
 }
 
@@ -55,37 +64,61 @@ func (b *BinaryDeltaReader) HashAlgorithm() (HashAlgorithm, error) {
 }
 
 func (b *BinaryDeltaReader) Apply(writeData func([]byte) error, copyData func(int64, int64) error) error {
+	return b.apply(b.Limits, b.BufferPool, writeData, copyData)
+}
+
+// apply is Apply with `limits` and `bufferPool` in place of the reader's own, so that ApplyDeltaWithOptions
+// can pass its options on without changing the fields of a reader which belongs to the caller
+func (b *BinaryDeltaReader) apply(limits *DeltaLimits, bufferPool *BufferPool, writeData func([]byte) error, copyData func(int64, int64) error) error {
 	err := b.ensureMetadata()
 	if err != nil {
 		return err
 	}
 
-	buffer := make([]byte, defaultReadBufferSize)
+	buffer, releaseBuffer := getBuffer(bufferPool, b.BufferSize, defaultReadBufferSize)
+	defer releaseBuffer()
+	tracker := &deltaLimitTracker{}
+	if limits != nil {
+		tracker.limits = *limits
+	}
 
 	cmdTypeByte := make([]byte, 1)
 	for {
 		// we should not reach EOF when reading other expected bytes like EOF, but we
 		// can rech it here once we've consumed all the commands in a file
-		bytesRead, err := b.input.Read(cmdTypeByte)
+		commandOffset := b.input.offset
+		b.ProgressReporter.ReportProgress("Applying delta", commandOffset, b.DeltaLength)
+		_, err := io.ReadFull(b.input, cmdTypeByte)
 		if err == io.EOF {
 			return nil // all done, finished reading the file
 		}
 		if err != nil {
 			return err
 		}
-		if bytesRead != len(cmdTypeByte) {
-			return errors.New("could not read command type byte")
-		}
 
-		//b.ProgressReporter.ReportProgress("Applying delta", reader.BaseStream.Position, fileLength)
+		err = tracker.addCommand()
+		if err != nil {
+			return err
+		}
 
 		if bytes.Equal(cmdTypeByte, BinaryCopyCommand) {
 			var start, length int64
 			err = binary.Read(b.input, binary.LittleEndian, &start)
 			if err != nil {
-				return err
+				return truncatedOrError(err, "delta", b.input.offset)
 			}
 			err = binary.Read(b.input, binary.LittleEndian, &length)
+			if err != nil {
+				return truncatedOrError(err, "delta", b.input.offset)
+			}
+			if start < 0 || length < 0 {
+				return newFormatError("delta", commandOffset, ErrCorruptCommand, "the delta file appears to be corrupt; copy command has a negative offset or length")
+			}
+			err = checkLimit(LimitCopyLength, tracker.limits.MaxCopyLength, length)
+			if err != nil {
+				return err
+			}
+			err = tracker.addOutput(length)
 			if err != nil {
 				return err
 			}
@@ -97,10 +130,22 @@ func (b *BinaryDeltaReader) Apply(writeData func([]byte) error, copyData func(in
 		} else if bytes.Equal(cmdTypeByte, BinaryDataCommand) {
 			var length int64
 			err = binary.Read(b.input, binary.LittleEndian, &length)
+			if err != nil {
+				return truncatedOrError(err, "delta", b.input.offset)
+			}
+			if length < 0 {
+				return newFormatError("delta", commandOffset, ErrCorruptCommand, "the delta file appears to be corrupt; data command has a negative length")
+			}
+			err = checkLimit(LimitDataCommandLength, tracker.limits.MaxDataCommandLength, length)
+			if err != nil {
+				return err
+			}
+			err = tracker.addOutput(length)
 			if err != nil {
 				return err
 			}
 
+			dataEnd := b.input.offset + length
 			iter := NewReaderIteratorBufferNBytes(b.input, buffer, length)
 			for iter.Next() {
 				err = writeData(iter.Current)
@@ -110,78 +155,99 @@ func (b *BinaryDeltaReader) Apply(writeData func([]byte) error, copyData func(in
 			}
 			err = iter.Err()
 			if err != nil {
-				return err
+				return truncatedOrError(err, "delta", b.input.offset)
+			}
+			if b.input.offset < dataEnd {
+				return truncatedOrError(io.ErrUnexpectedEOF, "delta", b.input.offset)
 			}
 			// loop round to read the next command
 		} else {
-			return errors.New("unexpected cmd byte in delta file")
+			return newFormatError("delta", commandOffset, ErrCorruptCommand, "unexpected cmd byte in delta file")
 		}
 	}
 }
 
 var _ DeltaReader = (*BinaryDeltaReader)(nil)
 
+// binaryDeltaReaderWithOptions is a BinaryDeltaReader applied with limits and a buffer pool from ApplyDeltaOptions
+type binaryDeltaReaderWithOptions struct {
+	*BinaryDeltaReader
+	limits     *DeltaLimits
+	bufferPool *BufferPool
+}
+
+func (b *binaryDeltaReaderWithOptions) Apply(writeData func([]byte) error, copyData func(int64, int64) error) error {
+	return b.apply(b.limits, b.bufferPool, writeData, copyData)
+}
+
 func (b *BinaryDeltaReader) ensureMetadata() error {
 	if b.hasReadMetadata {
 		return nil
 	}
 
 	headerBytes := make([]byte, len(BinaryDeltaHeader))
-	bytesRead, err := b.input.Read(headerBytes)
+	err := b.readFull(headerBytes)
 	if err != nil {
 		return err
 	}
-	if bytesRead != len(BinaryDeltaHeader) || !bytes.Equal(headerBytes, BinaryDeltaHeader) {
-		return errors.New("the delta file appears to be corrupt")
+	if !bytes.Equal(headerBytes, BinaryDeltaHeader) {
+		return newFormatError("delta", 0, ErrCorruptHeader, "the delta file appears to be corrupt")
 	}
 
+	offset := b.input.offset
 	var versionBytes = make([]byte, len(BinaryVersion))
-	bytesRead, err = b.input.Read(versionBytes)
+	err = b.readFull(versionBytes)
 	if err != nil {
 		return err
 	}
-	if bytesRead != len(BinaryVersion) || !bytes.Equal(versionBytes, BinaryVersion) {
-		return errors.New("the delta file uses a newer file format than this program can handle")
+	if !bytes.Equal(versionBytes, BinaryVersion) {
+		return newFormatError("delta", offset, ErrUnsupportedVersion, "the delta file uses a newer file format than this program can handle")
 	}
 
-	hashAlgorithmName, _, err := readLengthPrefixedString(b.input)
+	offset = b.input.offset
+	hashAlgorithmName, _, err := readLengthPrefixedString(b.input, "delta", offset)
 	if err != nil {
 		return err
 	}
 	if hashAlgorithmName != DefaultHashAlgorithm.Name() {
-		return errors.New("the delta file uses an unsupported hashing algorithm")
+		return newFormatError("delta", offset, ErrUnsupportedAlgorithm, "the delta file uses an unsupported hashing algorithm")
 	}
 	hashAlgorithm := DefaultHashAlgorithm
 	b.hashAlgorithm = hashAlgorithm
 
+	offset = b.input.offset
 	var hashLength int32
 	err = binary.Read(b.input, binary.LittleEndian, &hashLength)
 	if err != nil {
-		return err
+		return truncatedOrError(err, "delta", b.input.offset)
 	}
 	if int(hashLength) != hashAlgorithm.HashLength() {
-		return errors.New("the delta file contains an invalid hash length")
+		return newFormatError("delta", offset, ErrCorruptHeader, "the delta file contains an invalid hash length")
 	}
 
-	hashBytes := make([]byte, hashLength)
-	bytesRead, err = b.input.Read(hashBytes)
+	hashBytes := make([]byte, hashLength) // hashLength has been checked above, so this can't be a huge allocation
+	err = b.readFull(hashBytes)
 	if err != nil {
 		return err
 	}
-	if bytesRead != len(hashBytes) {
-		return fmt.Errorf("the delta file appears to be corrupt; expecting hash length of %d but only read %d bytes", hashLength, len(hashBytes))
-	}
 	b.expectedHash = hashBytes
 
+	offset = b.input.offset
 	endOfMetaBytes := make([]byte, len(BinaryEndOfMetadata))
-	bytesRead, err = b.input.Read(endOfMetaBytes)
+	err = b.readFull(endOfMetaBytes)
 	if err != nil {
 		return err
 	}
-	if bytesRead != len(BinaryEndOfMetadata) || !bytes.Equal(endOfMetaBytes, BinaryEndOfMetadata) {
-		return errors.New("the delta file appears to be corrupt")
+	if !bytes.Equal(endOfMetaBytes, BinaryEndOfMetadata) {
+		return newFormatError("delta", offset, ErrCorruptHeader, "the delta file appears to be corrupt")
 	}
 
 	b.hasReadMetadata = true
 	return nil
 }
+
+// readFull fills `buffer` from the delta file, which must not end part way through it
+func (b *BinaryDeltaReader) readFull(buffer []byte) error {
+	_, err := io.ReadFull(b.input, buffer)
+	return truncatedOrError(err, "delta", b.input.offset)
+}
@@ -2,6 +2,7 @@ package octodiff
 
 import (
 	"encoding/binary"
+	"errors"
 	"io"
 )
 
@@ -9,6 +10,16 @@ type BinaryDeltaWriter struct {
 	Output             io.Writer
 	bufferedCopyOffset int64
 	bufferedCopyLength int64
+
+	expectedHashOffset int64 // where WriteMetadata put the expected hash, relative to the start of the delta
+	expectedHashLength int
+
+	// BufferSize is the size of the buffer used to copy data commands from the new file; 1MB if zero
+	BufferSize int
+	// BufferPool, if set, supplies the buffer for each data command instead. See BufferPool
+	BufferPool *BufferPool
+
+	buffer []byte
 }
 
 var _ DeltaWriter = (*BinaryDeltaWriter)(nil)
@@ -20,6 +31,9 @@ func NewBinaryDeltaWriter(output io.Writer) *BinaryDeltaWriter {
 }
 
 func (w *BinaryDeltaWriter) WriteMetadata(hashAlgorithm HashAlgorithm, expectedNewFileHash []byte) error {
+	w.expectedHashOffset = int64(len(BinaryDeltaHeader) + len(BinaryVersion) + lengthPrefixedStringSize(hashAlgorithm.Name()) + 4)
+	w.expectedHashLength = len(expectedNewFileHash)
+
 	_, err := w.Output.Write(BinaryDeltaHeader)
 	if err != nil {
 		return err
@@ -104,30 +118,30 @@ func (w *BinaryDeltaWriter) WriteDataCommand(source io.ReadSeeker, offset int64,
 		return
 	}
 
-	var originalPosition int64
-	originalPosition, err = source.Seek(0, io.SeekCurrent) // doing a no-op seek is how you find out the current position of a Go reader
-	if err != nil {
-		return
-	}
-	// we need to ensure we seek back to originalPosition before exiting the function.
-	defer func() {
-		_, seekBackErr := source.Seek(originalPosition, io.SeekStart)
-		if seekBackErr != nil {
-			err = seekBackErr // this causes writeDataCommand to return this error
+	var buffer []byte
+	if w.BufferPool != nil {
+		buffer = w.BufferPool.Get()
+		defer w.BufferPool.Put(buffer)
+	} else {
+		if w.buffer == nil { // kept for the next data command
+			w.buffer = make([]byte, bufferSizeOrDefault(w.BufferSize, defaultDataCommandBufferSize))
 		}
-	}()
-
-	_, err = source.Seek(offset, io.SeekStart)
-	if err != nil {
-		return
+		buffer = w.buffer
 	}
 
-	iter := NewReaderIteratorSizeNBytes(source, 1024*1024, length)
-	for iter.Next() {
-		_, err = w.Output.Write(iter.Current)
-		if err != nil {
-			return err
-		}
-	}
-	return iter.Err()
+	return readSourceRange(source, offset, length, buffer, func(data []byte) error {
+		_, err := w.Output.Write(data)
+		return err
+	})
+}
+
+// WriteExpectedHash replaces the expected hash given to WriteMetadata, for deltas built by StreamingDeltaBuilder
+// which only know the hash once they have finished. `output` is where the delta was written to, with the delta
+// starting at offset zero, and must be called after anything buffering writes to it has been flushed.
+func (w *BinaryDeltaWriter) WriteExpectedHash(output io.WriterAt, expectedNewFileHash []byte) error {
+	if len(expectedNewFileHash) != w.expectedHashLength {
+		return errors.New("the expected hash must be the same length as the one written with the metadata")
+	}
+	_, err := output.WriteAt(expectedNewFileHash, w.expectedHashOffset)
+	return err
 }
@@ -0,0 +1,62 @@
+package octodiff
+
+import (
+	"sync"
+)
+
+// defaultDataCommandBufferSize is the size of the buffer delta writers use to copy data commands from the new file
+const defaultDataCommandBufferSize = 1024 * 1024
+
+// BufferPool shares buffers of a fixed size between builders, readers and writers, so that a program building or
+// applying many deltas doesn't allocate new buffers for each one. It is safe for concurrent use.
+// Anything given a BufferPool uses its buffers instead of allocating its own, whatever its BufferSize.
+type BufferPool struct {
+	size int
+	pool sync.Pool
+}
+
+// NewBufferPool creates a pool of buffers of `size` bytes. DeltaBuilder needs buffers bigger than the largest chunk
+// in a signature, so for sharing between everything 4MB, the default size used by NewDeltaBuilder, is a good choice.
+func NewBufferPool(size int) *BufferPool {
+	return &BufferPool{size: size}
+}
+
+// Size returns the size of the buffers in the pool
+func (p *BufferPool) Size() int {
+	return p.size
+}
+
+// Get returns a buffer from the pool, allocating one if the pool is empty
+func (p *BufferPool) Get() []byte {
+	if buffer, ok := p.pool.Get().(*[]byte); ok {
+		return *buffer
+	}
+	return make([]byte, p.size)
+}
+
+// Put returns a buffer to the pool. Buffers which didn't come from the pool (or are the wrong size) are ignored.
+func (p *BufferPool) Put(buffer []byte) {
+	if cap(buffer) != p.size {
+		return
+	}
+	buffer = buffer[:p.size]
+	p.pool.Put(&buffer) // stored as a pointer, which fits in an interface without copying the slice header
+}
+
+// getBuffer returns a buffer from `pool` if it isn't nil, or else allocates one of `size` bytes,
+// or `defaultSize` if `size` isn't positive. Call the returned func once the buffer is no longer needed.
+func getBuffer(pool *BufferPool, size int, defaultSize int) ([]byte, func()) {
+	if pool != nil {
+		buffer := pool.Get()
+		return buffer, func() { pool.Put(buffer) }
+	}
+	return make([]byte, bufferSizeOrDefault(size, defaultSize)), func() {}
+}
+
+// bufferSizeOrDefault returns `size`, or `defaultSize` if `size` isn't positive
+func bufferSizeOrDefault(size int, defaultSize int) int {
+	if size <= 0 {
+		return defaultSize
+	}
+	return size
+}
@@ -0,0 +1,159 @@
+package octodiff_test
+
+import (
+	"bytes"
+	"github.com/OctopusDeploy/go-octodiff/pkg/octodiff"
+	"github.com/OctopusDeploy/go-octodiff/pkg/test"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func buildDeltaWith(builder *octodiff.DeltaBuilder, deltaWriter *octodiff.BinaryDeltaWriter, newFile []byte, signatureFile []byte) error {
+	return builder.Build(bytes.NewReader(newFile), int64(len(newFile)), bytes.NewReader(signatureFile), int64(len(signatureFile)), deltaWriter)
+}
+
+func TestBufferPool(t *testing.T) {
+	pool := octodiff.NewBufferPool(1024)
+	assert.Equal(t, 1024, pool.Size())
+
+	buffer := pool.Get()
+	assert.Equal(t, 1024, len(buffer))
+	pool.Put(buffer[:10]) // put back however it was sliced
+	pool.Put(make([]byte, 512))
+
+	for i := 0; i < 3; i++ { // whether or not they come from the pool, buffers are the right size
+		assert.Equal(t, 1024, len(pool.Get()))
+	}
+}
+
+func TestDeltaBuilderBufferSize(t *testing.T) {
+	original, newFile := largeFileWithDisjointChanges()
+	signatureFile := buildSignature(original)
+
+	// the new file is read a few chunks at a time, rather than all at once, and the delta is the same
+	var delta bytes.Buffer
+	builder := octodiff.NewDeltaBuilder()
+	builder.BufferSize = 4 * octodiff.SignatureDefaultChunkSize
+	err := buildDeltaWith(builder, octodiff.NewBinaryDeltaWriter(&delta), newFile, signatureFile)
+	assert.Nil(t, err)
+	assert.Equal(t, buildDelta(newFile, signatureFile), delta.Bytes())
+
+	builder.BufferSize = octodiff.SignatureDefaultChunkSize - 1
+	err = buildDeltaWith(builder, octodiff.NewBinaryDeltaWriter(&bytes.Buffer{}), newFile, signatureFile)
+	assert.EqualError(t, err, "the delta builder's buffer of 2047 bytes is smaller than the largest chunk in the signature, of 2048 bytes")
+
+	builder.BufferSize = 0 // the default
+	delta.Reset()
+	err = buildDeltaWith(builder, octodiff.NewBinaryDeltaWriter(&delta), newFile, signatureFile)
+	assert.Nil(t, err)
+	assert.Equal(t, buildDelta(newFile, signatureFile), delta.Bytes())
+}
+
+func TestBinaryDeltaReaderBufferSize(t *testing.T) {
+	newFile := test.GenerateTestData(10000)
+	deltaFile := buildDelta(newFile, buildSignature(nil)) // all data
+
+	deltaReader := octodiff.NewBinaryDeltaReader(bytes.NewReader(deltaFile))
+	deltaReader.BufferSize = 4096
+	var writes []int
+	err := deltaReader.Apply(func(data []byte) error {
+		writes = append(writes, len(data))
+		return nil
+	}, func(int64, int64) error {
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []int{4096, 4096, 1808}, writes)
+}
+
+func TestBinaryDeltaWriterBufferSize(t *testing.T) {
+	original, newFile := largeFileWithDisjointChanges()
+	signatureFile := buildSignature(original)
+
+	deltaWriter := func() *octodiff.BinaryDeltaWriter {
+		return octodiff.NewBinaryDeltaWriter(&bytes.Buffer{})
+	}
+	small := deltaWriter()
+	small.BufferSize = 100
+	pooled := deltaWriter()
+	pooled.BufferPool = octodiff.NewBufferPool(100)
+
+	for _, w := range []*octodiff.BinaryDeltaWriter{small, pooled} {
+		err := buildDeltaWith(octodiff.NewDeltaBuilder(), w, newFile, signatureFile)
+		assert.Nil(t, err)
+		assert.Equal(t, buildDelta(newFile, signatureFile), w.Output.(*bytes.Buffer).Bytes())
+	}
+}
+
+func TestApplyDeltaWithBufferPool(t *testing.T) {
+	pool := octodiff.NewBufferPool(64 * 1024)
+	original, newFile := largeFileWithDisjointChanges()
+	deltaFile := buildDelta(newFile, buildSignature(original))
+
+	for i := 0; i < 3; i++ { // later deltas get the buffers from earlier ones
+		var output bytes.Buffer
+		err := octodiff.ApplyDeltaWithOptions(bytes.NewReader(original), octodiff.NewBinaryDeltaReader(bytes.NewReader(deltaFile)), &output, octodiff.ApplyDeltaOptions{Verify: true, BufferPool: pool})
+		assert.Nil(t, err)
+		assert.Equal(t, newFile, output.Bytes())
+	}
+}
+
+// The benchmarks below show the allocations saved by a BufferPool (run with -benchmem) when there are many small files,
+// where allocating a 4MB buffer for each is most of the work.
+
+func smallFileWithChanges() ([]byte, []byte) {
+	original := test.GenerateTestData(16 * 1024)
+	newFile := append([]byte(nil), original...)
+	newFile[100] = 0xaa
+	newFile[10000] = 0xab
+	return original, newFile
+}
+
+func benchmarkDeltaBuilder(b *testing.B, pool *octodiff.BufferPool) {
+	original, newFile := smallFileWithChanges()
+	signatureFile := buildSignature(original)
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		builder := octodiff.NewDeltaBuilder()
+		builder.BufferPool = pool
+		deltaWriter := octodiff.NewBinaryDeltaWriter(&bytes.Buffer{})
+		deltaWriter.BufferPool = pool
+		err := buildDeltaWith(builder, deltaWriter, newFile, signatureFile)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDeltaBuilder(b *testing.B) {
+	benchmarkDeltaBuilder(b, nil)
+}
+
+func BenchmarkDeltaBuilderWithBufferPool(b *testing.B) {
+	benchmarkDeltaBuilder(b, octodiff.NewBufferPool(4*1024*1024))
+}
+
+func benchmarkApplyDelta(b *testing.B, pool *octodiff.BufferPool) {
+	original, newFile := smallFileWithChanges()
+	deltaFile := buildDelta(newFile, buildSignature(original))
+	var output bytes.Buffer
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		output.Reset()
+		deltaReader := octodiff.NewBinaryDeltaReader(bytes.NewReader(deltaFile))
+		err := octodiff.ApplyDeltaWithOptions(bytes.NewReader(original), deltaReader, &output, octodiff.ApplyDeltaOptions{BufferPool: pool})
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkApplyDelta(b *testing.B) {
+	benchmarkApplyDelta(b, nil)
+}
+
+func BenchmarkApplyDeltaWithBufferPool(b *testing.B) {
+	benchmarkApplyDelta(b, octodiff.NewBufferPool(4*1024*1024))
+}
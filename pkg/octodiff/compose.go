@@ -0,0 +1,176 @@
+package octodiff
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// ComposeDeltas combines a delta from A to B, and a delta from B to C, into a single delta from A to C.
+// Copy commands in `second` refer to the intermediate file B, and are rewritten in terms of
+// the commands of `first` which produce those bytes, so B itself is never needed.
+func ComposeDeltas(first *Delta, second *Delta) (*Delta, error) {
+	result := &Delta{
+		HashAlgorithm: second.HashAlgorithm,
+		ExpectedHash:  second.ExpectedHash,
+	}
+	err := composeCommands(newDeltaIndex(first.Commands), second.Reader(),
+		func(data []byte) error {
+			result.appendData(data)
+			return nil
+		},
+		func(offset int64, length int64) error {
+			result.Commands = append(result.Commands, NewCopyCommand(offset, length))
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+	result.Commands = mergeDeltaCommands(result.Commands)
+	return result, nil
+}
+
+// ComposeDeltaChain combines a chain of deltas (A to B, B to C, C to D, ...) into a single delta from the
+// first basis file to the last new file, written to `output`.
+// All but the last delta are held in memory; the last delta is streamed straight through to `output`.
+func ComposeDeltaChain(deltaReaders []DeltaReader, output DeltaWriter) error {
+	if len(deltaReaders) == 0 {
+		return errors.New("no deltas were supplied to compose")
+	}
+
+	last := deltaReaders[len(deltaReaders)-1]
+	var base *Delta
+	for i, deltaReader := range deltaReaders[:len(deltaReaders)-1] {
+		delta, err := ReadDelta(deltaReader)
+		if err != nil {
+			return fmt.Errorf("could not read delta %d: %w", i+1, err)
+		}
+		if base == nil {
+			base = delta
+			continue
+		}
+		base, err = ComposeDeltas(base, delta)
+		if err != nil {
+			return fmt.Errorf("could not compose delta %d: %w", i+1, err)
+		}
+	}
+
+	hashAlgorithm, err := last.HashAlgorithm()
+	if err != nil {
+		return err
+	}
+	expectedHash, err := last.ExpectedHash()
+	if err != nil {
+		return err
+	}
+	err = output.WriteMetadata(hashAlgorithm, expectedHash)
+	if err != nil {
+		return err
+	}
+
+	writeData := func(data []byte) error {
+		return output.WriteDataCommand(bytes.NewReader(data), 0, int64(len(data)))
+	}
+	if base == nil { // only one delta; nothing to compose so just copy it
+		err = last.Apply(writeData, output.WriteCopyCommand)
+	} else {
+		err = composeCommands(newDeltaIndex(base.Commands), last, writeData, output.WriteCopyCommand)
+	}
+	if err != nil {
+		return err
+	}
+	return output.Flush()
+}
+
+// composeCommands reads the commands from `deltaReader`, passing data through and
+// replacing each copy with the commands from `index` that produce the copied range.
+func composeCommands(index *deltaIndex, deltaReader DeltaReader, writeData func([]byte) error, copyData func(int64, int64) error) error {
+	return deltaReader.Apply(writeData, func(offset int64, length int64) error {
+		return index.visit(offset, length, writeData, copyData)
+	})
+}
+
+// deltaIndex maps offsets within the file a delta produces onto the commands which produce them
+type deltaIndex struct {
+	commands []*DeltaCommand
+	offsets  []int64 // offsets[i] is where commands[i] starts in the new file
+	length   int64
+}
+
+func newDeltaIndex(commands []*DeltaCommand) *deltaIndex {
+	index := &deltaIndex{
+		commands: make([]*DeltaCommand, 0, len(commands)),
+		offsets:  make([]int64, 0, len(commands)),
+	}
+	for _, cmd := range commands {
+		index.add(cmd)
+	}
+	return index
+}
+
+// add appends a command to the end of the new file described by the index
+func (x *deltaIndex) add(cmd *DeltaCommand) {
+	if cmd.Length == 0 {
+		return
+	}
+	x.commands = append(x.commands, cmd)
+	x.offsets = append(x.offsets, x.length)
+	x.length += cmd.Length
+}
+
+// find returns the index of the command containing `offset`; offset must be within the file
+func (x *deltaIndex) find(offset int64) int {
+	return sort.Search(len(x.offsets), func(i int) bool { return x.offsets[i] > offset }) - 1
+}
+
+// dataAt returns the `length` bytes from `offset` in the new file if they are all produced by data commands,
+// or false if any of them are copied. offset and length must be within the file.
+func (x *deltaIndex) dataAt(offset int64, length int64) ([]byte, bool) {
+	result := make([]byte, 0, length)
+	for i := x.find(offset); int64(len(result)) < length; i++ {
+		cmd := x.commands[i]
+		if cmd.Type != DeltaCommandData {
+			return nil, false
+		}
+		skip := offset + int64(len(result)) - x.offsets[i]
+		n := cmd.Length - skip
+		if remaining := length - int64(len(result)); n > remaining {
+			n = remaining
+		}
+		result = append(result, cmd.Data[skip:skip+n]...)
+	}
+	return result, true
+}
+
+// visit invokes writeData or copyData for each part of the commands that produce `length` bytes from `offset` in the new file.
+func (x *deltaIndex) visit(offset int64, length int64, writeData func([]byte) error, copyData func(int64, int64) error) error {
+	if offset < 0 || length < 0 || offset > x.length || length > x.length-offset {
+		return &CopyOutOfRangeError{Offset: offset, Length: length, BasisFileLength: x.length} // the basis of the second delta
+	}
+	if length == 0 {
+		return nil
+	}
+
+	for i := x.find(offset); length > 0; i++ {
+		cmd := x.commands[i]
+		skip := offset - x.offsets[i]
+		n := cmd.Length - skip
+		if n > length {
+			n = length
+		}
+
+		var err error
+		if cmd.Type == DeltaCommandCopy {
+			err = copyData(cmd.Offset+skip, n)
+		} else {
+			err = writeData(cmd.Data[skip : skip+n])
+		}
+		if err != nil {
+			return err
+		}
+		offset += n
+		length -= n
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package octodiff_test
+
+import (
+	"bytes"
+	"errors"
+	"github.com/OctopusDeploy/go-octodiff/pkg/octodiff"
+	"github.com/OctopusDeploy/go-octodiff/pkg/test"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// returns three successive versions of a file, each with small changes from the last
+func threeVersions() ([]byte, []byte, []byte) {
+	a := test.GenerateTestData(64 * 1024)
+
+	b := append([]byte{0x01, 0x02, 0x03}, a...) // prepend, shifting everything along
+	b[10000] = 0xaa
+
+	c := append([]byte(nil), b[:40000]...) // cut out a section and change a byte
+	c = append(c, b[45000:]...)
+	c[100] = 0xbb
+	return a, b, c
+}
+
+func TestComposeDeltas(t *testing.T) {
+	a, b, c := threeVersions()
+
+	ab := readDelta(buildDelta(b, buildSignatureWithChunkSize(a, octodiff.SignatureMinimumChunkSize)))
+	bc := readDelta(buildDelta(c, buildSignatureWithChunkSize(b, octodiff.SignatureMinimumChunkSize)))
+
+	ac, err := octodiff.ComposeDeltas(ab, bc)
+	assert.Nil(t, err)
+	assert.Equal(t, bc.ExpectedHash, ac.ExpectedHash)
+
+	var output bytes.Buffer
+	err = ac.Apply(bytes.NewReader(a), &output)
+	assert.Nil(t, err)
+	assert.Equal(t, c, output.Bytes())
+	assert.Nil(t, octodiff.VerifyNewFile(bytes.NewReader(output.Bytes()), ac.Reader()))
+}
+
+func TestComposeDeltaChain(t *testing.T) {
+	a, b, c := threeVersions()
+	d := append([]byte(nil), c...)
+	d[20000] = 0xcc
+
+	deltaReaders := []octodiff.DeltaReader{
+		octodiff.NewBinaryDeltaReader(bytes.NewReader(buildDelta(b, buildSignature(a)))),
+		octodiff.NewBinaryDeltaReader(bytes.NewReader(buildDelta(c, buildSignature(b)))),
+		octodiff.NewBinaryDeltaReader(bytes.NewReader(buildDelta(d, buildSignature(c)))),
+	}
+
+	var deltaFile bytes.Buffer
+	err := octodiff.ComposeDeltaChain(deltaReaders, octodiff.NewBinaryDeltaWriter(&deltaFile))
+	assert.Nil(t, err)
+
+	var output bytes.Buffer
+	err = octodiff.ApplyDelta(bytes.NewReader(a), octodiff.NewBinaryDeltaReader(bytes.NewReader(deltaFile.Bytes())), &output)
+	assert.Nil(t, err)
+	assert.Equal(t, d, output.Bytes())
+}
+
+func TestComposeDeltaChainWithSingleDelta(t *testing.T) {
+	a, b, _ := threeVersions()
+	deltaFile := buildDelta(b, buildSignature(a))
+
+	var output bytes.Buffer
+	err := octodiff.ComposeDeltaChain([]octodiff.DeltaReader{octodiff.NewBinaryDeltaReader(bytes.NewReader(deltaFile))}, octodiff.NewBinaryDeltaWriter(&output))
+	assert.Nil(t, err)
+	assert.Equal(t, deltaFile, output.Bytes())
+}
+
+func TestComposeDeltasRejectsCopyBeyondIntermediateFile(t *testing.T) {
+	first := &octodiff.Delta{
+		HashAlgorithm: octodiff.DefaultHashAlgorithm,
+		Commands:      []*octodiff.DeltaCommand{octodiff.NewCopyCommand(0, 100)},
+	}
+	second := &octodiff.Delta{
+		HashAlgorithm: octodiff.DefaultHashAlgorithm,
+		Commands:      []*octodiff.DeltaCommand{octodiff.NewCopyCommand(50, 51)},
+	}
+
+	_, err := octodiff.ComposeDeltas(first, second)
+	var rangeErr *octodiff.CopyOutOfRangeError
+	if assert.True(t, errors.As(err, &rangeErr)) {
+		assert.Equal(t, octodiff.CopyOutOfRangeError{Offset: 50, Length: 51, BasisFileLength: 100}, *rangeErr)
+	}
+}
@@ -2,6 +2,9 @@ package octodiff
 
 var BinarySignatureHeader = []byte("OCTOSIG")
 var BinaryDeltaHeader = []byte("OCTODELTA")
+var BinaryDirectorySignatureHeader = []byte("OCTODIRSIG")
+var BinaryDirectoryDeltaHeader = []byte("OCTODIRDELTA")
+var BinaryUnpackedArchiveHeader = []byte("OCTOARCHIVE")
 var BinaryEndOfMetadata = []byte(">>>")
 
 var BinaryCopyCommand = []byte{0x60}
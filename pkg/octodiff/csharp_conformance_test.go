@@ -0,0 +1,140 @@
+package octodiff_test
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"github.com/OctopusDeploy/go-octodiff/pkg/octodiff"
+	"github.com/stretchr/testify/assert"
+	"hash/adler32"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// The files in testdata/csharp are the signatures and deltas which the C# implementation of OctoDiff produces,
+// so Go and C# can be used on either end of a transfer. See testdata/csharp/README.md for how they are generated.
+// Each case has a `basis` file and its signature `basis.octosig`, and optionally a `new` file and the delta `new.octodelta`
+// which turns the basis file into the new file. Only the C# library may write the signatures and deltas, so a case
+// is skipped until they have been generated with it.
+var csharpConformanceCases = []struct {
+	name                     string
+	chunkSize                int
+	rollingChecksumAlgorithm octodiff.RollingChecksum
+}{
+	{"same-file", octodiff.SignatureDefaultChunkSize, octodiff.NewAdler32RollingChecksum()},
+	{"empty-basis", octodiff.SignatureDefaultChunkSize, octodiff.NewAdler32RollingChecksum()},
+	{"changed-bytes", octodiff.SignatureMinimumChunkSize, octodiff.NewAdler32RollingChecksum()},
+	{"prepended-byte", octodiff.SignatureMinimumChunkSize, octodiff.NewAdler32RollingChecksum()},
+	{"large-file-disjoint-changes", octodiff.SignatureDefaultChunkSize, octodiff.NewAdler32RollingChecksum()},
+	{"adler32v2", octodiff.SignatureMinimumChunkSize, octodiff.NewAdler32RollingChecksumV2()},
+}
+
+func readCSharpTestData(t *testing.T, name string, file string) []byte {
+	data, err := os.ReadFile(filepath.Join("testdata", "csharp", name, file))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// readCSharpOutput reads a signature or delta written by the C# library, skipping the test if it hasn't been generated
+func readCSharpOutput(t *testing.T, name string, file string) []byte {
+	data := readCSharpTestData(t, name, file)
+	if data == nil {
+		t.Skipf("testdata/csharp/%s/%s has not been generated by the C# library; see testdata/csharp/README.md", name, file)
+	}
+	return data
+}
+
+func TestCSharpSignaturesCanBeRead(t *testing.T) {
+	for _, tc := range csharpConformanceCases {
+		t.Run(tc.name, func(t *testing.T) {
+			basis := readCSharpTestData(t, tc.name, "basis")
+			signature, err := readSignature(readCSharpOutput(t, tc.name, "basis.octosig"))
+			if !assert.Nil(t, err) {
+				return
+			}
+			assert.Equal(t, tc.rollingChecksumAlgorithm.Name(), signature.RollingChecksumAlgorithm.Name())
+
+			// check each chunk against the standard library, rather than our own implementation
+			offset := int64(0)
+			for _, chunk := range signature.Chunks {
+				assert.Equal(t, offset, chunk.StartOffset)
+				data := basis[chunk.StartOffset : chunk.StartOffset+int64(chunk.Length)]
+				hash := sha1.Sum(data)
+				assert.Equal(t, hash[:], chunk.Hash)
+				if tc.rollingChecksumAlgorithm.Name() == octodiff.Adler32RollingChecksumV2Name {
+					assert.Equal(t, adler32.Checksum(data), chunk.RollingChecksum) // V2 is standard Adler-32
+				}
+				offset += int64(chunk.Length)
+			}
+			assert.Equal(t, int64(len(basis)), offset)
+		})
+	}
+}
+
+func TestCSharpDeltasCanBeApplied(t *testing.T) {
+	for _, tc := range csharpConformanceCases {
+		if readCSharpTestData(t, tc.name, "new") == nil {
+			continue
+		}
+		t.Run(tc.name, func(t *testing.T) {
+			deltaFile := readCSharpOutput(t, tc.name, "new.octodelta")
+			output, err := applyAndVerify(readCSharpTestData(t, tc.name, "basis"), octodiff.NewBinaryDeltaReader(bytes.NewReader(deltaFile)))
+			assert.Nil(t, err)
+			assert.Equal(t, readCSharpTestData(t, tc.name, "new"), output)
+		})
+	}
+}
+
+func TestSignaturesMatchCSharp(t *testing.T) {
+	for _, tc := range csharpConformanceCases {
+		t.Run(tc.name, func(t *testing.T) {
+			builder := octodiff.NewSignatureBuilder()
+			builder.ChunkSize = tc.chunkSize
+			builder.RollingChecksumAlgorithm = tc.rollingChecksumAlgorithm
+
+			assert.Equal(t, readCSharpOutput(t, tc.name, "basis.octosig"), buildSignatureBuilder(builder, readCSharpTestData(t, tc.name, "basis")))
+		})
+	}
+}
+
+func TestDeltasMatchCSharp(t *testing.T) {
+	for _, tc := range csharpConformanceCases {
+		if readCSharpTestData(t, tc.name, "new") == nil {
+			continue
+		}
+		t.Run(tc.name, func(t *testing.T) {
+			deltaFile := readCSharpOutput(t, tc.name, "new.octodelta")
+			assert.Equal(t, deltaFile, buildDelta(readCSharpTestData(t, tc.name, "new"), readCSharpOutput(t, tc.name, "basis.octosig")))
+		})
+	}
+}
+
+// renamedHashAlgorithm is SHA1 under another name
+type renamedHashAlgorithm struct {
+	octodiff.HashAlgorithm
+	name string
+}
+
+func (r renamedHashAlgorithm) Name() string {
+	return r.name
+}
+
+func TestCSharpSignatureWithLongAlgorithmName(t *testing.T) {
+	// the hash algorithm name is 200 bytes, so its length takes two bytes
+	longName := strings.Repeat("A", 200)
+	signatureFile := readCSharpOutput(t, "long-algorithm-name", "basis.octosig")
+
+	_, err := readSignature(signatureFile)
+	assert.ErrorIs(t, err, octodiff.ErrUnsupportedAlgorithm)
+	assert.EqualError(t, err, "signature uses unsupported hash algorithm "+longName+" (at offset 8)")
+
+	builder := octodiff.NewSignatureBuilder()
+	builder.HashAlgorithm = renamedHashAlgorithm{HashAlgorithm: octodiff.DefaultHashAlgorithm, name: longName}
+	assert.Equal(t, signatureFile, buildSignatureBuilder(builder, nil))
+}
@@ -0,0 +1,269 @@
+package octodiff
+
+import (
+	"bytes"
+	"errors"
+	"io"
+)
+
+type DeltaCommandType int
+
+const (
+	DeltaCommandCopy DeltaCommandType = iota + 1
+	DeltaCommandData
+)
+
+// sizes of commands in the binary delta format, used to work out what is cheaper on the wire
+const (
+	binaryCopyCommandSize       = 1 + 8 + 8 // command byte, int64 offset, int64 length
+	binaryDataCommandHeaderSize = 1 + 8     // command byte, int64 length; followed by the data itself
+)
+
+// DeltaCommand is a single instruction from a delta file.
+// Copy commands copy Length bytes from Offset in the basis file; Data is nil.
+// Data commands write Data to the new file; Offset is unused and Length is len(Data).
+type DeltaCommand struct {
+	Type   DeltaCommandType
+	Offset int64
+	Length int64
+	Data   []byte
+}
+
+func NewCopyCommand(offset int64, length int64) *DeltaCommand {
+	return &DeltaCommand{Type: DeltaCommandCopy, Offset: offset, Length: length}
+}
+
+func NewDataCommand(data []byte) *DeltaCommand {
+	return &DeltaCommand{Type: DeltaCommandData, Length: int64(len(data)), Data: data}
+}
+
+// Delta is an in-memory representation of an entire delta file.
+// Data commands hold their data in memory, so this is only suitable for deltas that comfortably fit in RAM.
+type Delta struct {
+	HashAlgorithm HashAlgorithm
+	ExpectedHash  []byte
+	Commands      []*DeltaCommand
+}
+
+// ReadDelta parses all the commands from `deltaReader` into memory.
+// Consecutive writes from the reader are collected into a single data command.
+func ReadDelta(deltaReader DeltaReader) (*Delta, error) {
+	hashAlgorithm, err := deltaReader.HashAlgorithm()
+	if err != nil {
+		return nil, err
+	}
+	expectedHash, err := deltaReader.ExpectedHash()
+	if err != nil {
+		return nil, err
+	}
+
+	delta := &Delta{
+		HashAlgorithm: hashAlgorithm,
+		ExpectedHash:  expectedHash,
+	}
+	err = delta.readCommands(deltaReader)
+	if err != nil {
+		return nil, err
+	}
+	return delta, nil
+}
+
+// readCommands appends the commands from `deltaReader`, without its metadata, which not every delta has
+func (d *Delta) readCommands(deltaReader DeltaReader) error {
+	return deltaReader.Apply(
+		func(data []byte) error {
+			// readers reuse their buffers, so we must copy the data we are given
+			d.appendData(data)
+			return nil
+		},
+		func(offset int64, length int64) error {
+			d.Commands = append(d.Commands, NewCopyCommand(offset, length))
+			return nil
+		})
+}
+
+func (d *Delta) appendData(data []byte) {
+	if n := len(d.Commands); n > 0 && d.Commands[n-1].Type == DeltaCommandData {
+		last := d.Commands[n-1]
+		last.Data = append(last.Data, data...)
+		last.Length = int64(len(last.Data))
+		return
+	}
+	d.Commands = append(d.Commands, NewDataCommand(append([]byte(nil), data...)))
+}
+
+// NewFileLength returns the length of the file that applying this delta would produce
+func (d *Delta) NewFileLength() int64 {
+	total := int64(0)
+	for _, cmd := range d.Commands {
+		total += cmd.Length
+	}
+	return total
+}
+
+// Write serializes the delta through `deltaWriter`, including the final Flush.
+// Empty commands are skipped.
+func (d *Delta) Write(deltaWriter DeltaWriter) error {
+	if d.HashAlgorithm == nil {
+		return errors.New("delta has no hash algorithm")
+	}
+	err := deltaWriter.WriteMetadata(d.HashAlgorithm, d.ExpectedHash)
+	if err != nil {
+		return err
+	}
+	for _, cmd := range d.Commands {
+		if cmd.Length == 0 {
+			continue
+		}
+		switch cmd.Type {
+		case DeltaCommandCopy:
+			err = deltaWriter.WriteCopyCommand(cmd.Offset, cmd.Length)
+		case DeltaCommandData:
+			err = deltaWriter.WriteDataCommand(bytes.NewReader(cmd.Data), 0, int64(len(cmd.Data)))
+		default:
+			err = errors.New("delta contains an unknown command type")
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return deltaWriter.Flush()
+}
+
+// Apply builds the new file from `basisFile`. See ApplyDelta
+func (d *Delta) Apply(basisFile io.ReadSeeker, output io.Writer) error {
+	return ApplyDelta(basisFile, d.Reader(), output)
+}
+
+// Reader returns a DeltaReader over the in-memory commands, so a Delta can be passed
+// anywhere that would otherwise read a delta file.
+func (d *Delta) Reader() DeltaReader {
+	return &deltaCommandReader{delta: d}
+}
+
+type deltaCommandReader struct {
+	delta *Delta
+}
+
+var _ DeltaReader = (*deltaCommandReader)(nil)
+
+func (r *deltaCommandReader) ExpectedHash() ([]byte, error) {
+	return r.delta.ExpectedHash, nil
+}
+
+func (r *deltaCommandReader) HashAlgorithm() (HashAlgorithm, error) {
+	if r.delta.HashAlgorithm == nil {
+		return nil, errors.New("delta has no hash algorithm")
+	}
+	return r.delta.HashAlgorithm, nil
+}
+
+func (r *deltaCommandReader) Apply(writeData func([]byte) error, copyData func(int64, int64) error) error {
+	for _, cmd := range r.delta.Commands {
+		if cmd.Length == 0 {
+			continue
+		}
+		var err error
+		switch cmd.Type {
+		case DeltaCommandCopy:
+			err = copyData(cmd.Offset, cmd.Length)
+		case DeltaCommandData:
+			err = writeData(cmd.Data)
+		default:
+			err = errors.New("delta contains an unknown command type")
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Optimize rewrites the command list so that it is as small as possible on the wire, without changing the file it produces.
+//   - Empty commands are dropped
+//   - Adjacent data commands are merged
+//   - Copy commands covering contiguous ranges of the basis file are merged
+//   - Copy commands which are smaller written out as literal data are converted into data commands.
+//     This requires reading from the basis file; if `basisFile` is nil this step is skipped.
+func (d *Delta) Optimize(basisFile io.ReaderAt) error {
+	d.Commands = mergeDeltaCommands(d.Commands)
+	if basisFile == nil {
+		return nil
+	}
+
+	commands := d.Commands
+	for i, cmd := range commands {
+		if cmd.Type != DeltaCommandCopy {
+			continue
+		}
+		// work out what the copy would cost as literal data, given that it would merge into any neighbouring data commands
+		literalSize := cmd.Length + binaryDataCommandHeaderSize
+		if i > 0 && commands[i-1].Type == DeltaCommandData {
+			literalSize -= binaryDataCommandHeaderSize
+		}
+		if i < len(commands)-1 && commands[i+1].Type == DeltaCommandData {
+			literalSize -= binaryDataCommandHeaderSize
+		}
+		if literalSize >= binaryCopyCommandSize {
+			continue
+		}
+
+		data := make([]byte, cmd.Length)
+		n, err := basisFile.ReadAt(data, cmd.Offset)
+		if err != nil && !(err == io.EOF && n == len(data)) { // ReaderAt may return EOF alongside a full read at the end of the file
+			return err
+		}
+		commands[i] = NewDataCommand(data)
+	}
+
+	d.Commands = mergeDeltaCommands(commands)
+	return nil
+}
+
+// mergeDeltaCommands returns a new slice where empty commands are removed and adjacent commands are merged where possible.
+// Data slices are not modified in place, as they may be shared with other deltas.
+func mergeDeltaCommands(commands []*DeltaCommand) []*DeltaCommand {
+	result := make([]*DeltaCommand, 0, len(commands))
+	var pendingData []*DeltaCommand // run of adjacent data commands, concatenated once the run ends
+
+	flushData := func() {
+		switch len(pendingData) {
+		case 0:
+			return
+		case 1:
+			result = append(result, pendingData[0])
+		default:
+			size := 0
+			for _, cmd := range pendingData {
+				size += len(cmd.Data)
+			}
+			merged := make([]byte, 0, size)
+			for _, cmd := range pendingData {
+				merged = append(merged, cmd.Data...)
+			}
+			result = append(result, NewDataCommand(merged))
+		}
+		pendingData = pendingData[:0]
+	}
+
+	for _, cmd := range commands {
+		if cmd.Length == 0 {
+			continue
+		}
+		if cmd.Type == DeltaCommandData {
+			pendingData = append(pendingData, cmd)
+			continue
+		}
+		flushData()
+		if len(result) > 0 {
+			last := result[len(result)-1]
+			if last.Type == DeltaCommandCopy && cmd.Type == DeltaCommandCopy && last.Offset+last.Length == cmd.Offset {
+				result[len(result)-1] = NewCopyCommand(last.Offset, last.Length+cmd.Length)
+				continue
+			}
+		}
+		result = append(result, cmd)
+	}
+	flushData()
+	return result
+}
@@ -0,0 +1,124 @@
+package octodiff_test
+
+import (
+	"bytes"
+	"github.com/OctopusDeploy/go-octodiff/pkg/octodiff"
+	"github.com/OctopusDeploy/go-octodiff/pkg/test"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func readDelta(input []byte) *octodiff.Delta {
+	delta, err := octodiff.ReadDelta(octodiff.NewBinaryDeltaReader(bytes.NewReader(input)))
+	if err != nil {
+		panic(err) // should never fail under tests
+	}
+	return delta
+}
+
+func writeDelta(delta *octodiff.Delta) []byte {
+	var output bytes.Buffer
+	err := delta.Write(octodiff.NewBinaryDeltaWriter(&output))
+	if err != nil {
+		panic(err) // should never fail under tests
+	}
+	return output.Bytes()
+}
+
+func largeFileWithDisjointChanges() ([]byte, []byte) {
+	original := test.GenerateTestData(128 * 1024)
+	newFile := append([]byte(nil), original...)
+	newFile[32] = 0xaa
+	newFile[32000] = 0xab
+	newFile[34000] = 0xac
+	return original, newFile
+}
+
+func TestDeltaRoundTripsBinaryDelta(t *testing.T) {
+	original, newFile := largeFileWithDisjointChanges()
+	deltaFile := buildDelta(newFile, buildSignature(original))
+
+	delta := readDelta(deltaFile)
+	assert.Equal(t, "SHA1", delta.HashAlgorithm.Name())
+	assert.Equal(t, int64(len(newFile)), delta.NewFileLength())
+
+	assert.Equal(t, deltaFile, writeDelta(delta))
+}
+
+func TestDeltaApply(t *testing.T) {
+	original, newFile := largeFileWithDisjointChanges()
+	delta := readDelta(buildDelta(newFile, buildSignature(original)))
+
+	var output bytes.Buffer
+	err := delta.Apply(bytes.NewReader(original), &output)
+	assert.Nil(t, err)
+	assert.Equal(t, newFile, output.Bytes())
+}
+
+func TestDeltaReaderCanBeVerified(t *testing.T) {
+	original, newFile := largeFileWithDisjointChanges()
+	delta := readDelta(buildDelta(newFile, buildSignature(original)))
+
+	assert.Nil(t, octodiff.VerifyNewFile(bytes.NewReader(newFile), delta.Reader()))
+	assert.NotNil(t, octodiff.VerifyNewFile(bytes.NewReader(original), delta.Reader()))
+}
+
+func TestDeltaOptimizeMergesAdjacentCommands(t *testing.T) {
+	delta := &octodiff.Delta{
+		HashAlgorithm: octodiff.DefaultHashAlgorithm,
+		Commands: []*octodiff.DeltaCommand{
+			octodiff.NewCopyCommand(0, 100),
+			octodiff.NewCopyCommand(100, 100),
+			octodiff.NewDataCommand(nil),
+			octodiff.NewCopyCommand(200, 100),
+			octodiff.NewDataCommand([]byte{1, 2}),
+			octodiff.NewDataCommand([]byte{3}),
+			octodiff.NewCopyCommand(500, 100),
+			octodiff.NewCopyCommand(0, 100),
+		},
+	}
+
+	err := delta.Optimize(nil)
+	assert.Nil(t, err)
+
+	assert.Equal(t, []*octodiff.DeltaCommand{
+		octodiff.NewCopyCommand(0, 300),
+		octodiff.NewDataCommand([]byte{1, 2, 3}),
+		octodiff.NewCopyCommand(500, 100),
+		octodiff.NewCopyCommand(0, 100),
+	}, delta.Commands)
+}
+
+func TestDeltaOptimizeConvertsTinyCopiesToLiterals(t *testing.T) {
+	basis := test.GenerateTestData(1024)
+	delta := &octodiff.Delta{
+		HashAlgorithm: octodiff.DefaultHashAlgorithm,
+		Commands: []*octodiff.DeltaCommand{
+			octodiff.NewCopyCommand(0, 4),     // standalone: 9+4 bytes of data is smaller than a 17 byte copy
+			octodiff.NewCopyCommand(500, 200), // too big to be worth converting
+			octodiff.NewDataCommand([]byte{0xff}),
+			octodiff.NewCopyCommand(10, 20), // between two data commands, converting saves a data header
+			octodiff.NewDataCommand([]byte{0xfe}),
+			octodiff.NewCopyCommand(800, 20), // follows a data command, but 20 bytes of data is still larger than a copy
+		},
+	}
+
+	var expected bytes.Buffer
+	assert.Nil(t, delta.Apply(bytes.NewReader(basis), &expected))
+
+	err := delta.Optimize(bytes.NewReader(basis))
+	assert.Nil(t, err)
+
+	literal := append([]byte{0xff}, basis[10:30]...)
+	literal = append(literal, 0xfe)
+	assert.Equal(t, []*octodiff.DeltaCommand{
+		octodiff.NewDataCommand(basis[0:4]),
+		octodiff.NewCopyCommand(500, 200),
+		octodiff.NewDataCommand(literal),
+		octodiff.NewCopyCommand(800, 20),
+	}, delta.Commands)
+
+	var actual bytes.Buffer
+	assert.Nil(t, delta.Apply(bytes.NewReader(basis), &actual))
+	assert.Equal(t, expected.Bytes(), actual.Bytes())
+}
@@ -1,24 +1,134 @@
 package octodiff
 
 import (
+	"bufio"
 	"bytes"
-	"errors"
 	"io"
+	"os"
 )
 
+// fileOutputBufferSize is the size of the buffer ApplyDeltaWithOptions writes to an *os.File output through
+const fileOutputBufferSize = 64 * 1024
+
+type ApplyDeltaOptions struct {
+	// Limits, if set, bound the size of the new file and of each copy command, and copy commands are
+	// checked against the length of the basis file. If deltaReader is a *BinaryDeltaReader with no Limits of
+	// its own, it applies the delta with these, so that command counts and data lengths are checked before any data
+	// is read. Its Limits field is left as it was.
+	Limits *DeltaLimits
+
+	// Parallelism, if greater than 1, allows copy commands to run concurrently when the basis file is an io.ReaderAt
+	// and the output is an io.WriterAt (such as *os.File for both). The output is written from offset zero.
+	// Otherwise commands are applied sequentially.
+	Parallelism int
+
+	// Verify, if set, hashes the new file as it is written and compares it with the delta's expected hash,
+	// returning an error if they don't match. This saves reading the new file back with VerifyNewFile.
+	// When copies run in parallel, or use copy_file_range, the new file is read back to hash it instead, so the output
+	// must also be readable; otherwise commands are applied sequentially.
+	Verify bool
+
+	// BufferSize is the size of the buffer copy commands are read from the basis file into; 4MB if zero
+	BufferSize int
+
+	// BufferPool, if set, supplies the buffer instead. If deltaReader is a *BinaryDeltaReader with no BufferPool
+	// of its own, it uses this one too, without its BufferPool field being changed. See BufferPool
+	BufferPool *BufferPool
+}
+
 // ApplyDelta builds thew new file.
 // Verifying the hash of the written file is done seperately, to allow the caller to use
 // a buffered output writer to improve performance.
 func ApplyDelta(basisFile io.ReadSeeker, deltaReader DeltaReader, output io.Writer) error {
-	buffer := make([]byte, defaultReadBufferSize)
+	return ApplyDeltaWithOptions(basisFile, deltaReader, output, ApplyDeltaOptions{})
+}
+
+// ApplyDeltaWithOptions builds the new file as ApplyDelta does, according to `options`.
+// On Linux, when applying sequentially and both basisFile and output are regular files, copy commands use
+// copy_file_range so the copied bytes don't pass through user space. With options.Verify, the output must also be open
+// for reading, as the new file is then read back to hash it. Writes to an *os.File output are
+// buffered internally whichever way it's written, so there's no need to wrap it in a bufio.Writer, which would hide
+// the *os.File and rule out copy_file_range.
+func ApplyDeltaWithOptions(basisFile io.ReadSeeker, deltaReader DeltaReader, output io.Writer, options ApplyDeltaOptions) error {
+	limits, err := newApplyLimits(basisFile, options)
+	if err != nil {
+		return err
+	}
+	deltaReader = withApplyOptions(deltaReader, options)
+
+	var expectedHash []byte
+	var hashAlgorithm HashAlgorithm
+	if options.Verify {
+		// fetch these first, so we don't write anything for a delta that can't be verified
+		hashAlgorithm, err = deltaReader.HashAlgorithm()
+		if err != nil {
+			return err
+		}
+		expectedHash, err = deltaReader.ExpectedHash()
+		if err != nil {
+			return err
+		}
+	}
+
+	if options.Parallelism > 1 {
+		basisFileAt, basisOk := basisFile.(io.ReaderAt)
+		outputAt, outputOk := output.(io.WriterAt)
+		outputReaderAt, outputReadable := output.(io.ReaderAt)
+		if basisOk && outputOk && (outputReadable || !options.Verify) {
+			newFileLength, err := applyDeltaParallel(basisFileAt, deltaReader, outputAt, options.Parallelism, limits)
+			if err != nil || !options.Verify {
+				return err
+			}
+			// the output was written out of order, so it has to be read back to hash it
+			return verifyWrittenFile(outputReaderAt, 0, newFileLength, hashAlgorithm, expectedHash)
+		}
+	}
+
+	if basisFileOnDisk, outputOnDisk, ok := canZeroCopy(basisFile, output, options.Verify); ok {
+		start, err := outputOnDisk.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return err
+		}
+		newFileLength, err := applyDeltaFileToFile(basisFileOnDisk, deltaReader, outputOnDisk, limits)
+		if err != nil || !options.Verify {
+			return err
+		}
+		// copied bytes never passed through here, so the output has to be read back to hash it
+		return verifyWrittenFile(outputOnDisk, start, newFileLength, hashAlgorithm, expectedHash)
+	}
+
+	var outputStream *bufio.Writer
+	if outputFile, ok := output.(*os.File); ok {
+		outputStream = bufio.NewWriterSize(outputFile, fileOutputBufferSize)
+		output = outputStream
+	}
+
+	var hasher *streamingHasher
+	if options.Verify {
+		hasher = newStreamingHasher(hashAlgorithm)
+		defer hasher.close()
+		output = io.MultiWriter(output, hasher)
+	}
+
+	buffer, releaseBuffer := getBuffer(options.BufferPool, options.BufferSize, defaultReadBufferSize)
+	defer releaseBuffer()
 
-	return deltaReader.Apply(
+	err = deltaReader.Apply(
 		func(bytes []byte) error {
-			_, err := output.Write(bytes)
+			err := limits.checkData(bytes)
+			if err != nil {
+				return err
+			}
+			_, err = output.Write(bytes)
 			return err
 		},
 		func(offset int64, length int64) error {
-			_, err := basisFile.Seek(offset, io.SeekStart)
+			err := limits.checkCopy(offset, length)
+			if err != nil {
+				return err
+			}
+
+			_, err = basisFile.Seek(offset, io.SeekStart)
 			if err != nil {
 				return err
 			}
@@ -32,6 +142,85 @@ func ApplyDelta(basisFile io.ReadSeeker, deltaReader DeltaReader, output io.Writ
 			}
 			return iter.Err()
 		})
+	if err == nil && outputStream != nil {
+		err = outputStream.Flush()
+	}
+	if err != nil || hasher == nil {
+		return err
+	}
+
+	actualHash, err := hasher.sum()
+	if err != nil {
+		return err
+	}
+	return checkNewFileHash(hashAlgorithm, expectedHash, actualHash)
+}
+
+// applyLimits enforces ApplyDeltaOptions.Limits from the callbacks passed to DeltaReader.Apply
+type applyLimits struct {
+	tracker         deltaLimitTracker
+	basisFileLength int64 // -1 if not checking limits
+}
+
+// withApplyOptions gives a *BinaryDeltaReader without limits or a buffer pool of its own the ones from `options`,
+// so that command counts and data lengths are checked before any data is read. The caller's reader isn't changed.
+func withApplyOptions(deltaReader DeltaReader, options ApplyDeltaOptions) DeltaReader {
+	binaryDeltaReader, ok := deltaReader.(*BinaryDeltaReader)
+	if !ok {
+		return deltaReader
+	}
+	withOptions := &binaryDeltaReaderWithOptions{BinaryDeltaReader: binaryDeltaReader, limits: binaryDeltaReader.Limits, bufferPool: binaryDeltaReader.BufferPool}
+	if withOptions.limits == nil {
+		withOptions.limits = options.Limits
+	}
+	if withOptions.bufferPool == nil {
+		withOptions.bufferPool = options.BufferPool
+	}
+	return withOptions
+}
+
+func newApplyLimits(basisFile io.Seeker, options ApplyDeltaOptions) (*applyLimits, error) {
+	limits := &applyLimits{basisFileLength: -1}
+	if options.Limits == nil {
+		return limits, nil
+	}
+
+	limits.tracker.limits = *options.Limits
+
+	var err error
+	limits.basisFileLength, err = basisFile.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	return limits, nil
+}
+
+func (l *applyLimits) checkData(data []byte) error {
+	return l.tracker.addOutput(int64(len(data)))
+}
+
+func (l *applyLimits) checkCopy(offset int64, length int64) error {
+	if l.basisFileLength < 0 {
+		return nil
+	}
+	if offset < 0 || length < 0 || offset > l.basisFileLength || length > l.basisFileLength-offset {
+		return &CopyOutOfRangeError{Offset: offset, Length: length, BasisFileLength: l.basisFileLength}
+	}
+	err := checkLimit(LimitCopyLength, l.tracker.limits.MaxCopyLength, length)
+	if err != nil {
+		return err
+	}
+	return l.tracker.addOutput(length)
+}
+
+// verifyWrittenFile checks the hash of the `length` bytes of the new file written to `output` from `offset`
+func verifyWrittenFile(output io.ReaderAt, offset int64, length int64, hashAlgorithm HashAlgorithm, expectedHash []byte) error {
+	newFile := bufio.NewReaderSize(io.NewSectionReader(output, offset, length), defaultReadBufferSize)
+	actualHash, err := hashAlgorithm.HashOverReader(newFile)
+	if err != nil {
+		return err
+	}
+	return checkNewFileHash(hashAlgorithm, expectedHash, actualHash)
 }
 
 func VerifyNewFile(newFile io.Reader, deltaReader DeltaReader) error {
@@ -48,9 +237,12 @@ func VerifyNewFile(newFile io.Reader, deltaReader DeltaReader) error {
 	if err != nil {
 		return err
 	}
+	return checkNewFileHash(algorithm, sourceFileHash, actualHash)
+}
 
-	if !bytes.Equal(sourceFileHash, actualHash) {
-		return errors.New("verification of the patched file failed. The SHA1 hash of the patch result file, and the file that was used as input for the delta, do not match. This can happen if the basis file changed since the signatures were calculated")
+func checkNewFileHash(hashAlgorithm HashAlgorithm, expectedHash []byte, actualHash []byte) error {
+	if !bytes.Equal(expectedHash, actualHash) {
+		return &VerificationError{HashAlgorithm: hashAlgorithm.Name(), ExpectedHash: expectedHash, ActualHash: actualHash}
 	}
 	return nil
 }
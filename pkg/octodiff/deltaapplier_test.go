@@ -0,0 +1,107 @@
+package octodiff_test
+
+import (
+	"bytes"
+	"errors"
+	"github.com/OctopusDeploy/go-octodiff/pkg/octodiff"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"testing"
+)
+
+func applyAndVerify(basis []byte, deltaReader octodiff.DeltaReader) ([]byte, error) {
+	var output bytes.Buffer
+	err := octodiff.ApplyDeltaWithOptions(bytes.NewReader(basis), deltaReader, &output, octodiff.ApplyDeltaOptions{Verify: true})
+	return output.Bytes(), err
+}
+
+func TestApplyDeltaWithVerification(t *testing.T) {
+	original, newFile := largeFileWithDisjointChanges()
+	deltaFile := buildDelta(newFile, buildSignature(original))
+
+	output, err := applyAndVerify(original, octodiff.NewBinaryDeltaReader(bytes.NewReader(deltaFile)))
+	assert.Nil(t, err)
+	assert.Equal(t, newFile, output)
+
+	// the basis file has changed since the signature was taken, in a part which the delta copies
+	changedOriginal := append([]byte(nil), original...)
+	changedOriginal[64*1024]++
+	_, err = applyAndVerify(changedOriginal, octodiff.NewBinaryDeltaReader(bytes.NewReader(deltaFile)))
+	assert.EqualError(t, err, "verification of the patched file failed. The SHA1 hash of the patch result file, and the file that was used as input for the delta, do not match. This can happen if the basis file changed since the signatures were calculated")
+}
+
+// otherSha1 is a HashAlgorithm from outside the package, which can only hash via HashOverReader
+type otherSha1 struct {
+	octodiff.HashAlgorithm
+}
+
+func (o otherSha1) HashOverReader(reader io.Reader) ([]byte, error) {
+	return o.HashAlgorithm.HashOverReader(reader)
+}
+
+func TestApplyDeltaWithVerificationOfOtherHashAlgorithm(t *testing.T) {
+	original, newFile := largeFileWithDisjointChanges()
+	delta := readDelta(buildDelta(newFile, buildSignature(original)))
+	delta.HashAlgorithm = otherSha1{octodiff.DefaultHashAlgorithm}
+
+	output, err := applyAndVerify(original, delta.Reader())
+	assert.Nil(t, err)
+	assert.Equal(t, newFile, output)
+
+	delta.ExpectedHash = make([]byte, 20)
+	_, err = applyAndVerify(original, delta.Reader())
+	assert.NotNil(t, err)
+}
+
+func TestApplyDeltaWithVerificationRequiresExpectedHash(t *testing.T) {
+	original, newFile := largeFileWithDisjointChanges()
+	var deltaFile bytes.Buffer
+	signature := buildSignature(original)
+	err := octodiff.NewDeltaBuilder().Build(bytes.NewReader(newFile), int64(len(newFile)), bytes.NewReader(signature), int64(len(signature)), octodiff.NewRdiffDeltaWriter(&deltaFile))
+	assert.Nil(t, err)
+
+	output, err := applyAndVerify(original, octodiff.NewRdiffDeltaReader(bytes.NewReader(deltaFile.Bytes())))
+	assert.True(t, errors.Is(err, octodiff.ErrNoExpectedHash))
+	assert.Empty(t, output)
+}
+
+func TestDeltaReadersReportProgress(t *testing.T) {
+	original, newFile := largeFileWithDisjointChanges()
+	signature := buildSignature(original)
+	build := func(deltaWriter func(io.Writer) octodiff.DeltaWriter) []byte {
+		var output bytes.Buffer
+		err := octodiff.NewDeltaBuilder().Build(bytes.NewReader(newFile), int64(len(newFile)), bytes.NewReader(signature), int64(len(signature)), deltaWriter(&output))
+		assert.Nil(t, err)
+		return output.Bytes()
+	}
+	binaryDelta := build(func(w io.Writer) octodiff.DeltaWriter { return octodiff.NewBinaryDeltaWriter(w) })
+	vcdiffDelta := build(func(w io.Writer) octodiff.DeltaWriter { return octodiff.NewVcdiffDeltaWriter(w) })
+	rdiffDelta := build(func(w io.Writer) octodiff.DeltaWriter { return octodiff.NewRdiffDeltaWriter(w) })
+
+	var events []octodiff.ProgressEvent
+	reporter := octodiff.NewProgressEventReporter(func(event octodiff.ProgressEvent) {
+		events = append(events, event)
+	})
+	binaryReader := octodiff.NewBinaryDeltaReader(bytes.NewReader(binaryDelta))
+	binaryReader.ProgressReporter, binaryReader.DeltaLength = reporter, int64(len(binaryDelta))
+	vcdiffReader := octodiff.NewVcdiffDeltaReader(bytes.NewReader(vcdiffDelta))
+	vcdiffReader.ProgressReporter, vcdiffReader.DeltaLength = reporter, int64(len(vcdiffDelta))
+	rdiffReader := octodiff.NewRdiffDeltaReader(bytes.NewReader(rdiffDelta))
+	rdiffReader.ProgressReporter, rdiffReader.DeltaLength = reporter, int64(len(rdiffDelta))
+
+	for _, deltaReader := range []octodiff.DeltaReader{binaryReader, vcdiffReader, rdiffReader} {
+		events = nil
+		var output bytes.Buffer
+		err := octodiff.ApplyDelta(bytes.NewReader(original), deltaReader, &output)
+		assert.Nil(t, err)
+		assert.Equal(t, newFile, output.Bytes())
+
+		if assert.NotEmpty(t, events) {
+			last := events[len(events)-1]
+			assert.Equal(t, "Applying delta", last.Operation)
+			assert.Equal(t, last.Total, last.Position) // finished
+			assert.GreaterOrEqual(t, len(events), 2)   // at least the start and the end
+		}
+	}
+	assert.Equal(t, int64(len(rdiffDelta)), events[len(events)-1].Total)
+}
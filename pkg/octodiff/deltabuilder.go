@@ -2,6 +2,7 @@ package octodiff
 
 import (
 	"bytes"
+	"fmt"
 	"io"
 	"math"
 	"sort"
@@ -9,11 +10,18 @@ import (
 
 type DeltaBuilder struct {
 	ProgressReporter ProgressReporter
+
+	// BufferSize is how much of the new file is read at a time; 4MB if zero.
+	// It must be at least as big as the largest chunk in the signature.
+	BufferSize int
+	// BufferPool, if set, supplies the buffer instead. See BufferPool
+	BufferPool *BufferPool
 }
 
 func NewDeltaBuilder() *DeltaBuilder {
 	return &DeltaBuilder{
 		ProgressReporter: NopProgressReporter(),
+		BufferSize:       defaultReadBufferSize,
 	}
 }
 
@@ -28,9 +36,14 @@ func (d *DeltaBuilder) Build(newFile io.ReadSeeker, newFileLength int64, signatu
 	if err != nil {
 		return err
 	}
+	return d.BuildFromSignature(newFile, newFileLength, signature, deltaWriter)
+}
 
-	chunks := signature.Chunks
-	hash, err := signature.HashAlgorithm.HashOverReader(newFile)
+// BuildFromSignature is Build for a signature which has already been read
+func (d *DeltaBuilder) BuildFromSignature(newFile io.ReadSeeker, newFileLength int64, signature *Signature, deltaWriter DeltaWriter) error {
+	chunks := append([]*ChunkSignature(nil), signature.Chunks...) // they get sorted, which the caller won't expect
+	hashAlgorithm := newFileHashAlgorithm(signature)
+	hash, err := hashAlgorithm.HashOverReader(newFile)
 	if err != nil {
 		return err
 	}
@@ -39,24 +52,22 @@ func (d *DeltaBuilder) Build(newFile io.ReadSeeker, newFileLength int64, signatu
 		return err
 	}
 
-	err = deltaWriter.WriteMetadata(signature.HashAlgorithm, hash)
+	err = deltaWriter.WriteMetadata(hashAlgorithm, hash)
 	if err != nil {
 		return err
 	}
 
-	sort.Slice(chunks, func(i, j int) bool {
-		// aligns with C# ChunkSignatureChecksumComparer
-		x, y := chunks[i], chunks[j]
-		if x.RollingChecksum == y.RollingChecksum {
-			return x.StartOffset < y.StartOffset
-		}
-		return x.RollingChecksum < y.RollingChecksum
-	})
+	sortChunkSignatures(chunks)
+	chunkMap, minChunkSize, maxChunkSize := createChunkMap(chunks, d.ProgressReporter)
 
-	chunkMap, minChunkSize, maxChunkSize := d.createChunkMap(chunks)
+	buffer, releaseBuffer := getBuffer(d.BufferPool, d.BufferSize, defaultReadBufferSize)
+	defer releaseBuffer()
+	if len(buffer) < maxChunkSize {
+		// we seek back by a chunk each time round the loop below, so would never get anywhere
+		return fmt.Errorf("the delta builder's buffer of %d bytes is smaller than the largest chunk in the signature, of %d bytes", len(buffer), maxChunkSize)
+	}
 
 	lastMatchPosition := int64(0)
-	buffer := make([]byte, defaultReadBufferSize)
 	d.ProgressReporter.ReportProgress("Building delta", int64(0), newFileLength)
 
 	startPosition := int64(0)
@@ -86,8 +97,6 @@ func (d *DeltaBuilder) Build(newFile io.ReadSeeker, newFileLength int64, signatu
 					checksum = checksumAlgorithm.Rotate(checksum, remove, add, remainingPossibleChunkSize)
 				}
 
-				d.ProgressReporter.ReportProgress("Building delta", readSoFar, newFileLength)
-
 				if readSoFar-(lastMatchPosition-int64(remainingPossibleChunkSize)) < int64(remainingPossibleChunkSize) {
 					continue
 				}
@@ -123,6 +132,8 @@ func (d *DeltaBuilder) Build(newFile io.ReadSeeker, newFileLength int64, signatu
 					}
 				}
 			}
+			// reported per buffer rather than per byte, as calling the reporter in the loop above is a noticeable cost
+			d.ProgressReporter.ReportProgress("Building delta", startPosition+int64(bytesRead), newFileLength)
 		}
 		if fileReadErr != nil {
 			if fileReadErr == io.EOF { // all done
@@ -157,9 +168,20 @@ func (d *DeltaBuilder) Build(newFile io.ReadSeeker, newFileLength int64, signatu
 	return deltaWriter.Flush()
 }
 
-// returns chunkMap, minChunkSize, maxChunkSize
-func (d *DeltaBuilder) createChunkMap(chunks []*ChunkSignature) (map[uint32]int, int, int) {
-	d.ProgressReporter.ReportProgress("Creating chunk map", 0, int64(len(chunks)))
+func sortChunkSignatures(chunks []*ChunkSignature) {
+	sort.Slice(chunks, func(i, j int) bool {
+		// aligns with C# ChunkSignatureChecksumComparer
+		x, y := chunks[i], chunks[j]
+		if x.RollingChecksum == y.RollingChecksum {
+			return x.StartOffset < y.StartOffset
+		}
+		return x.RollingChecksum < y.RollingChecksum
+	})
+}
+
+// returns chunkMap, minChunkSize, maxChunkSize. `chunks` must be sorted with sortChunkSignatures
+func createChunkMap(chunks []*ChunkSignature, progressReporter ProgressReporter) (map[uint32]int, int, int) {
+	progressReporter.ReportProgress("Creating chunk map", 0, int64(len(chunks)))
 
 	maxChunkSize := uint16(0)
 	minChunkSize := uint16(math.MaxUint16)
@@ -177,7 +199,7 @@ func (d *DeltaBuilder) createChunkMap(chunks []*ChunkSignature) (map[uint32]int,
 		if _, ok := chunkMap[chunk.RollingChecksum]; !ok {
 			chunkMap[chunk.RollingChecksum] = chunkIdx
 		}
-		d.ProgressReporter.ReportProgress("Creating chunk map", int64(chunkIdx), int64(len(chunks)))
+		progressReporter.ReportProgress("Creating chunk map", int64(chunkIdx+1), int64(len(chunks)))
 	}
 	return chunkMap, int(minChunkSize), int(maxChunkSize)
 }
@@ -0,0 +1,81 @@
+package octodiff
+
+import (
+	"errors"
+	"fmt"
+)
+
+// DeltaLimits bounds the resources that applying a delta may use, for deltas from untrusted sources.
+// A zero value for any field means that aspect is unlimited.
+type DeltaLimits struct {
+	MaxOutputSize        int64 // total bytes written to the new file
+	MaxCommandCount      int64 // number of copy and data commands in the delta
+	MaxDataCommandLength int64 // bytes in any single data command
+	MaxCopyLength        int64 // bytes copied from the basis file by any single copy command
+}
+
+type DeltaLimit string
+
+const (
+	LimitOutputSize        DeltaLimit = "output size"
+	LimitCommandCount      DeltaLimit = "command count"
+	LimitDataCommandLength DeltaLimit = "data command length"
+	LimitCopyLength        DeltaLimit = "copy length"
+)
+
+// ErrLimitExceeded matches any LimitExceededError when used with errors.Is
+var ErrLimitExceeded = errors.New("delta exceeds resource limit")
+
+// LimitExceededError is returned when applying a delta would go beyond one of its DeltaLimits
+type LimitExceededError struct {
+	Limit  DeltaLimit
+	Max    int64
+	Actual int64
+}
+
+func (e *LimitExceededError) Error() string {
+	return fmt.Sprintf("delta exceeds %s limit: %d is greater than the maximum of %d", e.Limit, e.Actual, e.Max)
+}
+
+func (e *LimitExceededError) Is(target error) bool {
+	return target == ErrLimitExceeded
+}
+
+// CopyOutOfRangeError is returned when a copy command refers to data outside the basis file
+type CopyOutOfRangeError struct {
+	Offset          int64
+	Length          int64
+	BasisFileLength int64
+}
+
+func (e *CopyOutOfRangeError) Error() string {
+	return fmt.Sprintf("delta copy command of %d bytes from offset %d is outside the %d byte basis file", e.Length, e.Offset, e.BasisFileLength)
+}
+
+// checks `actual` against `max`, where max of zero is unlimited
+func checkLimit(limit DeltaLimit, max int64, actual int64) error {
+	if max > 0 && actual > max {
+		return &LimitExceededError{Limit: limit, Max: max, Actual: actual}
+	}
+	return nil
+}
+
+// deltaLimitTracker accumulates the totals which are checked against DeltaLimits as a delta is applied
+type deltaLimitTracker struct {
+	limits       DeltaLimits
+	outputSize   int64
+	commandCount int64
+}
+
+func (t *deltaLimitTracker) addCommand() error {
+	t.commandCount++
+	return checkLimit(LimitCommandCount, t.limits.MaxCommandCount, t.commandCount)
+}
+
+func (t *deltaLimitTracker) addOutput(length int64) error {
+	t.outputSize += length
+	if t.outputSize < 0 { // overflowed
+		return &LimitExceededError{Limit: LimitOutputSize, Max: t.limits.MaxOutputSize, Actual: t.outputSize}
+	}
+	return checkLimit(LimitOutputSize, t.limits.MaxOutputSize, t.outputSize)
+}
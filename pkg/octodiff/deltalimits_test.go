@@ -0,0 +1,92 @@
+package octodiff_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"github.com/OctopusDeploy/go-octodiff/pkg/octodiff"
+	"github.com/OctopusDeploy/go-octodiff/pkg/test"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func applyWithLimits(basis []byte, deltaFile []byte, limits *octodiff.DeltaLimits) ([]byte, error) {
+	var output bytes.Buffer
+	err := octodiff.ApplyDeltaWithOptions(
+		bytes.NewReader(basis),
+		octodiff.NewBinaryDeltaReader(bytes.NewReader(deltaFile)),
+		&output,
+		octodiff.ApplyDeltaOptions{Limits: limits})
+	return output.Bytes(), err
+}
+
+func TestApplyWithinLimits(t *testing.T) {
+	original, newFile := largeFileWithDisjointChanges()
+	deltaFile := buildDelta(newFile, buildSignature(original))
+
+	output, err := applyWithLimits(original, deltaFile, &octodiff.DeltaLimits{
+		MaxOutputSize:        int64(len(newFile)),
+		MaxCommandCount:      100,
+		MaxDataCommandLength: 16 * 1024,
+		MaxCopyLength:        int64(len(original)),
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, newFile, output)
+}
+
+func TestApplyExceedingLimits(t *testing.T) {
+	original, newFile := largeFileWithDisjointChanges()
+	deltaFile := buildDelta(newFile, buildSignature(original))
+
+	tests := []struct {
+		limits   octodiff.DeltaLimits
+		expected octodiff.DeltaLimit
+	}{
+		{octodiff.DeltaLimits{MaxOutputSize: int64(len(newFile)) - 1}, octodiff.LimitOutputSize},
+		{octodiff.DeltaLimits{MaxCommandCount: 2}, octodiff.LimitCommandCount},
+		{octodiff.DeltaLimits{MaxDataCommandLength: 1024}, octodiff.LimitDataCommandLength},
+		{octodiff.DeltaLimits{MaxCopyLength: 1024}, octodiff.LimitCopyLength},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.expected), func(t *testing.T) {
+			_, err := applyWithLimits(original, deltaFile, &tt.limits)
+
+			assert.True(t, errors.Is(err, octodiff.ErrLimitExceeded))
+			var limitErr *octodiff.LimitExceededError
+			assert.True(t, errors.As(err, &limitErr))
+			assert.Equal(t, tt.expected, limitErr.Limit)
+		})
+	}
+}
+
+func TestApplyWithLimitsDoesNotChangeTheDeltaReader(t *testing.T) {
+	original, newFile := largeFileWithDisjointChanges()
+	deltaReader := octodiff.NewBinaryDeltaReader(bytes.NewReader(buildDelta(newFile, buildSignature(original))))
+
+	options := octodiff.ApplyDeltaOptions{Limits: &octodiff.DeltaLimits{MaxCommandCount: 2}, BufferPool: octodiff.NewBufferPool(1024)}
+	err := octodiff.ApplyDeltaWithOptions(bytes.NewReader(original), deltaReader, &bytes.Buffer{}, options)
+	assert.ErrorIs(t, err, octodiff.ErrLimitExceeded)
+
+	// the limits were applied, but the reader doesn't keep them
+	assert.Nil(t, deltaReader.Limits)
+	assert.Nil(t, deltaReader.BufferPool)
+}
+
+func TestApplyWithLimitsRejectsCopyBeyondBasisFile(t *testing.T) {
+	// no-op delta copying 520 bytes, applied to a smaller basis file
+	deltaFile, _ := hex.DecodeString("4f43544f44454c544101045348413114000000330bd06982d3b5dbda6c1a6ad16687a0cdb03c0d3e3e3e6000000000000000000802000000000000")
+
+	_, err := applyWithLimits(test.GenerateTestData(500), deltaFile, &octodiff.DeltaLimits{})
+
+	var rangeErr *octodiff.CopyOutOfRangeError
+	assert.True(t, errors.As(err, &rangeErr))
+	assert.Equal(t, octodiff.CopyOutOfRangeError{Offset: 0, Length: 520, BasisFileLength: 500}, *rangeErr)
+}
+
+func TestApplyRejectsNegativeDataLength(t *testing.T) {
+	deltaFile, _ := hex.DecodeString("4f43544f44454c544101045348413114000000330bd06982d3b5dbda6c1a6ad16687a0cdb03c0d3e3e3e80ffffffffffffffffaabbcc")
+
+	_, err := applyWithLimits(test.TestData(), deltaFile, nil)
+	assert.EqualError(t, err, "the delta file appears to be corrupt; data command has a negative length (at offset 42)")
+	assert.ErrorIs(t, err, octodiff.ErrCorruptCommand)
+}
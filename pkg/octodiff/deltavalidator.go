@@ -0,0 +1,245 @@
+package octodiff
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// DeltaProblem describes something wrong with a delta file, and where in the file it was found
+type DeltaProblem struct {
+	Offset  int64
+	Message string
+}
+
+func (p DeltaProblem) String() string {
+	return fmt.Sprintf("offset %d: %s", p.Offset, p.Message)
+}
+
+type DeltaValidationResult struct {
+	Problems      []DeltaProblem
+	CommandCount  int64
+	NewFileLength int64 // the length of the file the delta would produce, as far as it could be read
+}
+
+func (r *DeltaValidationResult) Valid() bool {
+	return len(r.Problems) == 0
+}
+
+// ValidateDelta reads a binary delta file from `input` and checks its structure, version and hash metadata,
+// and that every command is well-formed. If `basisFileLength` is not negative, copy commands are also checked
+// to be within the basis file.
+// Nothing is written and data is not buffered, so this is safe to run over untrusted deltas of any size.
+// All problems found are reported in the result; an error is only returned if `input` itself fails.
+// Some problems (such as a corrupt header or unknown command) mean the rest of the file can't be interpreted,
+// in which case validation stops there.
+func ValidateDelta(input io.Reader, basisFileLength int64) (*DeltaValidationResult, error) {
+	v := &deltaValidator{input: input, basisFileLength: basisFileLength, result: &DeltaValidationResult{}}
+	err := v.validate()
+	if err == errStopValidation {
+		err = nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return v.result, nil
+}
+
+// returned internally when the delta is too broken to carry on reading
+var errStopValidation = errors.New("stop validation")
+
+type deltaValidator struct {
+	input           io.Reader
+	pos             int64
+	basisFileLength int64
+	result          *DeltaValidationResult
+}
+
+func (v *deltaValidator) problem(offset int64, format string, args ...any) {
+	v.result.Problems = append(v.result.Problems, DeltaProblem{Offset: offset, Message: fmt.Sprintf(format, args...)})
+}
+
+// read fills `buffer`, recording a problem and stopping validation if the file ends first
+func (v *deltaValidator) read(buffer []byte, what string) error {
+	start := v.pos
+	n, err := io.ReadFull(v.input, buffer)
+	v.pos += int64(n)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		v.problem(start, "file is truncated; expecting %d bytes for %s but only %d were available", len(buffer), what, n)
+		return errStopValidation
+	}
+	return err
+}
+
+func (v *deltaValidator) readInt64(what string) (int64, error) {
+	buffer := make([]byte, 8)
+	if err := v.read(buffer, what); err != nil {
+		return 0, err
+	}
+	return int64(binary.LittleEndian.Uint64(buffer)), nil
+}
+
+func (v *deltaValidator) validate() error {
+	err := v.validateMetadata()
+	if err != nil {
+		return err
+	}
+
+	cmdTypeByte := make([]byte, 1)
+	for {
+		cmdOffset := v.pos
+		n, err := io.ReadFull(v.input, cmdTypeByte)
+		if n == 0 && err == io.EOF {
+			return nil // all done
+		}
+		if err != nil {
+			return err
+		}
+		v.pos += int64(n)
+		v.result.CommandCount++
+
+		switch {
+		case bytes.Equal(cmdTypeByte, BinaryCopyCommand):
+			err = v.validateCopyCommand(cmdOffset)
+		case bytes.Equal(cmdTypeByte, BinaryDataCommand):
+			err = v.validateDataCommand(cmdOffset)
+		default:
+			v.problem(cmdOffset, "unexpected command byte 0x%02x", cmdTypeByte[0])
+			err = errStopValidation
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (v *deltaValidator) validateMetadata() error {
+	headerBytes := make([]byte, len(BinaryDeltaHeader))
+	if err := v.read(headerBytes, "the header"); err != nil {
+		return err
+	}
+	if !bytes.Equal(headerBytes, BinaryDeltaHeader) {
+		v.problem(0, "file does not start with the %s header", BinaryDeltaHeader)
+		return errStopValidation
+	}
+
+	versionBytes := make([]byte, len(BinaryVersion))
+	if err := v.read(versionBytes, "the version"); err != nil {
+		return err
+	}
+	if !bytes.Equal(versionBytes, BinaryVersion) {
+		v.problem(v.pos-int64(len(versionBytes)), "unsupported file format version %d", versionBytes[0])
+		return errStopValidation
+	}
+
+	hashAlgorithmOffset := v.pos
+	nameLength, n, err := read7BitEncodedInt(v.input)
+	v.pos += int64(n)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		v.problem(hashAlgorithmOffset, "file is truncated; expecting the hash algorithm name length")
+		return errStopValidation
+	}
+	if err != nil {
+		return err
+	}
+	if nameLength < 0 || nameLength > maxLengthPrefixedStringLength {
+		v.problem(hashAlgorithmOffset, "invalid hash algorithm name length %d", nameLength)
+		return errStopValidation
+	}
+	hashAlgorithmName := make([]byte, nameLength)
+	if err := v.read(hashAlgorithmName, "the hash algorithm name"); err != nil {
+		return err
+	}
+	expectedHashLength := -1
+	if string(hashAlgorithmName) == DefaultHashAlgorithm.Name() {
+		expectedHashLength = DefaultHashAlgorithm.HashLength()
+	} else {
+		v.problem(hashAlgorithmOffset, "unsupported hash algorithm %q", hashAlgorithmName)
+	}
+
+	hashLengthOffset := v.pos
+	hashLengthBytes := make([]byte, 4)
+	if err := v.read(hashLengthBytes, "the hash length"); err != nil {
+		return err
+	}
+	hashLength := int32(binary.LittleEndian.Uint32(hashLengthBytes))
+	if hashLength < 0 || hashLength > 1024 { // no sane hash algorithm produces hashes this large; don't try to read it
+		v.problem(hashLengthOffset, "invalid hash length %d", hashLength)
+		return errStopValidation
+	}
+	if expectedHashLength >= 0 && int(hashLength) != expectedHashLength {
+		v.problem(hashLengthOffset, "hash length %d does not match the %d bytes produced by %s", hashLength, expectedHashLength, hashAlgorithmName)
+	}
+	if err := v.read(make([]byte, hashLength), "the expected hash"); err != nil {
+		return err
+	}
+
+	endOfMetaBytes := make([]byte, len(BinaryEndOfMetadata))
+	if err := v.read(endOfMetaBytes, "the end of metadata marker"); err != nil {
+		return err
+	}
+	if !bytes.Equal(endOfMetaBytes, BinaryEndOfMetadata) {
+		v.problem(v.pos-int64(len(endOfMetaBytes)), "missing end of metadata marker")
+		return errStopValidation
+	}
+	return nil
+}
+
+func (v *deltaValidator) validateCopyCommand(cmdOffset int64) error {
+	start, err := v.readInt64("the copy command offset")
+	if err != nil {
+		return err
+	}
+	length, err := v.readInt64("the copy command length")
+	if err != nil {
+		return err
+	}
+
+	if start < 0 {
+		v.problem(cmdOffset, "copy command has negative offset %d", start)
+	}
+	if length < 0 {
+		v.problem(cmdOffset, "copy command has negative length %d", length)
+		return nil
+	}
+	if v.basisFileLength >= 0 && start >= 0 && (start > v.basisFileLength || length > v.basisFileLength-start) {
+		v.problem(cmdOffset, "copy command of %d bytes from offset %d is beyond the end of the %d byte basis file", length, start, v.basisFileLength)
+	}
+	v.addNewFileLength(cmdOffset, length)
+	return nil
+}
+
+func (v *deltaValidator) validateDataCommand(cmdOffset int64) error {
+	length, err := v.readInt64("the data command length")
+	if err != nil {
+		return err
+	}
+	if length < 0 {
+		v.problem(cmdOffset, "data command has negative length %d", length)
+		return errStopValidation // we don't know where the next command starts
+	}
+
+	dataOffset := v.pos
+	n, err := io.CopyN(io.Discard, v.input, length)
+	v.pos += n
+	if err == io.EOF {
+		v.problem(dataOffset, "file is truncated; data command declares %d bytes but only %d were available", length, n)
+		return errStopValidation
+	}
+	if err != nil {
+		return err
+	}
+	v.addNewFileLength(cmdOffset, length)
+	return nil
+}
+
+func (v *deltaValidator) addNewFileLength(cmdOffset int64, length int64) {
+	if v.result.NewFileLength > math.MaxInt64-length {
+		v.problem(cmdOffset, "new file length overflows")
+		return
+	}
+	v.result.NewFileLength += length
+}
@@ -0,0 +1,87 @@
+package octodiff_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"github.com/OctopusDeploy/go-octodiff/pkg/octodiff"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func validateDelta(t *testing.T, hexInput string, basisFileLength int64) *octodiff.DeltaValidationResult {
+	input, _ := hex.DecodeString(hexInput)
+	result, err := octodiff.ValidateDelta(bytes.NewReader(input), basisFileLength)
+	assert.Nil(t, err)
+	return result
+}
+
+func problemStrings(result *octodiff.DeltaValidationResult) []string {
+	problems := make([]string, 0, len(result.Problems))
+	for _, p := range result.Problems {
+		problems = append(problems, p.String())
+	}
+	return problems
+}
+
+const validDeltaHeader = "4f43544f44454c544101045348413114000000e5ca5051b8cf462ed567a8f88802fd9e62a0f0e83e3e3e"
+
+func TestValidatesGoodDelta(t *testing.T) {
+	// prepend delta: one data command then a copy of the 520 byte basis
+	result := validateDelta(t, validDeltaHeader+"800100000000000000aa6000000000000000000802000000000000", 520)
+
+	assert.True(t, result.Valid())
+	assert.Equal(t, int64(2), result.CommandCount)
+	assert.Equal(t, int64(521), result.NewFileLength)
+}
+
+func TestValidateReportsCopyBeyondBasisFile(t *testing.T) {
+	// copy of 520 bytes from the start, but the basis is only 500 bytes; then a copy with negative offset
+	result := validateDelta(t, validDeltaHeader+"6000000000000000000802000000000000"+"60ffffffffffffffff0100000000000000", 500)
+
+	assert.False(t, result.Valid())
+	assert.Equal(t, []string{
+		"offset 42: copy command of 520 bytes from offset 0 is beyond the end of the 500 byte basis file",
+		"offset 59: copy command has negative offset -1",
+	}, problemStrings(result))
+}
+
+func TestValidateIgnoresBasisLengthWhenUnknown(t *testing.T) {
+	result := validateDelta(t, validDeltaHeader+"6000000000000000000802000000000000", -1)
+
+	assert.True(t, result.Valid())
+}
+
+func TestValidateReportsTruncatedDataCommand(t *testing.T) {
+	// data command claims 4GB but only has 2 bytes
+	result := validateDelta(t, validDeltaHeader+"800000000001000000aaaa", -1)
+
+	assert.Equal(t, []string{
+		"offset 51: file is truncated; data command declares 4294967296 bytes but only 2 were available",
+	}, problemStrings(result))
+}
+
+func TestValidateReportsUnknownCommand(t *testing.T) {
+	result := validateDelta(t, validDeltaHeader+"6000000000000000000802000000000000ff", -1)
+
+	assert.Equal(t, []string{"offset 59: unexpected command byte 0xff"}, problemStrings(result))
+	assert.Equal(t, int64(2), result.CommandCount)
+}
+
+func TestValidateReportsBadMetadata(t *testing.T) {
+	assert.Equal(t, []string{"offset 0: file does not start with the OCTODELTA header"},
+		problemStrings(validateDelta(t, "4f43544f5349470104", -1)))
+
+	assert.Equal(t, []string{"offset 9: unsupported file format version 2"},
+		problemStrings(validateDelta(t, "4f43544f44454c544102", -1)))
+
+	// MD5 with a 16 byte hash
+	assert.Equal(t, []string{"offset 10: unsupported hash algorithm \"MD5\""},
+		problemStrings(validateDelta(t, "4f43544f44454c544101034d443510000000000000000000000000000000000000003e3e3e", -1)))
+
+	// SHA1 with a 16 byte hash
+	assert.Equal(t, []string{"offset 15: hash length 16 does not match the 20 bytes produced by SHA1"},
+		problemStrings(validateDelta(t, "4f43544f44454c544101045348413110000000000000000000000000000000000000003e3e3e", -1)))
+
+	assert.Equal(t, []string{"offset 15: file is truncated; expecting 4 bytes for the hash length but only 2 were available"},
+		problemStrings(validateDelta(t, "4f43544f44454c5441010453484131aabb", -1)))
+}
@@ -0,0 +1,564 @@
+package octodiff
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/fs"
+	"sort"
+)
+
+// A directory delta turns one directory tree into another. It's built from a DirectorySignature of the old tree,
+// and written as a single file starting with BinaryDirectoryDeltaHeader, followed by each operation:
+//
+//	type        byte; one of the DirectoryDeltaOperationType values
+//	path        length-prefixed string, relative to the root and separated by forward slashes
+//	basis path  for renames only: length-prefixed string; the file in the old tree that the delta applies to
+//	mode        uint32 Unix permission bits, for everything except removals and verifications
+//	delta       for added, modified and renamed files only: int64 length, then an OCTODELTA delta of that length
+//	hash        for verifications only: the SHA1 hash of the file
+//
+// All integers are little-endian. Added files have a delta against an empty file. Files which haven't changed have a
+// verification, so that the whole tree can be checked before anything in it is changed.
+type DirectoryDeltaOperationType byte
+
+const (
+	DirectoryDeltaAddDirectory = DirectoryDeltaOperationType('D')
+	DirectoryDeltaAddFile      = DirectoryDeltaOperationType('A')
+	DirectoryDeltaModifyFile   = DirectoryDeltaOperationType('U')
+	DirectoryDeltaRenameFile   = DirectoryDeltaOperationType('N')
+	DirectoryDeltaRemove       = DirectoryDeltaOperationType('R')
+	DirectoryDeltaChangeMode   = DirectoryDeltaOperationType('M')
+	DirectoryDeltaVerifyFile   = DirectoryDeltaOperationType('V')
+)
+
+func (t DirectoryDeltaOperationType) String() string {
+	switch t {
+	case DirectoryDeltaAddDirectory:
+		return "add directory"
+	case DirectoryDeltaAddFile:
+		return "add file"
+	case DirectoryDeltaModifyFile:
+		return "modify file"
+	case DirectoryDeltaRenameFile:
+		return "rename file"
+	case DirectoryDeltaRemove:
+		return "remove"
+	case DirectoryDeltaChangeMode:
+		return "change mode"
+	case DirectoryDeltaVerifyFile:
+		return "verify file"
+	}
+	return fmt.Sprintf("unknown operation 0x%02x", byte(t))
+}
+
+func (t DirectoryDeltaOperationType) hasDelta() bool {
+	return t == DirectoryDeltaAddFile || t == DirectoryDeltaModifyFile || t == DirectoryDeltaRenameFile
+}
+
+type DirectoryDeltaOperation struct {
+	Type      DirectoryDeltaOperationType
+	Path      string      // relative to the root of the tree, using forward slashes
+	BasisPath string      // for renames, the file in the old tree that Delta applies to
+	Mode      fs.FileMode // the permissions of the new file or directory. Unused for removals and verifications
+	Hash      []byte      // for verifications, the SHA1 hash the unchanged file should have
+
+	// Delta is an OCTODELTA delta for added, modified and renamed files; nil otherwise.
+	// When reading, it's only valid until the next operation is read.
+	Delta io.Reader
+}
+
+// DefaultRenameSimilarity is the fraction of a new file which must be found in a removed file for it to count as a rename
+const DefaultRenameSimilarity = 0.5
+
+// DirectoryDeltaBuilder creates a directory delta between a DirectorySignature and a tree of files
+type DirectoryDeltaBuilder struct {
+	ProgressReporter ProgressReporter // reports progress through the total size of all the files
+
+	// RenameSimilarity is the fraction of a new file which must match the chunks of a removed file for the
+	// new file to be written as a rename of it. Values above 1 turn off rename detection
+	RenameSimilarity float64
+}
+
+func NewDirectoryDeltaBuilder() *DirectoryDeltaBuilder {
+	return &DirectoryDeltaBuilder{
+		ProgressReporter: NopProgressReporter(),
+		RenameSimilarity: DefaultRenameSimilarity,
+	}
+}
+
+// Build walks `fsys` and writes a delta to `output` which turns the tree described by `signature` into it.
+// Files which haven't changed are only verified. The delta for each file is built in memory before it's written.
+func (d *DirectoryDeltaBuilder) Build(fsys fs.FS, signature *DirectorySignature, output io.Writer) error {
+	entries, totalSize, err := walkDirectory(fsys)
+	if err != nil {
+		return err
+	}
+	basisEntries := make(map[string]*DirectoryEntry, len(signature.Entries))
+	for _, entry := range signature.Entries {
+		basisEntries[entry.Path] = entry
+	}
+	newEntries := make(map[string]*DirectoryEntry, len(entries))
+	for _, entry := range entries {
+		newEntries[entry.Path] = entry
+	}
+
+	_, err = output.Write(BinaryDirectoryDeltaHeader)
+	if err != nil {
+		return err
+	}
+	_, err = output.Write(BinaryVersion)
+	if err != nil {
+		return err
+	}
+	_, err = output.Write(BinaryEndOfMetadata)
+	if err != nil {
+		return err
+	}
+
+	progress := int64(0)
+	d.ProgressReporter.ReportProgress("Building deltas", progress, totalSize)
+
+	// files with nothing at the same path in the old tree are dealt with once we know what's been removed,
+	// as they may have been renamed
+	var addedFiles []*DirectoryEntry
+	for _, entry := range entries {
+		basis := basisEntries[entry.Path]
+		if entry.Mode.IsDir() {
+			switch {
+			case basis == nil || !basis.Mode.IsDir():
+				err = writeDirectoryDeltaOperation(output, &DirectoryDeltaOperation{Type: DirectoryDeltaAddDirectory, Path: entry.Path, Mode: entry.Mode}, nil)
+			case unixMode(basis.Mode) != unixMode(entry.Mode):
+				err = writeDirectoryDeltaOperation(output, &DirectoryDeltaOperation{Type: DirectoryDeltaChangeMode, Path: entry.Path, Mode: entry.Mode}, nil)
+			}
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if basis == nil || basis.Mode.IsDir() {
+			addedFiles = append(addedFiles, entry)
+			continue
+		}
+		err = d.writeFileOperation(fsys, entry, DirectoryDeltaModifyFile, basis, output)
+		if err != nil {
+			return err
+		}
+		progress += entry.Size
+		d.ProgressReporter.ReportProgress("Building deltas", progress, totalSize)
+	}
+
+	// anything whose path is gone, or is now a different type, is removed
+	isRemoved := func(basis *DirectoryEntry) bool {
+		entry := newEntries[basis.Path]
+		return entry == nil || entry.Mode.IsDir() != basis.Mode.IsDir()
+	}
+	var removedFiles []*DirectoryEntry
+	for _, basis := range signature.Entries {
+		if !basis.Mode.IsDir() && isRemoved(basis) {
+			removedFiles = append(removedFiles, basis)
+		}
+	}
+	renames := newRenameDetector(removedFiles)
+
+	for _, entry := range addedFiles {
+		operationType := DirectoryDeltaAddFile
+		basis, err := d.findRenameSource(fsys, entry, renames)
+		if err != nil {
+			return err
+		}
+		if basis != nil {
+			operationType = DirectoryDeltaRenameFile
+		}
+		err = d.writeFileOperation(fsys, entry, operationType, basis, output)
+		if err != nil {
+			return err
+		}
+		progress += entry.Size
+		d.ProgressReporter.ReportProgress("Building deltas", progress, totalSize)
+	}
+
+	// contents are removed before the directories they're in
+	for i := len(signature.Entries) - 1; i >= 0; i-- {
+		basis := signature.Entries[i]
+		if isRemoved(basis) {
+			err = writeDirectoryDeltaOperation(output, &DirectoryDeltaOperation{Type: DirectoryDeltaRemove, Path: basis.Path}, nil)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// writeFileOperation builds the delta for `entry` against `basis`, which is nil for added files, and writes it.
+// A modified file which turns out to be unchanged is written as a verification, and a mode change if its permissions differ.
+// The size of `entry` is set to the number of bytes in the file.
+func (d *DirectoryDeltaBuilder) writeFileOperation(fsys fs.FS, entry *DirectoryEntry, operationType DirectoryDeltaOperationType, basis *DirectoryEntry, output io.Writer) error {
+	newFile, err := openReadSeeker(fsys, entry.Path)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = newFile.Close() }()
+	entry.Size, err = newFile.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	_, err = newFile.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	signature := &Signature{HashAlgorithm: DefaultHashAlgorithm, RollingChecksumAlgorithm: NewAdler32RollingChecksum()}
+	if basis != nil {
+		signature = basis.Signature
+	}
+	var delta bytes.Buffer
+	writer := &unchangedFileDeltaWriter{DeltaWriter: NewBinaryDeltaWriter(&delta), unchanged: true}
+	err = NewDeltaBuilder().BuildFromSignature(newFile, entry.Size, signature, writer)
+	if err != nil {
+		return fmt.Errorf("%s: %w", entry.Path, err)
+	}
+
+	if operationType == DirectoryDeltaModifyFile && writer.unchanged && writer.length == basis.Size {
+		err = writeDirectoryDeltaOperation(output, &DirectoryDeltaOperation{Type: DirectoryDeltaVerifyFile, Path: entry.Path, Hash: writer.hash}, nil)
+		if err != nil || unixMode(basis.Mode) == unixMode(entry.Mode) {
+			return err
+		}
+		return writeDirectoryDeltaOperation(output, &DirectoryDeltaOperation{Type: DirectoryDeltaChangeMode, Path: entry.Path, Mode: entry.Mode}, nil)
+	}
+	operation := &DirectoryDeltaOperation{Type: operationType, Path: entry.Path, Mode: entry.Mode}
+	if operationType == DirectoryDeltaRenameFile {
+		operation.BasisPath = basis.Path
+	}
+	return writeDirectoryDeltaOperation(output, operation, delta.Bytes())
+}
+
+type readSeekCloser interface {
+	io.ReadSeeker
+	io.Closer
+}
+
+// openReadSeeker opens `path`, reading it into memory if the file can't seek
+func openReadSeeker(fsys fs.FS, path string) (readSeekCloser, error) {
+	file, err := fsys.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if seeker, ok := file.(readSeekCloser); ok {
+		return seeker, nil
+	}
+	defer func() { _ = file.Close() }()
+	contents, err := io.ReadAll(file)
+	if err != nil {
+		return nil, err
+	}
+	return nopCloser{bytes.NewReader(contents)}, nil
+}
+
+type nopCloser struct {
+	io.ReadSeeker
+}
+
+func (nopCloser) Close() error { return nil }
+
+// unchangedFileDeltaWriter watches a delta go past, to find out whether it's a copy of the whole basis file
+type unchangedFileDeltaWriter struct {
+	DeltaWriter
+	unchanged bool   // whether everything so far has been copied from the same place in the basis file
+	length    int64  // the length of the new file so far
+	hash      []byte // the hash of the new file
+}
+
+func (w *unchangedFileDeltaWriter) WriteMetadata(hashAlgorithm HashAlgorithm, expectedNewFileHash []byte) error {
+	w.hash = expectedNewFileHash
+	return w.DeltaWriter.WriteMetadata(hashAlgorithm, expectedNewFileHash)
+}
+
+func (w *unchangedFileDeltaWriter) WriteCopyCommand(offset int64, length int64) error {
+	w.unchanged = w.unchanged && offset == w.length
+	w.length += length
+	return w.DeltaWriter.WriteCopyCommand(offset, length)
+}
+
+func (w *unchangedFileDeltaWriter) WriteDataCommand(source io.ReadSeeker, offset int64, length int64) error {
+	w.unchanged = false
+	w.length += length
+	return w.DeltaWriter.WriteDataCommand(source, offset, length)
+}
+
+// renameDetector indexes the chunks of removed files, so we can tell which one a new file is most like
+type renameDetector struct {
+	candidates []*DirectoryEntry
+	// chunkSizes maps each chunk size to the hashes of the chunks of the files which use it,
+	// and those to the indexes of the candidates they're found in
+	chunkSizes map[int]map[string][]int
+}
+
+func newRenameDetector(removedFiles []*DirectoryEntry) *renameDetector {
+	r := &renameDetector{chunkSizes: map[int]map[string][]int{}}
+	for _, file := range removedFiles {
+		if file.Size == 0 || file.Signature == nil || len(file.Signature.Chunks) == 0 {
+			continue // there's nothing to match
+		}
+		// new files are read in blocks of the first chunk's length, which only lines up with files that use the same one
+		chunkSize := int(file.Signature.Chunks[0].Length)
+		hashes := r.chunkSizes[chunkSize]
+		if hashes == nil {
+			hashes = map[string][]int{}
+			r.chunkSizes[chunkSize] = hashes
+		}
+		index := len(r.candidates)
+		r.candidates = append(r.candidates, file)
+		for _, chunk := range file.Signature.Chunks {
+			key := string(chunk.Hash)
+			if list := hashes[key]; len(list) == 0 || list[len(list)-1] != index {
+				hashes[key] = append(list, index)
+			}
+		}
+	}
+	return r
+}
+
+// findRenameSource returns the removed file which `entry` shares the most chunks with, or nil if none of them share
+// enough. Only chunks at the same alignment are found, which is enough to spot a file that was moved and perhaps edited.
+func (d *DirectoryDeltaBuilder) findRenameSource(fsys fs.FS, entry *DirectoryEntry, renames *renameDetector) (*DirectoryEntry, error) {
+	if len(renames.candidates) == 0 || d.RenameSimilarity > 1 {
+		return nil, nil
+	}
+	matched := make([]int64, len(renames.candidates))
+	size := int64(-1)
+
+	// go through the chunk sizes in order, so the result doesn't depend on map iteration
+	chunkSizes := make([]int, 0, len(renames.chunkSizes))
+	for chunkSize := range renames.chunkSizes {
+		chunkSizes = append(chunkSizes, chunkSize)
+	}
+	sort.Ints(chunkSizes)
+	for _, chunkSize := range chunkSizes {
+		hashes := renames.chunkSizes[chunkSize]
+		fileSize, err := matchChunks(fsys, entry.Path, chunkSize, func(block []byte) {
+			for _, index := range hashes[string(DefaultHashAlgorithm.HashOverData(block))] {
+				matched[index] += int64(len(block))
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+		size = fileSize
+	}
+	if size <= 0 {
+		return nil, nil
+	}
+
+	var best *DirectoryEntry
+	bestScore := 0.0
+	for index, candidate := range renames.candidates {
+		largest := candidate.Size
+		if size > largest {
+			largest = size
+		}
+		score := float64(matched[index]) / float64(largest)
+		if score > bestScore {
+			best, bestScore = candidate, score
+		}
+	}
+	if best == nil || bestScore < d.RenameSimilarity {
+		return nil, nil
+	}
+	return best, nil
+}
+
+// matchChunks calls `match` with each `chunkSize` block of the file at `path`, and returns the size of the file
+func matchChunks(fsys fs.FS, path string, chunkSize int, match func([]byte)) (int64, error) {
+	file, err := fsys.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = file.Close() }()
+
+	size := int64(0)
+	block := make([]byte, chunkSize)
+	for {
+		n, err := io.ReadFull(file, block)
+		if n > 0 {
+			match(block[:n])
+			size += int64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return size, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+func writeDirectoryDeltaOperation(output io.Writer, operation *DirectoryDeltaOperation, delta []byte) error {
+	_, err := output.Write([]byte{byte(operation.Type)})
+	if err != nil {
+		return err
+	}
+	err = writeLengthPrefixedString(output, operation.Path)
+	if err != nil {
+		return err
+	}
+	if operation.Type == DirectoryDeltaRenameFile {
+		err = writeLengthPrefixedString(output, operation.BasisPath)
+		if err != nil {
+			return err
+		}
+	}
+	if operation.Type == DirectoryDeltaRemove {
+		return nil
+	}
+	if operation.Type == DirectoryDeltaVerifyFile {
+		_, err = output.Write(operation.Hash)
+		return err
+	}
+	err = binary.Write(output, binary.LittleEndian, unixMode(operation.Mode))
+	if err != nil {
+		return err
+	}
+	if !operation.Type.hasDelta() {
+		return nil
+	}
+	err = binary.Write(output, binary.LittleEndian, int64(len(delta)))
+	if err != nil {
+		return err
+	}
+	_, err = output.Write(delta)
+	return err
+}
+
+// DirectoryDeltaReader reads the operations in a directory delta, one at a time
+type DirectoryDeltaReader struct {
+	input         *countingReader
+	hasReadHeader bool
+	delta         *io.LimitedReader // the delta of the last operation, which may not have been read yet
+	deltaEnd      int64
+}
+
+func NewDirectoryDeltaReader(input io.Reader) *DirectoryDeltaReader {
+	return &DirectoryDeltaReader{input: &countingReader{reader: input}}
+}
+
+// Next returns the next operation, or io.EOF once there are none left.
+// Paths are checked with fs.ValidPath, so they can't escape the root of the tree.
+func (r *DirectoryDeltaReader) Next() (*DirectoryDeltaOperation, error) {
+	const file = "directory delta"
+	if !r.hasReadHeader {
+		err := r.readHeader()
+		if err != nil {
+			return nil, err
+		}
+		r.hasReadHeader = true
+	}
+	if r.delta != nil {
+		// skip whatever the caller didn't read of the last delta
+		_, err := io.Copy(io.Discard, r.delta)
+		if err != nil {
+			return nil, err
+		}
+		if r.input.offset != r.deltaEnd {
+			return nil, truncatedOrError(io.ErrUnexpectedEOF, file, r.input.offset)
+		}
+		r.delta = nil
+	}
+
+	operationOffset := r.input.offset
+	var operationType [1]byte
+	_, err := io.ReadFull(r.input, operationType[:])
+	if err == io.EOF {
+		return nil, io.EOF // there's nothing more, so we've read every operation
+	}
+	if err != nil {
+		return nil, truncatedOrError(err, file, r.input.offset)
+	}
+	operation := &DirectoryDeltaOperation{Type: DirectoryDeltaOperationType(operationType[0])}
+	switch operation.Type {
+	case DirectoryDeltaAddDirectory, DirectoryDeltaAddFile, DirectoryDeltaModifyFile, DirectoryDeltaRenameFile, DirectoryDeltaRemove, DirectoryDeltaChangeMode, DirectoryDeltaVerifyFile:
+	default:
+		return nil, newFormatError(file, operationOffset, ErrCorruptCommand, "the directory delta file appears to be corrupt; found %s", operation.Type)
+	}
+
+	operation.Path, err = r.readPath(operationOffset)
+	if err != nil {
+		return nil, err
+	}
+	if operation.Type == DirectoryDeltaRenameFile {
+		operation.BasisPath, err = r.readPath(operationOffset)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if operation.Type == DirectoryDeltaRemove {
+		return operation, nil
+	}
+	if operation.Type == DirectoryDeltaVerifyFile {
+		operation.Hash = make([]byte, DefaultHashAlgorithm.HashLength())
+		_, err = io.ReadFull(r.input, operation.Hash)
+		if err != nil {
+			return nil, truncatedOrError(err, file, r.input.offset)
+		}
+		return operation, nil
+	}
+	var mode uint32
+	err = binary.Read(r.input, binary.LittleEndian, &mode)
+	if err != nil {
+		return nil, truncatedOrError(err, file, r.input.offset)
+	}
+	operation.Mode = fileMode(mode)
+	if operation.Type == DirectoryDeltaAddDirectory {
+		operation.Mode |= fs.ModeDir
+	}
+	if !operation.Type.hasDelta() {
+		return operation, nil
+	}
+
+	var deltaLength int64
+	err = binary.Read(r.input, binary.LittleEndian, &deltaLength)
+	if err != nil {
+		return nil, truncatedOrError(err, file, r.input.offset)
+	}
+	if deltaLength < 0 {
+		return nil, newFormatError(file, operationOffset, ErrCorruptCommand, "the directory delta file appears to be corrupt; %s has a negative delta length", operation.Path)
+	}
+	r.delta = &io.LimitedReader{R: r.input, N: deltaLength}
+	r.deltaEnd = r.input.offset + deltaLength
+	operation.Delta = r.delta
+	return operation, nil
+}
+
+func (r *DirectoryDeltaReader) readHeader() error {
+	const file = "directory delta"
+	header := make([]byte, len(BinaryDirectoryDeltaHeader)+len(BinaryVersion)+len(BinaryEndOfMetadata))
+	_, err := io.ReadFull(r.input, header)
+	if err != nil {
+		return truncatedOrError(err, file, r.input.offset)
+	}
+	if !bytes.HasPrefix(header, BinaryDirectoryDeltaHeader) {
+		return newFormatError(file, 0, ErrCorruptHeader, "the directory delta file appears to be corrupt")
+	}
+	versionOffset := len(BinaryDirectoryDeltaHeader)
+	if !bytes.Equal(header[versionOffset:versionOffset+len(BinaryVersion)], BinaryVersion) {
+		return newFormatError(file, int64(versionOffset), ErrUnsupportedVersion, "the directory delta file uses a newer file format than this program can handle")
+	}
+	if !bytes.HasSuffix(header, BinaryEndOfMetadata) {
+		return newFormatError(file, int64(versionOffset+len(BinaryVersion)), ErrCorruptHeader, "the directory delta file appears to be corrupt")
+	}
+	return nil
+}
+
+func (r *DirectoryDeltaReader) readPath(operationOffset int64) (string, error) {
+	const file = "directory delta"
+	path, _, err := readBoundedLengthPrefixedString(r.input, file, r.input.offset, maxDirectoryEntryPathLength)
+	if err != nil {
+		return "", err
+	}
+	if !fs.ValidPath(path) || path == "." {
+		return "", newFormatError(file, operationOffset, ErrCorruptCommand, "the directory delta file contains an invalid path %q", path)
+	}
+	return path, nil
+}
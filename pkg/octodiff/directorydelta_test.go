@@ -0,0 +1,252 @@
+package octodiff_test
+
+import (
+	"bytes"
+	"github.com/OctopusDeploy/go-octodiff/pkg/octodiff"
+	"github.com/OctopusDeploy/go-octodiff/pkg/test"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func buildDirectoryDelta(basisTree fs.FS, newTree fs.FS) []byte {
+	signature, err := readDirectorySignature(buildDirectorySignature(basisTree))
+	if err != nil {
+		panic(err) // should never fail under tests
+	}
+	var output bytes.Buffer
+	err = octodiff.NewDirectoryDeltaBuilder().Build(newTree, signature, &output)
+	if err != nil {
+		panic(err) // should never fail under tests
+	}
+	return output.Bytes()
+}
+
+// describeDirectoryDelta lists the operations in a directory delta as "<type> <path>", with the basis path for renames
+func describeDirectoryDelta(deltaFile []byte) ([]string, error) {
+	reader := octodiff.NewDirectoryDeltaReader(bytes.NewReader(deltaFile))
+	var result []string
+	for {
+		operation, err := reader.Next()
+		if err == io.EOF {
+			return result, nil
+		}
+		if err != nil {
+			return result, err
+		}
+		description := operation.Type.String() + " " + operation.Path
+		if operation.Type == octodiff.DirectoryDeltaRenameFile {
+			description += " from " + operation.BasisPath
+		}
+		result = append(result, description)
+	}
+}
+
+// changedTestTree is testTree() with one of everything a directory delta can describe
+func changedTestTree() fstest.MapFS {
+	_, changed := largeFileWithDisjointChanges()
+	settings := test.TestData()
+	return fstest.MapFS{
+		"app.dll":                 {Data: changed, Mode: 0644},
+		"bin/run.sh":              {Data: []byte("#!/bin/sh\n"), Mode: 0700},
+		"config/settings.json":    {Data: settings, Mode: 0600},
+		"empty":                   {Mode: fs.ModeDir | 0700},
+		"plugins/readme.txt":      {Data: []byte("plugins go here\n"), Mode: 0644},
+		"plugins/renamed/app.dll": {Data: append([]byte("prefix"), changed...), Mode: 0644},
+	}
+}
+
+func TestDirectoryDeltaOperations(t *testing.T) {
+	original := testTree()
+	original["lib/app.dll"] = &fstest.MapFile{Data: test.GenerateTestData(64 * 1024), Mode: 0644}
+	changed := changedTestTree()
+	// moved, with one byte changed
+	moved := test.GenerateTestData(64 * 1024)
+	moved[100] = 0xaa
+	changed["plugins/renamed/app.dll"] = &fstest.MapFile{Data: moved, Mode: 0644}
+
+	operations, err := describeDirectoryDelta(buildDirectoryDelta(original, changed))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{
+		"modify file app.dll",
+		"verify file bin/run.sh",
+		"change mode bin/run.sh",
+		"verify file config/settings.json",
+		"add directory plugins",
+		"add directory plugins/renamed",
+		"add file plugins/readme.txt",
+		"rename file plugins/renamed/app.dll from lib/app.dll",
+		"remove logs/.keep",
+		"remove logs",
+		"remove lib/app.dll",
+		"remove lib",
+	}, operations)
+}
+
+func TestDirectoryDeltaOfTheSameTreeOnlyVerifiesIt(t *testing.T) {
+	operations, err := describeDirectoryDelta(buildDirectoryDelta(testTree(), testTree()))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{
+		"verify file app.dll",
+		"verify file bin/run.sh",
+		"verify file config/settings.json",
+		"verify file logs/.keep",
+	}, operations)
+}
+
+func TestDirectoryDeltaReplacesFilesWithDirectories(t *testing.T) {
+	original := fstest.MapFS{
+		"a":   {Data: []byte("a file"), Mode: 0644},
+		"b/c": {Data: []byte("a file in a directory"), Mode: 0644},
+	}
+	changed := fstest.MapFS{
+		"a/c": {Data: []byte("a new file in a directory"), Mode: 0644},
+		"b":   {Data: []byte("a new file"), Mode: 0644},
+	}
+
+	operations, err := describeDirectoryDelta(buildDirectoryDelta(original, changed))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{
+		"add directory a",
+		"add file a/c",
+		"add file b",
+		"remove b/c",
+		"remove b",
+		"remove a",
+	}, operations)
+}
+
+func TestDirectoryDeltaRenameSimilarity(t *testing.T) {
+	data := test.GenerateTestData(16 * 1024)
+	original := fstest.MapFS{"old": {Data: data, Mode: 0644}}
+	changed := fstest.MapFS{"new": {Data: data, Mode: 0644}}
+	signature, err := readDirectorySignature(buildDirectorySignature(original))
+	assert.Nil(t, err)
+
+	builder := octodiff.NewDirectoryDeltaBuilder()
+	builder.RenameSimilarity = 2 // never
+	var output bytes.Buffer
+	err = builder.Build(changed, signature, &output)
+	assert.Nil(t, err)
+
+	operations, err := describeDirectoryDelta(output.Bytes())
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"add file new", "remove old"}, operations)
+}
+
+func writeTree(t *testing.T, tree fstest.MapFS) string {
+	root := t.TempDir()
+	err := fs.WalkDir(tree, ".", func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || path == "." {
+			return err
+		}
+		fullPath := filepath.Join(root, filepath.FromSlash(path))
+		if entry.IsDir() {
+			return os.Mkdir(fullPath, 0700)
+		}
+		return os.WriteFile(fullPath, tree[path].Data, 0600)
+	})
+	assert.Nil(t, err)
+	// permissions go on afterwards, so read-only directories can still be filled
+	for path, file := range tree {
+		err = os.Chmod(filepath.Join(root, filepath.FromSlash(path)), file.Mode.Perm())
+		assert.Nil(t, err)
+	}
+	return root
+}
+
+// assertTree checks that the tree at `root` has exactly the files, directories and permissions in `expected`
+func assertTree(t *testing.T, expected fstest.MapFS, root string) {
+	actual := fstest.MapFS{}
+	err := fs.WalkDir(os.DirFS(root), ".", func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || path == "." {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		file := &fstest.MapFile{Mode: info.Mode()}
+		if !info.IsDir() {
+			file.Data, err = os.ReadFile(filepath.Join(root, filepath.FromSlash(path)))
+		}
+		actual[path] = file
+		return err
+	})
+	assert.Nil(t, err)
+
+	for path, file := range expected {
+		assert.Contains(t, actual, path)
+		if actualFile, ok := actual[path]; ok {
+			assert.Equal(t, file.Mode, actualFile.Mode, path)
+			assert.True(t, bytes.Equal(file.Data, actualFile.Data), path)
+		}
+	}
+	for path, file := range actual {
+		if _, ok := expected[path]; !ok && !file.Mode.IsDir() {
+			t.Errorf("unexpected file %s", path)
+		}
+	}
+}
+
+func TestApplyDirectoryDelta(t *testing.T) {
+	original := testTree()
+	changed := changedTestTree()
+	changed["lib"] = &fstest.MapFile{Data: []byte("was a directory"), Mode: 0644}
+	original["lib/app.dll"] = &fstest.MapFile{Data: test.GenerateTestData(64 * 1024), Mode: 0644}
+	deltaFile := buildDirectoryDelta(original, changed)
+	root := writeTree(t, original)
+
+	err := octodiff.ApplyDirectoryDelta(root, octodiff.NewDirectoryDeltaReader(bytes.NewReader(deltaFile)))
+	assert.Nil(t, err)
+	assertTree(t, changed, root)
+}
+
+func TestApplyDirectoryDeltaLeavesTheTreeAloneWhenVerificationFails(t *testing.T) {
+	original := testTree()
+	deltaFile := buildDirectoryDelta(original, changedTestTree())
+
+	// the tree doesn't match the signature the delta was built from
+	original["app.dll"].Data = bytes.Repeat([]byte{0xaa}, len(original["app.dll"].Data))
+	root := writeTree(t, original)
+
+	err := octodiff.ApplyDirectoryDelta(root, octodiff.NewDirectoryDeltaReader(bytes.NewReader(deltaFile)))
+	assert.ErrorIs(t, err, octodiff.ErrVerificationFailed)
+	assertTree(t, original, root)
+}
+
+func TestApplyDirectoryDeltaVerifiesUnchangedFiles(t *testing.T) {
+	original := testTree()
+	deltaFile := buildDirectoryDelta(original, changedTestTree())
+
+	// a file which the delta doesn't change has changed since the signature was taken
+	original["config/settings.json"].Data = []byte("{}")
+	root := writeTree(t, original)
+
+	err := octodiff.ApplyDirectoryDelta(root, octodiff.NewDirectoryDeltaReader(bytes.NewReader(deltaFile)))
+	assert.ErrorIs(t, err, octodiff.ErrVerificationFailed)
+	assert.True(t, strings.HasPrefix(err.Error(), "config/settings.json: "), err.Error())
+	assertTree(t, original, root)
+}
+
+func TestDirectoryDeltaReaderRejectsPathsOutsideTheTree(t *testing.T) {
+	for _, path := range []string{"../escape", "/etc/passwd", "a/../../b", "."} {
+		deltaFile := append([]byte("OCTODIRDELTA\x01>>>R"), byte(len(path)))
+		deltaFile = append(deltaFile, path...)
+
+		_, err := octodiff.NewDirectoryDeltaReader(bytes.NewReader(deltaFile)).Next()
+		assert.ErrorIs(t, err, octodiff.ErrCorruptCommand, path)
+	}
+}
+
+func TestDirectoryDeltaReaderRejectsTruncatedDeltas(t *testing.T) {
+	deltaFile := buildDirectoryDelta(testTree(), changedTestTree())
+
+	_, err := describeDirectoryDelta(deltaFile[:len(deltaFile)/2])
+	assert.ErrorIs(t, err, octodiff.ErrTruncatedChunk)
+}
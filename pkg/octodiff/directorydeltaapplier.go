@@ -0,0 +1,166 @@
+package octodiff
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// ApplyDirectoryDelta applies a directory delta to the tree at `root`, in place.
+//
+// Every new and changed file is first written to a temporary file in `root` and verified against the hash in its delta,
+// and every unchanged file is checked against the hash recorded for it, so a delta which doesn't match the tree fails
+// before anything in the tree has changed. Files which the delta removes aren't checked. Only then are removals,
+// new directories, new files and mode changes applied; a failure while doing those, such as a removed directory which
+// still has files in it that weren't in the signature, can leave the tree partially updated.
+func ApplyDirectoryDelta(root string, deltaReader *DirectoryDeltaReader) error {
+	var staged []*stagedFile
+	defer func() {
+		for _, file := range staged {
+			if file.tempPath != "" {
+				_ = os.Remove(file.tempPath)
+			}
+		}
+	}()
+	var removals, directories, modes []*DirectoryDeltaOperation
+	for {
+		operation, err := deltaReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		switch operation.Type {
+		case DirectoryDeltaAddFile, DirectoryDeltaModifyFile, DirectoryDeltaRenameFile:
+			file := &stagedFile{operation: operation}
+			staged = append(staged, file)
+			err = file.stage(root)
+			if err != nil {
+				return fmt.Errorf("%s: %w", operation.Path, err)
+			}
+			modes = append(modes, operation)
+		case DirectoryDeltaRemove:
+			removals = append(removals, operation)
+		case DirectoryDeltaAddDirectory:
+			directories = append(directories, operation)
+			modes = append(modes, operation)
+		case DirectoryDeltaChangeMode:
+			modes = append(modes, operation)
+		case DirectoryDeltaVerifyFile:
+			err = verifyUnchangedFile(root, operation)
+			if err != nil {
+				return fmt.Errorf("%s: %w", operation.Path, err)
+			}
+		}
+	}
+
+	// contents are removed before the directories they're in, which sort before them
+	sort.Slice(removals, func(i, j int) bool { return removals[i].Path > removals[j].Path })
+	for _, operation := range removals {
+		err := os.Remove(directoryDeltaPath(root, operation.Path))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+
+	// directories are created before their contents, and made writable until everything is in them
+	sort.Slice(directories, func(i, j int) bool { return directories[i].Path < directories[j].Path })
+	for _, operation := range directories {
+		err := os.Mkdir(directoryDeltaPath(root, operation.Path), 0o700)
+		if err != nil && !errors.Is(err, fs.ErrExist) {
+			return err
+		}
+	}
+
+	for _, file := range staged {
+		err := os.Rename(file.tempPath, directoryDeltaPath(root, file.operation.Path))
+		if err != nil {
+			return err
+		}
+		file.tempPath = ""
+	}
+
+	// permissions go on last, contents first, in case they take away write access to a directory
+	sort.SliceStable(modes, func(i, j int) bool { return modes[i].Path > modes[j].Path })
+	for _, operation := range modes {
+		err := os.Chmod(directoryDeltaPath(root, operation.Path), operation.Mode&(fs.ModePerm|fs.ModeSetuid|fs.ModeSetgid|fs.ModeSticky))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stagedFile is a new or changed file, written to a temporary file until it can be moved into place
+type stagedFile struct {
+	operation *DirectoryDeltaOperation
+	tempPath  string
+}
+
+func (s *stagedFile) stage(root string) error {
+	var basisFile io.ReadSeeker = bytes.NewReader(nil)
+	basisPath := ""
+	switch s.operation.Type {
+	case DirectoryDeltaModifyFile:
+		basisPath = s.operation.Path
+	case DirectoryDeltaRenameFile:
+		basisPath = s.operation.BasisPath
+	}
+	if basisPath != "" {
+		file, err := os.Open(directoryDeltaPath(root, basisPath))
+		if err != nil {
+			return err
+		}
+		defer func() { _ = file.Close() }()
+		basisFile = file
+	}
+
+	tempFile, err := os.CreateTemp(root, ".octodiff-*.tmp")
+	if err != nil {
+		return err
+	}
+	s.tempPath = tempFile.Name()
+	defer func() { _ = tempFile.Close() }()
+
+	// we can't buffer IO for basisFile because it seeks all over the place
+	output := bufio.NewWriter(tempFile)
+	err = ApplyDeltaWithOptions(basisFile, NewBinaryDeltaReader(s.operation.Delta), output, ApplyDeltaOptions{Verify: true})
+	if err != nil {
+		return err
+	}
+	err = output.Flush()
+	if err != nil {
+		return err
+	}
+	return tempFile.Close()
+}
+
+// verifyUnchangedFile checks that a file the delta leaves alone is the one the delta was built for
+func verifyUnchangedFile(root string, operation *DirectoryDeltaOperation) error {
+	file, err := os.Open(directoryDeltaPath(root, operation.Path))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = file.Close() }()
+
+	hash, err := DefaultHashAlgorithm.HashOverReader(bufio.NewReader(file))
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(hash, operation.Hash) {
+		return &VerificationError{HashAlgorithm: DefaultHashAlgorithm.Name(), ExpectedHash: operation.Hash, ActualHash: hash}
+	}
+	return nil
+}
+
+// directoryDeltaPath converts a path from a directory delta, which has already been checked with fs.ValidPath, to a file path
+func directoryDeltaPath(root string, path string) string {
+	return filepath.Join(root, filepath.FromSlash(path))
+}
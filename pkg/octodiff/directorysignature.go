@@ -0,0 +1,304 @@
+package octodiff
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+)
+
+// DirectorySignature describes a whole directory tree: every directory and file in it, with a signature for each file.
+// It's written as a single file starting with BinaryDirectorySignatureHeader, followed by each entry:
+//
+//	path       length-prefixed string, relative to the root and separated by forward slashes
+//	type       byte; DirectoryEntryTypeDirectory or DirectoryEntryTypeFile
+//	mode       uint32; the Unix permission bits, including setuid, setgid and sticky
+//	size       int64
+//	signature  for files only: int64 length, then an OCTOSIG signature of that length
+//
+// All integers are little-endian. Entries are in lexical order, with each directory before its contents.
+type DirectorySignature struct {
+	Entries []*DirectoryEntry
+}
+
+type DirectoryEntry struct {
+	Path      string      // relative to the root of the tree, using forward slashes
+	Mode      fs.FileMode // either a directory or a regular file, and its permissions
+	Size      int64       // zero for directories
+	Signature *Signature  // nil for directories
+}
+
+const (
+	DirectoryEntryTypeDirectory = byte('D')
+	DirectoryEntryTypeFile      = byte('F')
+)
+
+// maxDirectoryEntryPathLength limits the paths we'll read from a directory signature
+const maxDirectoryEntryPathLength = 64 * 1024
+
+// DirectorySignatureBuilder creates a DirectorySignature for a tree of files, using SignatureBuilder for each file
+type DirectorySignatureBuilder struct {
+	SignatureBuilder *SignatureBuilder // builds the signature for each file. Its ProgressReporter isn't used
+	ProgressReporter ProgressReporter  // reports progress through the total size of all the files
+}
+
+func NewDirectorySignatureBuilder() *DirectorySignatureBuilder {
+	return &DirectorySignatureBuilder{
+		SignatureBuilder: NewSignatureBuilder(),
+		ProgressReporter: NopProgressReporter(),
+	}
+}
+
+// Build walks `fsys` and writes a signature of everything in it to `output`.
+// Only directories and regular files are supported; anything else, such as a symbolic link, is an error.
+func (d *DirectorySignatureBuilder) Build(fsys fs.FS, output io.Writer) error {
+	err := d.SignatureBuilder.ensureValid()
+	if err != nil {
+		return err
+	}
+
+	// find everything first, so we know how much there is to do
+	entries, totalSize, err := walkDirectory(fsys)
+	if err != nil {
+		return err
+	}
+
+	_, err = output.Write(BinaryDirectorySignatureHeader)
+	if err != nil {
+		return err
+	}
+	_, err = output.Write(BinaryVersion)
+	if err != nil {
+		return err
+	}
+	_, err = output.Write(BinaryEndOfMetadata)
+	if err != nil {
+		return err
+	}
+
+	// the buffer is a whole number of chunks, so the signature builder is always given full chunks
+	chunkSize := d.SignatureBuilder.ChunkSize
+	reader := bufio.NewReaderSize(nil, defaultReadBufferSize/chunkSize*chunkSize)
+	var signature bytes.Buffer
+	progress := int64(0)
+	d.ProgressReporter.ReportProgress("Building signatures", progress, totalSize)
+	for _, entry := range entries {
+		signature.Reset()
+		if !entry.Mode.IsDir() {
+			entry.Size, err = d.buildFileSignature(fsys, entry.Path, reader, &signature)
+			if err != nil {
+				return err
+			}
+		}
+		err = writeDirectoryEntry(output, entry, signature.Bytes())
+		if err != nil {
+			return err
+		}
+
+		progress += entry.Size
+		d.ProgressReporter.ReportProgress("Building signatures", progress, totalSize)
+	}
+	return nil
+}
+
+// buildFileSignature writes the signature of the file at `path` to `output`, and returns the size of the file
+func (d *DirectorySignatureBuilder) buildFileSignature(fsys fs.FS, path string, reader *bufio.Reader, output io.Writer) (int64, error) {
+	file, err := fsys.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = file.Close() }()
+
+	// the size is however much we read, in case the file changes after we looked at it
+	counter := &countingReader{reader: file}
+	reader.Reset(counter)
+
+	builder := *d.SignatureBuilder
+	builder.ProgressReporter = NopProgressReporter()
+	err = builder.Build(reader, -1, output)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", path, err)
+	}
+	return counter.offset, nil
+}
+
+// walkDirectory lists everything in `fsys`, without signatures, and returns the total size of the files.
+// The sizes of the entries aren't filled in, as they may change by the time the files are read.
+func walkDirectory(fsys fs.FS) ([]*DirectoryEntry, int64, error) {
+	var entries []*DirectoryEntry
+	var totalSize int64
+	err := fs.WalkDir(fsys, ".", func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == "." {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && !info.Mode().IsRegular() {
+			return fmt.Errorf("%s is not a regular file or directory, which directory signatures don't support", path)
+		}
+		entries = append(entries, &DirectoryEntry{Path: path, Mode: info.Mode()})
+		if !info.IsDir() {
+			totalSize += info.Size()
+		}
+		return nil
+	})
+	return entries, totalSize, err
+}
+
+func writeDirectoryEntry(output io.Writer, entry *DirectoryEntry, signature []byte) error {
+	err := writeLengthPrefixedString(output, entry.Path)
+	if err != nil {
+		return err
+	}
+	entryType := DirectoryEntryTypeFile
+	if entry.Mode.IsDir() {
+		entryType = DirectoryEntryTypeDirectory
+	}
+	_, err = output.Write([]byte{entryType})
+	if err != nil {
+		return err
+	}
+	err = binary.Write(output, binary.LittleEndian, unixMode(entry.Mode))
+	if err != nil {
+		return err
+	}
+	err = binary.Write(output, binary.LittleEndian, entry.Size)
+	if err != nil {
+		return err
+	}
+	if entry.Mode.IsDir() {
+		return nil
+	}
+	err = binary.Write(output, binary.LittleEndian, int64(len(signature)))
+	if err != nil {
+		return err
+	}
+	_, err = output.Write(signature)
+	return err
+}
+
+// DirectorySignatureReader reads the files written by DirectorySignatureBuilder
+type DirectorySignatureReader struct {
+	ProgressReporter ProgressReporter // must be non-null
+}
+
+func NewDirectorySignatureReader() *DirectorySignatureReader {
+	return &DirectorySignatureReader{
+		ProgressReporter: NopProgressReporter(),
+	}
+}
+
+// ReadDirectorySignature reads a directory signature. inputLength is only used to report progress, and may be -1
+func (r *DirectorySignatureReader) ReadDirectorySignature(input io.Reader, inputLength int64) (*DirectorySignature, error) {
+	const file = "directory signature"
+	counter := &countingReader{reader: input}
+	r.ProgressReporter.ReportProgress("Reading signature", 0, inputLength)
+
+	header := make([]byte, len(BinaryDirectorySignatureHeader)+len(BinaryVersion)+len(BinaryEndOfMetadata))
+	_, err := io.ReadFull(counter, header)
+	if err != nil {
+		return nil, truncatedOrError(err, file, counter.offset)
+	}
+	if !bytes.HasPrefix(header, BinaryDirectorySignatureHeader) {
+		return nil, newFormatError(file, 0, ErrCorruptHeader, "the directory signature file appears to be corrupt")
+	}
+	versionOffset := len(BinaryDirectorySignatureHeader)
+	if !bytes.Equal(header[versionOffset:versionOffset+len(BinaryVersion)], BinaryVersion) {
+		return nil, newFormatError(file, int64(versionOffset), ErrUnsupportedVersion, "the directory signature file uses a newer file format than this program can handle")
+	}
+	if !bytes.HasSuffix(header, BinaryEndOfMetadata) {
+		return nil, newFormatError(file, int64(versionOffset+len(BinaryVersion)), ErrCorruptHeader, "the directory signature file appears to be corrupt")
+	}
+
+	signatureReader := NewSignatureReader()
+	result := &DirectorySignature{}
+	for {
+		entryOffset := counter.offset
+		path, _, err := readBoundedLengthPrefixedString(counter, file, entryOffset, maxDirectoryEntryPathLength)
+		if entryOffset == counter.offset && errors.Is(err, ErrTruncatedChunk) {
+			break // there's nothing more, so we've read every entry
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var fields struct {
+			Type byte
+			Mode uint32
+			Size int64
+		}
+		err = binary.Read(counter, binary.LittleEndian, &fields)
+		if err != nil {
+			return nil, truncatedOrError(err, file, counter.offset)
+		}
+		entry := &DirectoryEntry{Path: path, Mode: fileMode(fields.Mode), Size: fields.Size}
+		switch fields.Type {
+		case DirectoryEntryTypeDirectory:
+			entry.Mode |= fs.ModeDir
+		case DirectoryEntryTypeFile:
+			var signatureLength int64
+			err = binary.Read(counter, binary.LittleEndian, &signatureLength)
+			if err != nil {
+				return nil, truncatedOrError(err, file, counter.offset)
+			}
+			if signatureLength < 0 {
+				return nil, newFormatError(file, entryOffset, ErrCorruptHeader, "the directory signature file appears to be corrupt; %s has a negative signature length", path)
+			}
+			signatureStart := counter.offset
+			signature := io.LimitReader(counter, signatureLength)
+			entry.Signature, err = signatureReader.ReadSignature(signature, signatureLength)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+			if counter.offset != signatureStart+signatureLength {
+				return nil, truncatedOrError(io.ErrUnexpectedEOF, file, counter.offset)
+			}
+		default:
+			return nil, newFormatError(file, entryOffset, ErrCorruptHeader, "the directory signature file appears to be corrupt; %s has unknown type 0x%02x", path, fields.Type)
+		}
+		if entry.Size < 0 {
+			return nil, newFormatError(file, entryOffset, ErrCorruptHeader, "the directory signature file appears to be corrupt; %s has a negative size", path)
+		}
+		result.Entries = append(result.Entries, entry)
+		r.ProgressReporter.ReportProgress("Reading signature", counter.offset, inputLength)
+	}
+	return result, nil
+}
+
+// unixMode converts the permission bits of `mode` to how Unix represents them
+func unixMode(mode fs.FileMode) uint32 {
+	result := uint32(mode.Perm())
+	if mode&fs.ModeSetuid != 0 {
+		result |= 0o4000
+	}
+	if mode&fs.ModeSetgid != 0 {
+		result |= 0o2000
+	}
+	if mode&fs.ModeSticky != 0 {
+		result |= 0o1000
+	}
+	return result
+}
+
+// fileMode converts Unix permission bits back to a FileMode
+func fileMode(mode uint32) fs.FileMode {
+	result := fs.FileMode(mode) & fs.ModePerm
+	if mode&0o4000 != 0 {
+		result |= fs.ModeSetuid
+	}
+	if mode&0o2000 != 0 {
+		result |= fs.ModeSetgid
+	}
+	if mode&0o1000 != 0 {
+		result |= fs.ModeSticky
+	}
+	return result
+}
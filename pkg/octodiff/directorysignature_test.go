@@ -0,0 +1,106 @@
+package octodiff_test
+
+import (
+	"bytes"
+	"github.com/OctopusDeploy/go-octodiff/pkg/octodiff"
+	"github.com/OctopusDeploy/go-octodiff/pkg/test"
+	"github.com/stretchr/testify/assert"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+)
+
+func buildDirectorySignature(fsys fs.FS) []byte {
+	var output bytes.Buffer
+	err := octodiff.NewDirectorySignatureBuilder().Build(fsys, &output)
+	if err != nil {
+		panic(err) // should never fail under tests
+	}
+	return output.Bytes()
+}
+
+func readDirectorySignature(input []byte) (*octodiff.DirectorySignature, error) {
+	return octodiff.NewDirectorySignatureReader().ReadDirectorySignature(bytes.NewReader(input), int64(len(input)))
+}
+
+func testTree() fstest.MapFS {
+	original, _ := largeFileWithDisjointChanges()
+	return fstest.MapFS{
+		"app.dll":              {Data: original, Mode: 0644},
+		"bin/run.sh":           {Data: []byte("#!/bin/sh\n"), Mode: 0755},
+		"config/settings.json": {Data: test.TestData(), Mode: 0600},
+		"empty":                {Mode: fs.ModeDir | 0700},
+		"logs/.keep":           {Data: nil, Mode: 0644},
+	}
+}
+
+func TestDirectorySignatureRoundTrip(t *testing.T) {
+	tree := testTree()
+
+	signature, err := readDirectorySignature(buildDirectorySignature(tree))
+	assert.Nil(t, err)
+
+	var paths []string
+	for _, entry := range signature.Entries {
+		paths = append(paths, entry.Path)
+	}
+	assert.Equal(t, []string{"app.dll", "bin", "bin/run.sh", "config", "config/settings.json", "empty", "logs", "logs/.keep"}, paths)
+
+	for _, entry := range signature.Entries {
+		file, ok := tree[entry.Path]
+		if !ok { // a directory implied by the files in it
+			assert.True(t, entry.Mode.IsDir(), entry.Path)
+			assert.Nil(t, entry.Signature)
+			continue
+		}
+		assert.Equal(t, file.Mode, entry.Mode, entry.Path)
+		if entry.Mode.IsDir() {
+			assert.Nil(t, entry.Signature)
+			continue
+		}
+		assert.Equal(t, int64(len(file.Data)), entry.Size)
+
+		// the same as a signature of the file on its own
+		expected, err := readSignature(buildSignature(file.Data))
+		assert.Nil(t, err)
+		assert.Equal(t, expected.Chunks, entry.Signature.Chunks, entry.Path)
+	}
+}
+
+func TestDirectorySignatureKeepsSpecialPermissions(t *testing.T) {
+	tree := fstest.MapFS{
+		"setuid": {Data: []byte("x"), Mode: fs.ModeSetuid | 0755},
+		"tmp":    {Mode: fs.ModeDir | fs.ModeSticky | 0777},
+	}
+
+	signature, err := readDirectorySignature(buildDirectorySignature(tree))
+	assert.Nil(t, err)
+	assert.Equal(t, fs.ModeSetuid|0755, signature.Entries[0].Mode)
+	assert.Equal(t, fs.ModeDir|fs.ModeSticky|0777, signature.Entries[1].Mode)
+}
+
+func TestDirectorySignatureRejectsSymlinks(t *testing.T) {
+	tree := fstest.MapFS{
+		"link": {Data: []byte("target"), Mode: fs.ModeSymlink | 0777},
+	}
+
+	err := octodiff.NewDirectorySignatureBuilder().Build(tree, &bytes.Buffer{})
+	assert.EqualError(t, err, "link is not a regular file or directory, which directory signatures don't support")
+}
+
+func TestReadDirectorySignatureErrors(t *testing.T) {
+	signatureFile := buildDirectorySignature(testTree())
+
+	_, err := readDirectorySignature(buildSignature(test.TestData()))
+	assert.ErrorIs(t, err, octodiff.ErrCorruptHeader)
+
+	_, err = readDirectorySignature(signatureFile[:len(signatureFile)-1])
+	assert.ErrorIs(t, err, octodiff.ErrTruncatedChunk)
+
+	_, err = readDirectorySignature(signatureFile[:20])
+	assert.ErrorIs(t, err, octodiff.ErrTruncatedChunk)
+
+	empty, err := readDirectorySignature(buildDirectorySignature(fstest.MapFS{}))
+	assert.Nil(t, err)
+	assert.Empty(t, empty.Entries)
+}
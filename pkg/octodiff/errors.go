@@ -0,0 +1,75 @@
+package octodiff
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Errors describing why a signature or delta file couldn't be read. These are wrapped in a *FormatError
+// which says which file the problem was in and where, so use errors.Is to check for them.
+var (
+	ErrCorruptHeader        = errors.New("corrupt header")
+	ErrUnsupportedVersion   = errors.New("unsupported file format version")
+	ErrUnsupportedAlgorithm = errors.New("unsupported algorithm")
+	ErrTruncatedChunk       = errors.New("truncated chunk")
+	ErrCorruptCommand       = errors.New("corrupt command")
+)
+
+// ErrVerificationFailed is matched by the *VerificationError returned when the new file doesn't have the expected hash
+var ErrVerificationFailed = errors.New("verification failed")
+
+// FormatError is returned when a signature or delta file can't be read
+type FormatError struct {
+	File    string // "signature" or "delta"
+	Offset  int64  // the offset in the file at which the problem was found
+	Err     error  // one of the Err... values above, saying what kind of problem it is
+	Message string
+}
+
+func (e *FormatError) Error() string {
+	return fmt.Sprintf("%s (at offset %d)", e.Message, e.Offset)
+}
+
+func (e *FormatError) Unwrap() error {
+	return e.Err
+}
+
+func newFormatError(file string, offset int64, err error, format string, args ...interface{}) *FormatError {
+	return &FormatError{File: file, Offset: offset, Err: err, Message: fmt.Sprintf(format, args...)}
+}
+
+// truncatedOrError turns the errors from reading past the end of a file into a FormatError, and passes others through
+func truncatedOrError(err error, file string, offset int64) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return newFormatError(file, offset, ErrTruncatedChunk, "the %s file appears to be corrupt; it ended part way through", file)
+	}
+	return err
+}
+
+// VerificationError is returned when the hash of the new file doesn't match the hash recorded in the delta
+type VerificationError struct {
+	HashAlgorithm string
+	ExpectedHash  []byte
+	ActualHash    []byte
+}
+
+func (e *VerificationError) Error() string {
+	return fmt.Sprintf("verification of the patched file failed. The %s hash of the patch result file, and the file that was used as input for the delta, do not match. This can happen if the basis file changed since the signatures were calculated", e.HashAlgorithm)
+}
+
+func (e *VerificationError) Is(target error) bool {
+	return target == ErrVerificationFailed
+}
+
+// countingReader keeps track of how far through a file we are, so errors can say where they happened
+type countingReader struct {
+	reader io.Reader
+	offset int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.offset += int64(n)
+	return n, err
+}
@@ -0,0 +1,126 @@
+package octodiff_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"github.com/OctopusDeploy/go-octodiff/pkg/octodiff"
+	"github.com/OctopusDeploy/go-octodiff/pkg/test"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func readSignatureError(signatureFile []byte) error {
+	_, err := octodiff.NewSignatureReader().ReadSignature(bytes.NewReader(signatureFile), int64(len(signatureFile)))
+	return err
+}
+
+func assertFormatError(t *testing.T, err error, file string, offset int64, kind error) {
+	assert.ErrorIs(t, err, kind)
+	var formatErr *octodiff.FormatError
+	if assert.True(t, errors.As(err, &formatErr)) {
+		assert.Equal(t, file, formatErr.File)
+		assert.Equal(t, offset, formatErr.Offset)
+	}
+}
+
+func TestSignatureErrors(t *testing.T) {
+	signatureFile := buildSignature(test.TestData())
+
+	corruptHeader := append([]byte(nil), signatureFile...)
+	corruptHeader[0] = 'X'
+	assertFormatError(t, readSignatureError(corruptHeader), "signature", 0, octodiff.ErrCorruptHeader)
+
+	newerVersion := append([]byte(nil), signatureFile...)
+	newerVersion[7] = 2
+	assertFormatError(t, readSignatureError(newerVersion), "signature", 7, octodiff.ErrUnsupportedVersion)
+
+	otherHash := append([]byte(nil), signatureFile...)
+	copy(otherHash[9:], "SHA2")
+	assertFormatError(t, readSignatureError(otherHash), "signature", 8, octodiff.ErrUnsupportedAlgorithm)
+
+	assertFormatError(t, readSignatureError(signatureFile[:10]), "signature", 10, octodiff.ErrTruncatedChunk)
+
+	err := readSignatureError(signatureFile[:len(signatureFile)-1])
+	assert.ErrorIs(t, err, octodiff.ErrTruncatedChunk)
+	assert.False(t, errors.Is(err, octodiff.ErrCorruptHeader))
+}
+
+func TestDeltaErrors(t *testing.T) {
+	deltaFile := buildDelta(test.TestData(), buildSignature(test.TestData()))
+
+	corruptHeader := append([]byte(nil), deltaFile...)
+	corruptHeader[0] = 'X'
+	_, err := applyWithLimits(test.TestData(), corruptHeader, nil)
+	assertFormatError(t, err, "delta", 0, octodiff.ErrCorruptHeader)
+
+	newerVersion := append([]byte(nil), deltaFile...)
+	newerVersion[9] = 2
+	_, err = applyWithLimits(test.TestData(), newerVersion, nil)
+	assertFormatError(t, err, "delta", 9, octodiff.ErrUnsupportedVersion)
+
+	// the metadata is 42 bytes long, so the first command is at offset 42
+	unknownCommand := append([]byte(nil), deltaFile...)
+	unknownCommand[42] = 0x12
+	_, err = applyWithLimits(test.TestData(), unknownCommand, nil)
+	assertFormatError(t, err, "delta", 42, octodiff.ErrCorruptCommand)
+
+	_, err = applyWithLimits(test.TestData(), deltaFile[:len(deltaFile)-4], nil)
+	assert.ErrorIs(t, err, octodiff.ErrTruncatedChunk)
+}
+
+func applyReaderError(reader octodiff.DeltaReader) error {
+	return reader.Apply(func([]byte) error { return nil }, func(int64, int64) error { return nil })
+}
+
+func TestRdiffDeltaErrors(t *testing.T) {
+	rdiffDeltaError := func(deltaHex string) error {
+		deltaFile, _ := hex.DecodeString(deltaHex)
+		return applyReaderError(octodiff.NewRdiffDeltaReader(bytes.NewReader(deltaFile)))
+	}
+	const magic = "72730236"
+
+	assertFormatError(t, rdiffDeltaError("72730237"+"00"), "delta", 0, octodiff.ErrCorruptHeader)
+	assertFormatError(t, rdiffDeltaError("7273"), "delta", 2, octodiff.ErrTruncatedChunk)
+	assertFormatError(t, rdiffDeltaError(magic+"01"+"61"+"55"), "delta", 6, octodiff.ErrCorruptCommand)      // an unknown opcode
+	assertFormatError(t, rdiffDeltaError(magic+"45"+"00"+"00"+"00"), "delta", 4, octodiff.ErrCorruptCommand) // an empty copy
+	assertFormatError(t, rdiffDeltaError(magic+"46"+"00"), "delta", 6, octodiff.ErrTruncatedChunk)           // part of a copy
+	assertFormatError(t, rdiffDeltaError(magic+"01"+"61"), "delta", 6, octodiff.ErrTruncatedChunk)           // no end marker
+}
+
+func TestVcdiffDeltaErrors(t *testing.T) {
+	vcdiffDeltaError := func(deltaFile []byte) error {
+		return applyReaderError(octodiff.NewVcdiffDeltaReader(bytes.NewReader(deltaFile)))
+	}
+	fromHex := func(s string) []byte {
+		b, _ := hex.DecodeString(s)
+		return b
+	}
+
+	assertFormatError(t, vcdiffDeltaError(fromHex("d6c3c5"+"0000")), "delta", 0, octodiff.ErrCorruptHeader)
+	assertFormatError(t, vcdiffDeltaError(fromHex("d6c3c401"+"00")), "delta", 3, octodiff.ErrUnsupportedVersion)
+	assertFormatError(t, vcdiffDeltaError(fromHex("d6c3c400"+"01")), "delta", 4, octodiff.ErrUnsupportedAlgorithm) // secondary compression
+	assertFormatError(t, vcdiffDeltaError(fromHex("d6c3c4")), "delta", 3, octodiff.ErrTruncatedChunk)
+
+	// a window which copies a byte from before the start of the target window, with no source segment;
+	// the address is the last byte of the file
+	badAddress := vcdiffDelta([]byte{0}, 1, nil, []byte{0x13, 1}, []byte{0})
+	assertFormatError(t, vcdiffDeltaError(badAddress), "delta", int64(len(badAddress)-1), octodiff.ErrCorruptCommand)
+
+	assertFormatError(t, vcdiffDeltaError(hugeRunDelta()), "delta", 15, octodiff.ErrCorruptCommand)
+	assertFormatError(t, vcdiffDeltaError(hugeRunDelta()[:10]), "delta", 10, octodiff.ErrTruncatedChunk)
+}
+
+func TestVerificationError(t *testing.T) {
+	deltaFile, _ := hex.DecodeString("4f43544f44454c544101045348413114000000330bd06982d3b5dbda6c1a6ad16687a0cdb03c0d3e3e3e800300000000000000aabbcc")
+
+	_, err := applyAndVerify(test.TestData(), octodiff.NewBinaryDeltaReader(bytes.NewReader(deltaFile)))
+	assert.ErrorIs(t, err, octodiff.ErrVerificationFailed)
+
+	var verificationErr *octodiff.VerificationError
+	if assert.True(t, errors.As(err, &verificationErr)) {
+		assert.Equal(t, "SHA1", verificationErr.HashAlgorithm)
+		assert.Equal(t, "330bd06982d3b5dbda6c1a6ad16687a0cdb03c0d", hex.EncodeToString(verificationErr.ExpectedHash))
+		assert.Equal(t, octodiff.DefaultHashAlgorithm.HashOverData([]byte{0xaa, 0xbb, 0xcc}), verificationErr.ActualHash)
+	}
+}
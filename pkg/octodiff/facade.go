@@ -0,0 +1,152 @@
+package octodiff
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+)
+
+// Sign, Diff, DiffFromSignature and Patch wrap up the builders, readers and writers in this package for the
+// common case of octodiff signatures and deltas of files on disk, buffering and verifying the same way the octodiff
+// command does. Use the types they're built from for anything else, such as other formats or files which aren't on disk.
+
+// Options changes how Sign, Diff, DiffFromSignature and Patch work. A nil *Options uses the defaults.
+type Options struct {
+	// ChunkSize is the size of the chunks in signatures; SignatureDefaultChunkSize if zero
+	ChunkSize int
+
+	// ProgressReporter, if set, is told how far through each step we are
+	ProgressReporter ProgressReporter
+
+	// SkipVerification stops Patch checking that the new file has the hash recorded in the delta,
+	// which is needed for deltas without one
+	SkipVerification bool
+
+	// Limits, if set, bound what Patch will do for a delta. See DeltaLimits
+	Limits *DeltaLimits
+
+	// BufferPool, if set, supplies the buffers used by Diff, DiffFromSignature and Patch, which saves
+	// allocating them for every call when there are many files. See BufferPool
+	BufferPool *BufferPool
+}
+
+func (o *Options) chunkSize() int {
+	if o == nil || o.ChunkSize == 0 {
+		return SignatureDefaultChunkSize
+	}
+	return o.ChunkSize
+}
+
+func (o *Options) bufferPool() *BufferPool {
+	if o == nil {
+		return nil
+	}
+	return o.BufferPool
+}
+
+func (o *Options) progressReporter() ProgressReporter {
+	if o == nil || o.ProgressReporter == nil {
+		return NopProgressReporter()
+	}
+	return o.ProgressReporter
+}
+
+// Sign writes a signature of the file at `basisPath` to `output`.
+// (It can't be called Signature, as that's the type SignatureReader reads signatures into.)
+func Sign(basisPath string, output io.Writer, options *Options) error {
+	basisFile, err := os.Open(basisPath)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = basisFile.Close() }()
+	basisFileInfo, err := basisFile.Stat()
+	if err != nil {
+		return err
+	}
+
+	signatureBuilder := NewSignatureBuilder()
+	signatureBuilder.ChunkSize = options.chunkSize()
+	signatureBuilder.ProgressReporter = options.progressReporter()
+
+	outputStream := bufio.NewWriter(output)
+	err = signatureBuilder.Build(bufio.NewReaderSize(basisFile, defaultReadBufferSize), basisFileInfo.Size(), outputStream)
+	if err != nil {
+		return err
+	}
+	return outputStream.Flush()
+}
+
+// Diff writes a delta to `output` which turns the file at `basisPath` into the file at `newPath`.
+// The signature of the basis file is kept in memory; use DiffFromSignature when the basis file is somewhere else.
+func Diff(basisPath string, newPath string, output io.Writer, options *Options) error {
+	var signature bytes.Buffer
+	err := Sign(basisPath, &signature, options)
+	if err != nil {
+		return err
+	}
+	return DiffFromSignature(&signature, newPath, output, options)
+}
+
+// DiffFromSignature writes a delta to `output` which turns the file that `signature` was made from into the file at `newPath`.
+// The signature is read into memory first, as its length has to be known to read it.
+func DiffFromSignature(signature io.Reader, newPath string, output io.Writer, options *Options) error {
+	signatureFile, err := io.ReadAll(signature)
+	if err != nil {
+		return err
+	}
+
+	newFile, err := os.Open(newPath)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = newFile.Close() }()
+	newFileInfo, err := newFile.Stat()
+	if err != nil {
+		return err
+	}
+
+	deltaBuilder := NewDeltaBuilder()
+	deltaBuilder.ProgressReporter = options.progressReporter()
+	deltaBuilder.BufferPool = options.bufferPool()
+
+	// not buffering newFile, as the delta builder seeks around it
+	outputStream := bufio.NewWriter(output)
+	deltaWriter := NewBinaryDeltaWriter(outputStream)
+	deltaWriter.BufferPool = options.bufferPool()
+	err = deltaBuilder.Build(newFile, newFileInfo.Size(), bytes.NewReader(signatureFile), int64(len(signatureFile)), deltaWriter)
+	if err != nil {
+		return err
+	}
+	return outputStream.Flush()
+}
+
+// Patch applies `delta` to the file at `basisPath`, writing the new file to `output`. Unless options.SkipVerification
+// is set, the new file is hashed as it's written, and a *VerificationError is returned if it doesn't match the delta.
+// By then the new file has been written, so callers writing to a file should only keep it if Patch succeeds.
+func Patch(basisPath string, delta io.Reader, output io.Writer, options *Options) error {
+	basisFile, err := os.Open(basisPath)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = basisFile.Close() }()
+
+	deltaReader := NewBinaryDeltaReader(bufio.NewReader(delta))
+	deltaReader.ProgressReporter = options.progressReporter()
+	applyOptions := ApplyDeltaOptions{Verify: options == nil || !options.SkipVerification, BufferPool: options.bufferPool()}
+	if options != nil {
+		applyOptions.Limits = options.Limits
+	}
+
+	if outputFile, ok := output.(*os.File); ok {
+		return ApplyDeltaWithOptions(basisFile, deltaReader, outputFile, applyOptions) // which buffers files itself
+	}
+
+	// not buffering basisFile, as applying the delta seeks around it
+	outputStream := bufio.NewWriter(output)
+	err = ApplyDeltaWithOptions(basisFile, deltaReader, outputStream, applyOptions)
+	if err != nil {
+		return err
+	}
+	return outputStream.Flush()
+}
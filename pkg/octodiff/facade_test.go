@@ -0,0 +1,84 @@
+package octodiff_test
+
+import (
+	"bytes"
+	"github.com/OctopusDeploy/go-octodiff/pkg/octodiff"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeTempFile(t *testing.T, name string, contents []byte) string {
+	path := filepath.Join(t.TempDir(), name)
+	err := os.WriteFile(path, contents, 0600)
+	assert.Nil(t, err)
+	return path
+}
+
+func TestFacadeRoundTrip(t *testing.T) {
+	original, newFile := largeFileWithDisjointChanges()
+	basisPath := writeTempFile(t, "basis", original)
+	newPath := writeTempFile(t, "new", newFile)
+
+	var signature bytes.Buffer
+	err := octodiff.Sign(basisPath, &signature, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, buildSignature(original), signature.Bytes())
+
+	var delta bytes.Buffer
+	err = octodiff.DiffFromSignature(&signature, newPath, &delta, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, buildDelta(newFile, buildSignature(original)), delta.Bytes())
+
+	var result bytes.Buffer
+	err = octodiff.Patch(basisPath, &delta, &result, nil)
+	assert.Nil(t, err)
+	assert.True(t, bytes.Equal(newFile, result.Bytes()))
+}
+
+func TestFacadeDiffIsTheSameAsDiffFromSignature(t *testing.T) {
+	original, newFile := largeFileWithDisjointChanges()
+	basisPath := writeTempFile(t, "basis", original)
+	newPath := writeTempFile(t, "new", newFile)
+	options := &octodiff.Options{ChunkSize: 128}
+
+	var delta bytes.Buffer
+	err := octodiff.Diff(basisPath, newPath, &delta, options)
+	assert.Nil(t, err)
+	assert.Equal(t, buildDelta(newFile, buildSignatureWithChunkSize(original, 128)), delta.Bytes())
+}
+
+func TestFacadePatchVerifiesTheNewFile(t *testing.T) {
+	original, newFile := largeFileWithDisjointChanges()
+	delta := buildDelta(newFile, buildSignature(original))
+	// not the basis file the delta was made for
+	basisPath := writeTempFile(t, "basis", bytes.Repeat([]byte{0xaa}, len(original)))
+
+	err := octodiff.Patch(basisPath, bytes.NewReader(delta), &bytes.Buffer{}, nil)
+	assert.ErrorIs(t, err, octodiff.ErrVerificationFailed)
+
+	err = octodiff.Patch(basisPath, bytes.NewReader(delta), &bytes.Buffer{}, &octodiff.Options{SkipVerification: true})
+	assert.Nil(t, err)
+}
+
+func TestFacadePatchChecksLimits(t *testing.T) {
+	original, newFile := largeFileWithDisjointChanges()
+	delta := buildDelta(newFile, buildSignature(original))
+	basisPath := writeTempFile(t, "basis", original)
+
+	options := &octodiff.Options{Limits: &octodiff.DeltaLimits{MaxOutputSize: int64(len(newFile) - 1)}}
+	err := octodiff.Patch(basisPath, bytes.NewReader(delta), &bytes.Buffer{}, options)
+	assert.ErrorIs(t, err, octodiff.ErrLimitExceeded)
+}
+
+func TestFacadeReportsMissingFiles(t *testing.T) {
+	missingPath := filepath.Join(t.TempDir(), "missing")
+
+	err := octodiff.Sign(missingPath, &bytes.Buffer{}, nil)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	err = octodiff.DiffFromSignature(bytes.NewReader(buildSignature(nil)), missingPath, &bytes.Buffer{}, nil)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	err = octodiff.Patch(missingPath, bytes.NewReader(buildDelta(nil, buildSignature(nil))), &bytes.Buffer{}, nil)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
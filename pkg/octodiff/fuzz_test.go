@@ -0,0 +1,136 @@
+package octodiff_test
+
+import (
+	"bytes"
+	"github.com/OctopusDeploy/go-octodiff/pkg/octodiff"
+	"github.com/OctopusDeploy/go-octodiff/pkg/test"
+	"io"
+	"testing"
+)
+
+// The fuzz targets below check that hostile signature and delta files produce errors rather than panics,
+// hangs or huge allocations. `go test` runs them over the seeds (and testdata/fuzz) only; to fuzz, use e.g.
+//
+//	go test ./pkg/octodiff -run '^$' -fuzz FuzzReadSignature -fuzztime 1m
+//
+// The seeds are kept small, as the fuzzer is much slower at minimizing large inputs.
+
+func addSignatureSeeds(f *testing.F) {
+	signatureFile := buildSignatureWithChunkSize(test.TestData(), 128)
+	f.Add(signatureFile)
+	f.Add(signatureFile[:len(signatureFile)-1])
+	f.Add(buildSignature(test.TestData()))
+	f.Add(buildSignature(nil))
+
+	var rdiffSignature bytes.Buffer
+	err := octodiff.NewRdiffSignatureBuilder().Build(bytes.NewReader(test.TestData()), int64(len(test.TestData())), &rdiffSignature)
+	if err != nil {
+		panic(err) // should never fail under tests
+	}
+	f.Add(rdiffSignature.Bytes())
+	f.Add([]byte{})
+}
+
+func addDeltaSeeds(f *testing.F) {
+	newFile := test.TestData()
+	newFile[200] = 0xaa
+	deltaFile := buildDelta(newFile, buildSignatureWithChunkSize(test.TestData(), 128))
+	f.Add(deltaFile)
+	f.Add(deltaFile[:len(deltaFile)-1])
+	f.Add(buildDelta(test.TestData(), buildSignature(test.TestData())))
+	f.Add(buildDelta(test.TestData(), buildSignature(nil)))
+	f.Add([]byte{})
+}
+
+func FuzzReadSignature(f *testing.F) {
+	addSignatureSeeds(f)
+	newFile := test.TestData()
+
+	f.Fuzz(func(t *testing.T, signatureFile []byte) {
+		signature, err := octodiff.NewSignatureReader().ReadSignature(bytes.NewReader(signatureFile), int64(len(signatureFile)))
+		if err != nil {
+			return
+		}
+		if signature.HashAlgorithm == nil || signature.RollingChecksumAlgorithm == nil {
+			t.Fatal("signature was read without its algorithms")
+		}
+
+		// anything we accept must also be usable to build a delta
+		var output bytes.Buffer
+		err = octodiff.NewDeltaBuilder().Build(bytes.NewReader(newFile), int64(len(newFile)), bytes.NewReader(signatureFile), int64(len(signatureFile)), octodiff.NewBinaryDeltaWriter(&output))
+		if err != nil {
+			t.Fatalf("signature could be read but not used to build a delta: %s", err)
+		}
+	})
+}
+
+func FuzzBinaryDeltaReader(f *testing.F) {
+	addDeltaSeeds(f)
+
+	f.Fuzz(func(t *testing.T, deltaFile []byte) {
+		reader := octodiff.NewBinaryDeltaReader(bytes.NewReader(deltaFile))
+		_, err := reader.ExpectedHash()
+		if err != nil {
+			return
+		}
+
+		dataLength := int64(0)
+		_ = reader.Apply(
+			func(data []byte) error {
+				dataLength += int64(len(data))
+				return nil
+			},
+			func(offset int64, length int64) error {
+				if offset < 0 || length < 0 {
+					t.Fatalf("copy command with negative offset %d or length %d", offset, length)
+				}
+				return nil
+			})
+		if dataLength > int64(len(deltaFile)) {
+			t.Fatalf("read %d bytes of data from a delta of %d bytes", dataLength, len(deltaFile))
+		}
+	})
+}
+
+func FuzzApplyDelta(f *testing.F) {
+	addDeltaSeeds(f)
+	basis := test.TestData()
+
+	f.Fuzz(func(t *testing.T, deltaFile []byte) {
+		// copy commands can repeat the basis file any number of times, so bound the output
+		limits := &octodiff.DeltaLimits{MaxOutputSize: 4 * int64(len(basis))}
+		options := octodiff.ApplyDeltaOptions{Limits: limits, Verify: true}
+
+		_ = octodiff.ApplyDeltaWithOptions(bytes.NewReader(basis), octodiff.NewBinaryDeltaReader(bytes.NewReader(deltaFile)), io.Discard, options)
+	})
+}
+
+func FuzzVcdiffDeltaReader(f *testing.F) {
+	var vcdiffDelta bytes.Buffer
+	newFile := test.TestData()
+	newFile[200] = 0xaa
+	signatureFile := buildSignatureWithChunkSize(test.TestData(), 128)
+	err := octodiff.NewDeltaBuilder().Build(bytes.NewReader(newFile), int64(len(newFile)), bytes.NewReader(signatureFile), int64(len(signatureFile)), octodiff.NewVcdiffDeltaWriter(&vcdiffDelta))
+	if err != nil {
+		panic(err) // should never fail under tests
+	}
+	f.Add(vcdiffDelta.Bytes())
+	// overlapping copies with a period of one byte, which must not take a command per byte
+	f.Add(overlappingCopyDelta(4*1024*1024, false))
+	f.Add(overlappingCopyDelta(4*1024*1024, true))
+	// an instruction size which overflows when added to the size of the window so far
+	f.Add(hugeRunDelta())
+	f.Add([]byte{})
+
+	f.Fuzz(func(t *testing.T, deltaFile []byte) {
+		reader := octodiff.NewVcdiffDeltaReader(bytes.NewReader(deltaFile))
+		_ = reader.Apply(
+			func(data []byte) error { return nil },
+			func(offset int64, length int64) error {
+				if offset < 0 || length < 0 {
+					t.Fatalf("copy command with negative offset %d or length %d", offset, length)
+				}
+				return nil
+			})
+	})
+}
@@ -2,6 +2,7 @@ package octodiff
 
 import (
 	"crypto/sha1"
+	"hash"
 	"io"
 )
 
@@ -49,4 +50,61 @@ func (s *Sha1HashAlgorithm) HashOverReader(reader io.Reader) ([]byte, error) {
 	return sha.Sum(nil), nil
 }
 
+func (s *Sha1HashAlgorithm) streamingHash() hash.Hash {
+	return sha1.New()
+}
+
 var DefaultHashAlgorithm HashAlgorithm = &Sha1HashAlgorithm{}
+
+// streamingHashAlgorithm is implemented by our own hash algorithms, so that data can be hashed as it is written.
+// HashAlgorithm only offers HashOverReader, so other implementations are fed through a pipe instead.
+type streamingHashAlgorithm interface {
+	streamingHash() hash.Hash
+}
+
+// streamingHasher computes the hash of everything written to it
+type streamingHasher struct {
+	writer io.Writer
+	sum    func() ([]byte, error)
+	close  func() // releases resources if sum is never called
+}
+
+func newStreamingHasher(algorithm HashAlgorithm) *streamingHasher {
+	if s, ok := algorithm.(streamingHashAlgorithm); ok {
+		h := s.streamingHash()
+		return &streamingHasher{
+			writer: h,
+			sum: func() ([]byte, error) {
+				return h.Sum(nil)[:algorithm.HashLength()], nil
+			},
+			close: func() {},
+		}
+	}
+
+	type result struct {
+		hash []byte
+		err  error
+	}
+	pipeReader, pipeWriter := io.Pipe()
+	results := make(chan result, 1)
+	go func() {
+		h, err := algorithm.HashOverReader(pipeReader)
+		_ = pipeReader.CloseWithError(err) // unblocks the writer if the algorithm gives up early
+		results <- result{h, err}
+	}()
+	return &streamingHasher{
+		writer: pipeWriter,
+		sum: func() ([]byte, error) {
+			_ = pipeWriter.Close()
+			r := <-results
+			return r.hash, r.err
+		},
+		close: func() {
+			_ = pipeWriter.CloseWithError(io.ErrClosedPipe)
+		},
+	}
+}
+
+func (h *streamingHasher) Write(p []byte) (int, error) {
+	return h.writer.Write(p)
+}
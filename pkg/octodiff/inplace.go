@@ -0,0 +1,292 @@
+package octodiff
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+// InPlaceFile is a basis file which can be rewritten into the new file; *os.File opened for reading and writing satisfies it
+type InPlaceFile interface {
+	io.ReaderAt
+	io.WriterAt
+	io.Seeker
+	Truncate(size int64) error
+}
+
+// InPlacePatchError is returned by ApplyDeltaInPlace when something fails after the basis file has started to be overwritten.
+// The file is then partly the basis file and partly the new file, and is no longer usable as either;
+// it must be restored from elsewhere before trying again. The same is true if the process crashes or loses power
+// part way through, so in-place patching should only be used where the basis file can be recovered, and the result
+// should always be verified.
+// Errors returned before anything was written (a corrupt delta, or copy commands outside the basis file) are not wrapped.
+type InPlacePatchError struct {
+	Err error
+}
+
+func (e *InPlacePatchError) Error() string {
+	return fmt.Sprintf("the basis file was partially patched and is no longer usable: %s", e.Err)
+}
+
+func (e *InPlacePatchError) Unwrap() error {
+	return e.Err
+}
+
+// ApplyDeltaInPlace turns `file` from the basis file into the new file, without needing space for a second copy.
+// The whole delta is read up front (so data commands are held in memory), and copy commands are split into pieces of
+// at most a megabyte and reordered so that no piece overwrites basis file data that another still needs. Where pieces
+// depend on each other in a cycle, the shortest piece in the cycle is read into memory and written out once the rest
+// of the cycle is done, so breaking a cycle never takes more than a megabyte however long the copies are.
+// Verifying the new file is left to the caller, as with ApplyDelta.
+func ApplyDeltaInPlace(file InPlaceFile, deltaReader DeltaReader) error {
+	delta := &Delta{} // the hash isn't needed here, so deltas without one can be applied too
+	err := delta.readCommands(deltaReader)
+	if err != nil {
+		return err
+	}
+	basisFileLength, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+
+	plan, err := planInPlace(basisFileLength, delta.Commands)
+	if err != nil {
+		return err
+	}
+
+	err = plan.execute(file)
+	if err != nil {
+		return &InPlacePatchError{Err: err}
+	}
+	return nil
+}
+
+// inPlaceMaxCopyLength is the longest piece a copy is split into, which bounds the memory used to break a cycle
+const inPlaceMaxCopyLength = 1024 * 1024
+
+// inPlaceCopy moves `length` bytes from `from` in the basis file to `to` in the new file
+type inPlaceCopy struct {
+	from   int64
+	to     int64
+	length int64
+}
+
+type inPlaceWrite struct {
+	to   int64
+	data []byte
+}
+
+type inPlaceStepType int
+
+const (
+	inPlaceCopyStep    inPlaceStepType = iota // copy within the file
+	inPlaceSaveStep                           // read the copy's source into memory, to break a cycle
+	inPlaceRestoreStep                        // write out what an earlier save step read
+)
+
+// inPlaceStep is one step of an inPlacePlan. Save and restore steps are matched up by `copy`
+type inPlaceStep struct {
+	stepType inPlaceStepType
+	copy     inPlaceCopy
+}
+
+// inPlacePlan is the order in which to carry out a delta over the top of its basis file:
+// first the copies, each before anything overwrites its source, then literal data, then truncation.
+type inPlacePlan struct {
+	steps         []inPlaceStep
+	writes        []inPlaceWrite
+	newFileLength int64
+}
+
+func planInPlace(basisFileLength int64, commands []*DeltaCommand) (*inPlacePlan, error) {
+	plan := &inPlacePlan{}
+
+	// the targets of the copies are disjoint and in order, as the new file is written sequentially
+	var copies []inPlaceCopy
+	for _, cmd := range commands {
+		if cmd.Type == DeltaCommandCopy {
+			if cmd.Offset < 0 || cmd.Length < 0 || cmd.Offset > basisFileLength || cmd.Length > basisFileLength-cmd.Offset {
+				return nil, &CopyOutOfRangeError{Offset: cmd.Offset, Length: cmd.Length, BasisFileLength: basisFileLength}
+			}
+			if cmd.Offset != plan.newFileLength { // data that's already in the right place has nothing to do
+				for offset := int64(0); offset < cmd.Length; offset += inPlaceMaxCopyLength {
+					length := cmd.Length - offset
+					if length > inPlaceMaxCopyLength {
+						length = inPlaceMaxCopyLength
+					}
+					copies = append(copies, inPlaceCopy{from: cmd.Offset + offset, to: plan.newFileLength + offset, length: length})
+				}
+			}
+		} else if cmd.Length > 0 {
+			plan.writes = append(plan.writes, inPlaceWrite{to: plan.newFileLength, data: cmd.Data})
+		}
+		plan.newFileLength += cmd.Length
+	}
+
+	// successors[u] are the copies which overwrite data that copy u reads, so must run after it
+	successors := make([][]int, len(copies))
+	predecessors := make([][]int, len(copies))
+	waitingOn := make([]int, len(copies))
+	for u, c := range copies {
+		end := c.from + c.length
+		first := sort.Search(len(copies), func(i int) bool { return copies[i].to+copies[i].length > c.from })
+		for v := first; v < len(copies) && copies[v].to < end; v++ {
+			if v == u { // a copy which overlaps itself is handled by copying in the right direction
+				continue
+			}
+			successors[u] = append(successors[u], v)
+			predecessors[v] = append(predecessors[v], u)
+			waitingOn[v]++
+		}
+	}
+
+	done := make([]bool, len(copies))
+	ready := make([]int, 0, len(copies))
+	for u := range copies {
+		if waitingOn[u] == 0 {
+			ready = append(ready, u)
+		}
+	}
+	finish := func(u int) {
+		done[u] = true
+		for _, v := range successors[u] {
+			waitingOn[v]--
+			if waitingOn[v] == 0 {
+				if done[v] { // saved to break a cycle, and now nothing else reads where it goes
+					plan.steps = append(plan.steps, inPlaceStep{stepType: inPlaceRestoreStep, copy: copies[v]})
+				} else {
+					ready = append(ready, v)
+				}
+			}
+		}
+	}
+
+	remaining := len(copies)
+	next := 0 // everything before this is done
+	for remaining > 0 {
+		for len(ready) > 0 {
+			u := ready[len(ready)-1]
+			ready = ready[:len(ready)-1]
+			plan.steps = append(plan.steps, inPlaceStep{stepType: inPlaceCopyStep, copy: copies[u]})
+			finish(u)
+			remaining--
+		}
+		if remaining == 0 {
+			break
+		}
+
+		// every remaining copy is waiting on another remaining copy, so following them back must lead to a cycle
+		for done[next] {
+			next++
+		}
+		u := findInPlaceCycleMinimum(next, copies, predecessors, done)
+
+		// nothing has overwritten its source yet, as everything that would is waiting on it
+		plan.steps = append(plan.steps, inPlaceStep{stepType: inPlaceSaveStep, copy: copies[u]})
+		finish(u)
+		remaining--
+	}
+	return plan, nil
+}
+
+// findInPlaceCycleMinimum follows copies back from `start` through ones that aren't done yet until it finds a cycle,
+// and returns the shortest copy in that cycle.
+func findInPlaceCycleMinimum(start int, copies []inPlaceCopy, predecessors [][]int, done []bool) int {
+	visitedAt := map[int]int{}
+	path := make([]int, 0)
+	u := start
+	for {
+		if i, ok := visitedAt[u]; ok {
+			best := path[i]
+			for _, v := range path[i:] {
+				if copies[v].length < copies[best].length {
+					best = v
+				}
+			}
+			return best
+		}
+		visitedAt[u] = len(path)
+		path = append(path, u)
+		for _, p := range predecessors[u] {
+			if !done[p] {
+				u = p
+				break
+			}
+		}
+	}
+}
+
+func (p *inPlacePlan) execute(file InPlaceFile) error {
+	buffer := make([]byte, defaultReadBufferSize)
+	saved := map[int64][]byte{} // by the offset they're restored to
+	var spare [][]byte          // buffers which have been restored from, to use again
+	for _, step := range p.steps {
+		c := step.copy
+		switch step.stepType {
+		case inPlaceCopyStep:
+			err := copyWithin(file, c, buffer)
+			if err != nil {
+				return err
+			}
+		case inPlaceSaveStep:
+			var data []byte
+			if len(spare) > 0 {
+				data = spare[len(spare)-1][:c.length]
+				spare = spare[:len(spare)-1]
+			} else {
+				data = make([]byte, c.length, inPlaceMaxCopyLength)
+			}
+			n, err := file.ReadAt(data, c.from)
+			if n < len(data) {
+				if err == nil || err == io.EOF {
+					err = io.ErrUnexpectedEOF
+				}
+				return err
+			}
+			saved[c.to] = data
+		case inPlaceRestoreStep:
+			_, err := file.WriteAt(saved[c.to], c.to)
+			if err != nil {
+				return err
+			}
+			spare = append(spare, saved[c.to])
+			delete(saved, c.to)
+		}
+	}
+	for _, w := range p.writes {
+		_, err := file.WriteAt(w.data, w.to)
+		if err != nil {
+			return err
+		}
+	}
+	return file.Truncate(p.newFileLength)
+}
+
+// copyWithin copies a range of the file over another, which may overlap it, in the same way as memmove
+func copyWithin(file InPlaceFile, c inPlaceCopy, buffer []byte) error {
+	forwards := c.to < c.from
+	for remaining := c.length; remaining > 0; {
+		n := int64(len(buffer))
+		if n > remaining {
+			n = remaining
+		}
+		offset := c.length - remaining // copying forwards, start at the beginning...
+		if !forwards {
+			offset = remaining - n // ...otherwise start at the end, so we never overwrite bytes before reading them
+		}
+
+		read, err := file.ReadAt(buffer[:n], c.from+offset)
+		if int64(read) < n {
+			if err == nil || err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		_, err = file.WriteAt(buffer[:n], c.to+offset)
+		if err != nil {
+			return err
+		}
+		remaining -= n
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+package octodiff_test
+
+import (
+	"bytes"
+	"errors"
+	"github.com/OctopusDeploy/go-octodiff/pkg/octodiff"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"math/rand"
+	"runtime"
+	"testing"
+)
+
+// memoryFile is an in-memory octodiff.InPlaceFile
+type memoryFile struct {
+	data       []byte
+	failWrites bool
+}
+
+func (m *memoryFile) ReadAt(p []byte, offset int64) (int, error) {
+	if offset >= int64(len(m.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, m.data[offset:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (m *memoryFile) WriteAt(p []byte, offset int64) (int, error) {
+	if m.failWrites {
+		return 0, errors.New("disk full")
+	}
+	end := offset + int64(len(p))
+	if end > int64(len(m.data)) {
+		m.data = append(m.data, make([]byte, end-int64(len(m.data)))...)
+	}
+	copy(m.data[offset:], p)
+	return len(p), nil
+}
+
+func (m *memoryFile) Seek(offset int64, whence int) (int64, error) {
+	return bytes.NewReader(m.data).Seek(offset, whence)
+}
+
+func (m *memoryFile) Truncate(size int64) error {
+	if size > int64(len(m.data)) {
+		m.data = append(m.data, make([]byte, size-int64(len(m.data)))...)
+	}
+	m.data = m.data[:size]
+	return nil
+}
+
+func applyInPlace(basis []byte, deltaFile []byte) ([]byte, error) {
+	file := &memoryFile{data: append([]byte(nil), basis...)}
+	err := octodiff.ApplyDeltaInPlace(file, octodiff.NewBinaryDeltaReader(bytes.NewReader(deltaFile)))
+	return file.data, err
+}
+
+func TestApplyDeltaInPlace(t *testing.T) {
+	random := rand.New(rand.NewSource(34))
+	original := make([]byte, 64*1024)
+	random.Read(original)
+
+	concat := func(parts ...[]byte) []byte {
+		return bytes.Join(parts, nil)
+	}
+	tests := []struct {
+		name    string
+		newFile []byte
+	}{
+		{"unchanged", original},
+		{"swapped halves", concat(original[32*1024:], original[:32*1024])},
+		{"rotated thirds", concat(original[20*1024:40*1024], original[40*1024:], original[:20*1024])},
+		{"shifted forwards", concat([]byte("inserted at the start"), original)},
+		{"shifted backwards", original[10*1024:]},
+		{"duplicated", concat(original[:16*1024], original[:16*1024], original[:16*1024], original[8*1024:])},
+		{"grown", concat(original, []byte("appended"), original[:4*1024])},
+		{"shrunk", concat(original[40*1024:44*1024], []byte("middle"), original[:2*1024])},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deltaFile := buildDelta(tt.newFile, buildSignatureWithChunkSize(original, 1024))
+
+			output, err := applyInPlace(original, deltaFile)
+			assert.Nil(t, err)
+			assert.Equal(t, tt.newFile, output)
+		})
+	}
+}
+
+func TestApplyDeltaInPlaceBreaksCycles(t *testing.T) {
+	basis := []byte("0123456789abcdefghijklmnopqrstuvwxyz")
+
+	// each copy overwrites the source of the next, in a cycle
+	delta := &octodiff.Delta{
+		HashAlgorithm: octodiff.DefaultHashAlgorithm,
+		ExpectedHash:  make([]byte, 20),
+		Commands: []*octodiff.DeltaCommand{
+			octodiff.NewCopyCommand(10, 10),
+			octodiff.NewCopyCommand(25, 11),
+			octodiff.NewCopyCommand(0, 10),
+			octodiff.NewCopyCommand(20, 5),
+		},
+	}
+	var expected bytes.Buffer
+	assert.Nil(t, delta.Apply(bytes.NewReader(basis), &expected))
+
+	file := &memoryFile{data: append([]byte(nil), basis...)}
+	assert.Nil(t, octodiff.ApplyDeltaInPlace(file, delta.Reader()))
+	assert.Equal(t, expected.String(), string(file.data))
+}
+
+func TestApplyDeltaInPlaceBreaksLongCyclesInBoundedMemory(t *testing.T) {
+	const half = 16 * 1024 * 1024
+	random := rand.New(rand.NewSource(34))
+	basis := make([]byte, 2*half)
+	random.Read(basis)
+
+	tests := []struct {
+		name     string
+		commands []*octodiff.DeltaCommand
+	}{
+		{"swapped halves", []*octodiff.DeltaCommand{octodiff.NewCopyCommand(half, half), octodiff.NewCopyCommand(0, half)}},
+		{"shifted forwards", []*octodiff.DeltaCommand{octodiff.NewDataCommand([]byte("abc")), octodiff.NewCopyCommand(0, 2*half)}},
+		{"shifted backwards", []*octodiff.DeltaCommand{octodiff.NewCopyCommand(3, 2*half-3), octodiff.NewDataCommand([]byte("abc"))}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			delta := &octodiff.Delta{HashAlgorithm: octodiff.DefaultHashAlgorithm, ExpectedHash: make([]byte, 20), Commands: tt.commands}
+			var expected bytes.Buffer
+			assert.Nil(t, delta.Apply(bytes.NewReader(basis), &expected))
+
+			file := &memoryFile{data: append(make([]byte, 0, 2*half+3), basis...)} // room to grow without reallocating
+			var before, after runtime.MemStats
+			runtime.ReadMemStats(&before)
+			err := octodiff.ApplyDeltaInPlace(file, delta.Reader())
+			runtime.ReadMemStats(&after)
+			assert.Nil(t, err)
+			assert.True(t, bytes.Equal(expected.Bytes(), file.data))
+			// the copy buffer and a piece of a copy, rather than a whole half of the file
+			assert.Less(t, after.TotalAlloc-before.TotalAlloc, uint64(8*1024*1024))
+		})
+	}
+}
+
+func TestApplyDeltaInPlaceErrors(t *testing.T) {
+	original, newFile := largeFileWithDisjointChanges()
+	deltaFile := buildDelta(newFile, buildSignature(original))
+
+	// checked before anything is written, so the basis file is untouched
+	truncated := append([]byte(nil), original[:1000]...)
+	output, err := applyInPlace(truncated, deltaFile)
+	var rangeErr *octodiff.CopyOutOfRangeError
+	assert.True(t, errors.As(err, &rangeErr))
+	assert.Equal(t, truncated, output)
+
+	file := &memoryFile{data: append([]byte(nil), original...), failWrites: true}
+	err = octodiff.ApplyDeltaInPlace(file, octodiff.NewBinaryDeltaReader(bytes.NewReader(deltaFile)))
+	var inPlaceErr *octodiff.InPlacePatchError
+	assert.True(t, errors.As(err, &inPlaceErr))
+	assert.EqualError(t, err, "the basis file was partially patched and is no longer usable: disk full")
+}
+
+func TestApplyDeltaInPlaceWithoutExpectedHash(t *testing.T) {
+	original, newFile := largeFileWithDisjointChanges()
+	signature := buildRdiffSignature(original, octodiff.RdiffRabinKarpSignatureMagic)
+	var deltaFile bytes.Buffer
+	err := octodiff.NewDeltaBuilder().Build(bytes.NewReader(newFile), int64(len(newFile)), bytes.NewReader(signature), int64(len(signature)), octodiff.NewRdiffDeltaWriter(&deltaFile))
+	assert.Nil(t, err)
+
+	file := &memoryFile{data: append([]byte(nil), original...)}
+	err = octodiff.ApplyDeltaInPlace(file, octodiff.NewRdiffDeltaReader(bytes.NewReader(deltaFile.Bytes())))
+	assert.Nil(t, err)
+	assert.Equal(t, newFile, file.data)
+}
@@ -0,0 +1,107 @@
+package octodiff_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/OctopusDeploy/go-octodiff/pkg/octodiff"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// These tests check the rdiff formats against librsync itself, using its rdiff tool, so they are skipped where it isn't
+// installed (e.g. `apt-get install rdiff`). Unlike the C# cases there are no checked-in files, because which signature
+// rdiff makes by default depends on the version of librsync; instead the signature builder is given the same settings.
+
+func lookPathRdiff(t *testing.T) string {
+	rdiff, err := exec.LookPath("rdiff")
+	if err != nil {
+		t.Skip("rdiff is not installed")
+	}
+	return rdiff
+}
+
+func runRdiff(t *testing.T, rdiff string, args ...string) {
+	output, err := exec.Command(rdiff, args...).CombinedOutput()
+	if err != nil {
+		t.Fatalf("rdiff %v: %v\n%s", args, err, output)
+	}
+}
+
+var librsyncConformanceCases = []struct {
+	name string
+	args []string // for `rdiff signature`
+}{
+	{"defaults", nil},
+	{"small-blocks-short-sums", []string{"-b", "256", "-S", "8"}},
+}
+
+func TestSignaturesMatchLibrsync(t *testing.T) {
+	rdiff := lookPathRdiff(t)
+	original, _ := largeFileWithDisjointChanges()
+	basisPath := writeTempFile(t, "basis", original)
+
+	for _, tc := range librsyncConformanceCases {
+		t.Run(tc.name, func(t *testing.T) {
+			signaturePath := filepath.Join(t.TempDir(), "basis.sig")
+			runRdiff(t, rdiff, append(append([]string{"signature"}, tc.args...), basisPath, signaturePath)...)
+			expected, err := os.ReadFile(signaturePath)
+			assert.Nil(t, err)
+
+			builder := octodiff.NewRdiffSignatureBuilder()
+			builder.Magic = binary.BigEndian.Uint32(expected[0:])
+			builder.BlockLength = int(binary.BigEndian.Uint32(expected[4:]))
+			builder.StrongSumLength = int(binary.BigEndian.Uint32(expected[8:]))
+			var output bytes.Buffer
+			err = builder.Build(bytes.NewReader(original), int64(len(original)), &output)
+			assert.Nil(t, err)
+			assert.Equal(t, expected, output.Bytes())
+		})
+	}
+}
+
+func TestLibrsyncDeltasCanBeApplied(t *testing.T) {
+	rdiff := lookPathRdiff(t)
+	original, newFile := largeFileWithDisjointChanges()
+	newFilePath := writeTempFile(t, "new", newFile)
+
+	for _, magic := range []uint32{octodiff.RdiffMd4SignatureMagic, octodiff.RdiffRabinKarpSignatureMagic} {
+		signaturePath := writeTempFile(t, "basis.sig", buildRdiffSignature(original, magic))
+		deltaPath := filepath.Join(t.TempDir(), "new.delta")
+		runRdiff(t, rdiff, "delta", signaturePath, newFilePath, deltaPath)
+		deltaFile, err := os.ReadFile(deltaPath)
+		assert.Nil(t, err)
+
+		var output bytes.Buffer
+		err = octodiff.ApplyDelta(bytes.NewReader(original), octodiff.NewRdiffDeltaReader(bytes.NewReader(deltaFile)), &output)
+		assert.Nil(t, err)
+		assert.Equal(t, newFile, output.Bytes())
+	}
+}
+
+func TestDeltasCanBeAppliedByLibrsync(t *testing.T) {
+	rdiff := lookPathRdiff(t)
+	original, newFile := largeFileWithDisjointChanges()
+	basisPath := writeTempFile(t, "basis", original)
+
+	for _, tc := range librsyncConformanceCases {
+		t.Run(tc.name, func(t *testing.T) {
+			signaturePath := filepath.Join(t.TempDir(), "basis.sig")
+			runRdiff(t, rdiff, append(append([]string{"signature"}, tc.args...), basisPath, signaturePath)...)
+			signatureFile, err := os.ReadFile(signaturePath)
+			assert.Nil(t, err)
+
+			var deltaFile bytes.Buffer
+			err = octodiff.NewDeltaBuilder().Build(bytes.NewReader(newFile), int64(len(newFile)), bytes.NewReader(signatureFile), int64(len(signatureFile)), octodiff.NewRdiffDeltaWriter(&deltaFile))
+			assert.Nil(t, err)
+
+			outputPath := filepath.Join(t.TempDir(), "new")
+			runRdiff(t, rdiff, "patch", basisPath, writeTempFile(t, "new.delta", deltaFile.Bytes()), outputPath)
+			output, err := os.ReadFile(outputPath)
+			assert.Nil(t, err)
+			assert.Equal(t, newFile, output)
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package octodiff
+
+import (
+	"io"
+	"sync"
+)
+
+// copy commands are split into pieces no bigger than this, so that a single large copy can be spread across workers
+const parallelCopyChunkSize = 1024 * 1024
+
+// parallelCopyJob copies `length` bytes from `basisOffset` in the basis file to `outputOffset` in the new file
+type parallelCopyJob struct {
+	basisOffset  int64
+	outputOffset int64
+	length       int64
+}
+
+// applyDeltaParallel builds the new file as ApplyDelta does, but positions every write explicitly so that
+// copy commands can be carried out by a pool of `parallelism` workers while the delta is still being read.
+// Data commands are written as they are read, since their bytes are only valid for the duration of the callback.
+// Returns the length of the new file.
+func applyDeltaParallel(basisFile io.ReaderAt, deltaReader DeltaReader, output io.WriterAt, parallelism int, limits *applyLimits) (int64, error) {
+	jobs := make(chan parallelCopyJob, parallelism*2)
+	done := make(chan struct{})
+
+	var firstErr error
+	var errOnce sync.Once
+	fail := func(err error) {
+		errOnce.Do(func() {
+			firstErr = err
+			close(done)
+		})
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < parallelism; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			buffer := make([]byte, parallelCopyChunkSize)
+			for job := range jobs {
+				err := copyAt(basisFile, output, job, buffer)
+				if err != nil {
+					fail(err)
+				}
+			}
+		}()
+	}
+
+	outputOffset := int64(0)
+	err := deltaReader.Apply(
+		func(bytes []byte) error {
+			err := limits.checkData(bytes)
+			if err != nil {
+				return err
+			}
+			_, err = output.WriteAt(bytes, outputOffset)
+			if err != nil {
+				return err
+			}
+			outputOffset += int64(len(bytes))
+			return nil
+		},
+		func(offset int64, length int64) error {
+			err := limits.checkCopy(offset, length)
+			if err != nil {
+				return err
+			}
+
+			for length > 0 {
+				n := int64(parallelCopyChunkSize)
+				if n > length {
+					n = length
+				}
+				select {
+				case jobs <- parallelCopyJob{basisOffset: offset, outputOffset: outputOffset, length: n}:
+				case <-done: // a worker failed; stop reading the delta
+					return firstErr
+				}
+				offset += n
+				outputOffset += n
+				length -= n
+			}
+			return nil
+		})
+	close(jobs)
+	wg.Wait()
+
+	if err != nil {
+		return 0, err
+	}
+	return outputOffset, firstErr
+}
+
+func copyAt(basisFile io.ReaderAt, output io.WriterAt, job parallelCopyJob, buffer []byte) error {
+	data := buffer[:job.length]
+	n, err := basisFile.ReadAt(data, job.basisOffset)
+	if n < len(data) {
+		if err == nil || err == io.EOF {
+			return &CopyOutOfRangeError{Offset: job.basisOffset, Length: job.length, BasisFileLength: job.basisOffset + int64(n)}
+		}
+		return err
+	}
+	// ReadAt may return io.EOF alongside a full read when the copy ends exactly at the end of the basis file
+
+	_, err = output.WriteAt(data, job.outputOffset)
+	return err
+}
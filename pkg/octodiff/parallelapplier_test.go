@@ -0,0 +1,100 @@
+package octodiff_test
+
+import (
+	"bytes"
+	"errors"
+	"github.com/OctopusDeploy/go-octodiff/pkg/octodiff"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"math/rand"
+	"sync"
+	"testing"
+)
+
+// memoryWriterAt is an in-memory file which can be written to concurrently at arbitrary offsets
+type memoryWriterAt struct {
+	mutex sync.Mutex
+	data  []byte
+}
+
+func (m *memoryWriterAt) Write(p []byte) (int, error) {
+	return 0, errors.New("parallel apply should only write at explicit offsets")
+}
+
+func (m *memoryWriterAt) WriteAt(p []byte, offset int64) (int, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	end := offset + int64(len(p))
+	if end > int64(len(m.data)) {
+		m.data = append(m.data, make([]byte, end-int64(len(m.data)))...)
+	}
+	copy(m.data[offset:], p)
+	return len(p), nil
+}
+
+func (m *memoryWriterAt) ReadAt(p []byte, offset int64) (int, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return bytes.NewReader(m.data).ReadAt(p, offset)
+}
+
+func TestParallelApplyMatchesSequential(t *testing.T) {
+	// large enough that copies are split into several pieces
+	random := rand.New(rand.NewSource(33))
+	original := make([]byte, 5*1024*1024)
+	random.Read(original)
+	newFile := append([]byte(nil), original[3*1024*1024:]...)
+	newFile = append(newFile, []byte("something new in the middle")...)
+	newFile = append(newFile, original[:3*1024*1024]...)
+	newFile[1234567] ^= 0xff
+
+	deltaFile := buildDelta(newFile, buildSignature(original))
+
+	for _, parallelism := range []int{2, 8} {
+		output := &memoryWriterAt{}
+		reader := octodiff.NewBinaryDeltaReader(bytes.NewReader(deltaFile))
+		err := octodiff.ApplyDeltaWithOptions(bytes.NewReader(original), reader, output, octodiff.ApplyDeltaOptions{Parallelism: parallelism, Verify: true})
+		assert.Nil(t, err)
+		assert.Equal(t, newFile, output.data)
+	}
+}
+
+func TestParallelApplyFallsBackWithoutWriterAt(t *testing.T) {
+	original, newFile := largeFileWithDisjointChanges()
+	deltaFile := buildDelta(newFile, buildSignature(original))
+
+	var output bytes.Buffer
+	err := octodiff.ApplyDeltaWithOptions(bytes.NewReader(original), octodiff.NewBinaryDeltaReader(bytes.NewReader(deltaFile)), &output, octodiff.ApplyDeltaOptions{Parallelism: 4})
+	assert.Nil(t, err)
+	assert.Equal(t, newFile, output.Bytes())
+}
+
+// failingReaderAt fails every read, after behaving like a normal basis file for Seek
+type failingReaderAt struct {
+	*bytes.Reader
+}
+
+func (f failingReaderAt) ReadAt(p []byte, offset int64) (int, error) {
+	return 0, io.ErrUnexpectedEOF
+}
+
+func TestParallelApplyReportsCopyFailure(t *testing.T) {
+	original, newFile := largeFileWithDisjointChanges()
+	deltaFile := buildDelta(newFile, buildSignature(original))
+
+	err := octodiff.ApplyDeltaWithOptions(failingReaderAt{bytes.NewReader(original)}, octodiff.NewBinaryDeltaReader(bytes.NewReader(deltaFile)), &memoryWriterAt{}, octodiff.ApplyDeltaOptions{Parallelism: 4})
+	assert.True(t, errors.Is(err, io.ErrUnexpectedEOF))
+}
+
+func TestParallelApplyChecksCopyBounds(t *testing.T) {
+	original, newFile := largeFileWithDisjointChanges()
+	deltaFile := buildDelta(newFile, buildSignature(original))
+
+	err := octodiff.ApplyDeltaWithOptions(bytes.NewReader(original[:1000]), octodiff.NewBinaryDeltaReader(bytes.NewReader(deltaFile)), &memoryWriterAt{}, octodiff.ApplyDeltaOptions{Parallelism: 4, Limits: &octodiff.DeltaLimits{}})
+	var rangeErr *octodiff.CopyOutOfRangeError
+	assert.True(t, errors.As(err, &rangeErr))
+
+	// without limits, the basis file's length isn't checked up front, but copies past the end are still found
+	err = octodiff.ApplyDeltaWithOptions(bytes.NewReader(original[:1000]), octodiff.NewBinaryDeltaReader(bytes.NewReader(deltaFile)), &memoryWriterAt{}, octodiff.ApplyDeltaOptions{Parallelism: 4})
+	assert.True(t, errors.As(err, &rangeErr))
+}
@@ -0,0 +1,107 @@
+package octodiff
+
+import (
+	"errors"
+	"io"
+)
+
+// PatchedFile reads the new file produced by applying a delta to a basis file, without writing the new file out.
+// Any range can be read at random, with only the parts of the basis file that it copies from being read.
+// The delta's commands (including its literal data) are held in memory, indexed by their offset in the new file.
+//
+// ReadAt may be called concurrently, if the basis file's ReadAt can be. Read and Seek share a position,
+// so can't be used concurrently.
+type PatchedFile struct {
+	basisFile io.ReaderAt
+	index     *deltaIndex
+	position  int64
+}
+
+var _ io.ReaderAt = (*PatchedFile)(nil)
+var _ io.ReadSeeker = (*PatchedFile)(nil)
+
+// NewPatchedFile reads all of `deltaReader`, and returns the new file it produces from `basisFile`
+func NewPatchedFile(basisFile io.ReaderAt, deltaReader DeltaReader) (*PatchedFile, error) {
+	delta, err := ReadDelta(deltaReader)
+	if err != nil {
+		return nil, err
+	}
+	return NewPatchedFileFromDelta(basisFile, delta), nil
+}
+
+// NewPatchedFileFromDelta returns the new file that `delta` produces from `basisFile`
+func NewPatchedFileFromDelta(basisFile io.ReaderAt, delta *Delta) *PatchedFile {
+	return &PatchedFile{
+		basisFile: basisFile,
+		index:     newDeltaIndex(delta.Commands),
+	}
+}
+
+// Size returns the length of the new file
+func (f *PatchedFile) Size() int64 {
+	return f.index.length
+}
+
+func (f *PatchedFile) ReadAt(p []byte, offset int64) (int, error) {
+	if offset < 0 {
+		return 0, errors.New("PatchedFile.ReadAt: negative offset")
+	}
+	if offset >= f.index.length {
+		return 0, io.EOF
+	}
+
+	length := int64(len(p))
+	var err error
+	if length > f.index.length-offset {
+		length = f.index.length - offset
+		err = io.EOF // as io.ReaderAt requires when we can't fill p
+	}
+
+	n := 0
+	visitErr := f.index.visit(offset, length,
+		func(data []byte) error {
+			n += copy(p[n:], data)
+			return nil
+		},
+		func(basisOffset int64, basisLength int64) error {
+			read, err := f.basisFile.ReadAt(p[n:n+int(basisLength)], basisOffset)
+			n += read
+			if int64(read) < basisLength {
+				if err == nil || err == io.EOF {
+					err = io.ErrUnexpectedEOF // the basis file is shorter than the delta expects
+				}
+				return err
+			}
+			return nil
+		})
+	if visitErr != nil {
+		return n, visitErr
+	}
+	return n, err
+}
+
+func (f *PatchedFile) Read(p []byte) (int, error) {
+	n, err := f.ReadAt(p, f.position)
+	f.position += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil // Read reports EOF on the next call instead
+	}
+	return n, err
+}
+
+func (f *PatchedFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.position
+	case io.SeekEnd:
+		offset += f.index.length
+	default:
+		return 0, errors.New("PatchedFile.Seek: invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("PatchedFile.Seek: negative position")
+	}
+	f.position = offset
+	return offset, nil
+}
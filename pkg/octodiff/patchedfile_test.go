@@ -0,0 +1,67 @@
+package octodiff_test
+
+import (
+	"bytes"
+	"github.com/OctopusDeploy/go-octodiff/pkg/octodiff"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"testing"
+	"testing/iotest"
+)
+
+func TestPatchedFileReadAt(t *testing.T) {
+	original, newFile := largeFileWithDisjointChanges()
+	deltaFile := buildDelta(newFile, buildSignature(original))
+
+	patched, err := octodiff.NewPatchedFile(bytes.NewReader(original), octodiff.NewBinaryDeltaReader(bytes.NewReader(deltaFile)))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(len(newFile)), patched.Size())
+
+	// ranges within one command, spanning several, and at each end
+	for _, r := range [][2]int{{0, 10}, {30, 40}, {2000, 5000}, {31000, 36000}, {len(newFile) - 100, len(newFile)}, {0, len(newFile)}} {
+		p := make([]byte, r[1]-r[0])
+		n, err := patched.ReadAt(p, int64(r[0]))
+		assert.Nil(t, err)
+		assert.Equal(t, len(p), n)
+		assert.Equal(t, newFile[r[0]:r[1]], p)
+	}
+
+	// reading past the end gives what there is, and io.EOF
+	p := make([]byte, 100)
+	n, err := patched.ReadAt(p, int64(len(newFile)-10))
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, newFile[len(newFile)-10:], p[:n])
+
+	n, err = patched.ReadAt(p, int64(len(newFile)))
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, 0, n)
+}
+
+func TestPatchedFileReadSeeker(t *testing.T) {
+	original, newFile := largeFileWithDisjointChanges()
+	deltaFile := buildDelta(newFile, buildSignature(original))
+
+	patched, err := octodiff.NewPatchedFile(bytes.NewReader(original), octodiff.NewBinaryDeltaReader(bytes.NewReader(deltaFile)))
+	assert.Nil(t, err)
+
+	// checks Read, Seek and ReadAt against the expected content
+	assert.Nil(t, iotest.TestReader(patched, newFile))
+
+	position, err := patched.Seek(-500, io.SeekEnd)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(len(newFile)-500), position)
+	rest, err := io.ReadAll(patched)
+	assert.Nil(t, err)
+	assert.Equal(t, newFile[len(newFile)-500:], rest)
+}
+
+func TestPatchedFileWithShortBasisFile(t *testing.T) {
+	original, newFile := largeFileWithDisjointChanges()
+	deltaFile := buildDelta(newFile, buildSignature(original))
+
+	patched, err := octodiff.NewPatchedFile(bytes.NewReader(original[:1000]), octodiff.NewBinaryDeltaReader(bytes.NewReader(deltaFile)))
+	assert.Nil(t, err)
+
+	_, err = patched.ReadAt(make([]byte, 100), 50000)
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+}
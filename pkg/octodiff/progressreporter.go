@@ -1,6 +1,12 @@
 package octodiff
 
-import "fmt"
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
 
 type ProgressReporter interface {
 	ReportProgress(operation string, currentPosition int64, total int64)
@@ -29,13 +35,18 @@ type stdoutProgressReporter struct {
 }
 
 func (s *stdoutProgressReporter) ReportProgress(operation string, currentPosition int64, total int64) {
+	if total <= 0 {
+		return // the total isn't known (such as when reading from a pipe), so there's no percentage to report
+	}
 	percent := int(float64(currentPosition)/float64(total)*100.0 + 0.5)
 	if s.CurrentOperation != operation {
 		s.ProgressPercentage = -1
 		s.CurrentOperation = operation
 	}
 
-	if s.ProgressPercentage != percent && percent%10 == 0 {
+	// progress can be reported in large steps, so print each 10% we've reached rather than only exact multiples of 10
+	percent = percent / 10 * 10
+	if s.ProgressPercentage != percent {
 		s.ProgressPercentage = percent
 		fmt.Printf("%v: %d%%\n", s.CurrentOperation, percent)
 	}
@@ -44,3 +55,240 @@ func (s *stdoutProgressReporter) ReportProgress(operation string, currentPositio
 func NewStdoutProgressReporter() ProgressReporter {
 	return &stdoutProgressReporter{}
 }
+
+// ----------------------------------------------------------------------------
+
+// DefaultProgressInterval is how often the terminal and JSON reporters are updated by the octodiff command
+const DefaultProgressInterval = 200 * time.Millisecond
+
+type throttledProgressReporter struct {
+	inner    ProgressReporter
+	interval time.Duration
+	now      func() time.Time
+
+	operation  string
+	lastReport time.Time
+}
+
+// NewThrottledProgressReporter passes progress on to `inner` at most once every `interval`, apart from the start and
+// end of each operation, which are always passed on. Use it for reporters which are expensive to update.
+func NewThrottledProgressReporter(inner ProgressReporter, interval time.Duration) ProgressReporter {
+	return &throttledProgressReporter{inner: inner, interval: interval, now: time.Now}
+}
+
+func (t *throttledProgressReporter) ReportProgress(operation string, currentPosition int64, total int64) {
+	now := t.now()
+	finished := total > 0 && currentPosition >= total
+	if operation == t.operation && !finished && now.Sub(t.lastReport) < t.interval {
+		return
+	}
+	t.operation = operation
+	t.lastReport = now
+	t.inner.ReportProgress(operation, currentPosition, total)
+}
+
+// ----------------------------------------------------------------------------
+
+// ProgressEvent is a progress report, along with how fast the operation is going and how long it's likely to take
+type ProgressEvent struct {
+	Operation string
+	Position  int64
+	Total     int64         // zero or less if it isn't known
+	Elapsed   time.Duration // since the operation started
+	Rate      float64       // how far the position moves per second; usually bytes, but chunks for "Creating chunk map"
+	Remaining time.Duration // estimated from the rate so far, or -1 if it can't be
+}
+
+// Percent returns how far through the operation we are, or -1 if the total isn't known
+func (e *ProgressEvent) Percent() float64 {
+	if e.Total <= 0 {
+		return -1
+	}
+	return float64(e.Position) / float64(e.Total) * 100
+}
+
+// progressTracker works out the rate and remaining time of each operation from its reports
+type progressTracker struct {
+	now func() time.Time
+
+	operation     string
+	started       time.Time
+	startPosition int64
+	lastPosition  int64
+}
+
+func (t *progressTracker) track(operation string, currentPosition int64, total int64) ProgressEvent {
+	now := t.now()
+	if operation != t.operation || currentPosition < t.lastPosition {
+		// a new operation, or the same one starting again on another file
+		t.operation = operation
+		t.started = now
+		t.startPosition = currentPosition
+	}
+	t.lastPosition = currentPosition
+
+	event := ProgressEvent{Operation: operation, Position: currentPosition, Total: total, Elapsed: now.Sub(t.started), Remaining: -1}
+	if seconds := event.Elapsed.Seconds(); seconds > 0 {
+		event.Rate = float64(currentPosition-t.startPosition) / seconds
+	}
+	if total > 0 && event.Rate > 0 {
+		event.Remaining = time.Duration(float64(total-currentPosition) / event.Rate * float64(time.Second))
+	}
+	return event
+}
+
+type progressEventReporter struct {
+	tracker progressTracker
+	report  func(ProgressEvent)
+}
+
+// NewProgressEventReporter calls `report` with a ProgressEvent for every progress report.
+// Wrap it in NewThrottledProgressReporter if `report` shouldn't be called so often.
+func NewProgressEventReporter(report func(ProgressEvent)) ProgressReporter {
+	return &progressEventReporter{tracker: progressTracker{now: time.Now}, report: report}
+}
+
+func (r *progressEventReporter) ReportProgress(operation string, currentPosition int64, total int64) {
+	r.report(r.tracker.track(operation, currentPosition, total))
+}
+
+// ----------------------------------------------------------------------------
+
+// jsonProgressEvent is how ProgressEvents are written by NewJSONProgressReporter. Anything which isn't known is null
+type jsonProgressEvent struct {
+	Operation        string   `json:"operation"`
+	Position         int64    `json:"position"`
+	Total            *int64   `json:"total"`
+	Percent          *float64 `json:"percent"`
+	ElapsedSeconds   float64  `json:"elapsedSeconds"`
+	RatePerSecond    float64  `json:"ratePerSecond"`
+	RemainingSeconds *float64 `json:"remainingSeconds"`
+}
+
+// NewJSONProgressReporter writes each progress report to `output` as a line of JSON, such as
+//
+//	{"operation":"Building delta","position":4194304,"total":8388608,"percent":50,"elapsedSeconds":0.5,"ratePerSecond":8388608,"remainingSeconds":0.5}
+//
+// Write errors are ignored, as progress is only informational.
+func NewJSONProgressReporter(output io.Writer) ProgressReporter {
+	encoder := json.NewEncoder(output)
+	return NewProgressEventReporter(func(event ProgressEvent) {
+		_ = encoder.Encode(newJSONProgressEvent(event))
+	})
+}
+
+func newJSONProgressEvent(event ProgressEvent) jsonProgressEvent {
+	result := jsonProgressEvent{
+		Operation:      event.Operation,
+		Position:       event.Position,
+		ElapsedSeconds: event.Elapsed.Seconds(),
+		RatePerSecond:  event.Rate,
+	}
+	if event.Total > 0 {
+		total := event.Total
+		percent := event.Percent()
+		result.Total = &total
+		result.Percent = &percent
+	}
+	if event.Remaining >= 0 {
+		remaining := event.Remaining.Seconds()
+		result.RemainingSeconds = &remaining
+	}
+	return result
+}
+
+// ----------------------------------------------------------------------------
+
+// terminalProgressBarWidth is the number of characters inside the brackets of the progress bar
+const terminalProgressBarWidth = 30
+
+type terminalProgressReporter struct {
+	output  io.Writer
+	tracker progressTracker
+
+	operation  string // the operation being drawn on the current line, if any
+	finished   bool   // whether the current line is complete
+	lineLength int
+}
+
+// NewTerminalProgressReporter draws a progress bar for each operation on `output`, redrawing it in place on the
+// same line, such as
+//
+//	Building delta        [===============>              ]  50%  ETA 3s
+//
+// Call the returned func once everything's done, to end the last line. Write errors are ignored.
+func NewTerminalProgressReporter(output io.Writer) (ProgressReporter, func()) {
+	reporter := &terminalProgressReporter{output: output, tracker: progressTracker{now: time.Now}}
+	return reporter, reporter.finish
+}
+
+func (r *terminalProgressReporter) ReportProgress(operation string, currentPosition int64, total int64) {
+	event := r.tracker.track(operation, currentPosition, total)
+	if operation != r.operation {
+		r.finish()
+		r.operation = operation
+		r.finished = false
+	}
+	if r.finished {
+		return // the bar is already full, and the line ended
+	}
+
+	var line strings.Builder
+	fmt.Fprintf(&line, "%-20s ", operation)
+	if total > 0 {
+		filled := int(event.Percent() / 100 * terminalProgressBarWidth)
+		if filled > terminalProgressBarWidth {
+			filled = terminalProgressBarWidth
+		}
+		bar := strings.Repeat("=", filled)
+		if filled < terminalProgressBarWidth {
+			bar += ">" + strings.Repeat(" ", terminalProgressBarWidth-filled-1)
+		}
+		fmt.Fprintf(&line, "[%s] %3.0f%%", bar, event.Percent())
+		if event.Remaining >= 0 && currentPosition < total {
+			fmt.Fprintf(&line, "  ETA %s", event.Remaining.Round(time.Second))
+		}
+	} else {
+		fmt.Fprintf(&line, "%s", formatBytes(currentPosition))
+	}
+	if event.Rate > 0 && operation != "Creating chunk map" {
+		fmt.Fprintf(&line, "  %s/s", formatBytes(int64(event.Rate)))
+	}
+
+	// pad with spaces to cover anything left over from the last time the line was drawn
+	text := line.String()
+	padding := r.lineLength - len(text)
+	r.lineLength = len(text)
+	if padding > 0 {
+		text += strings.Repeat(" ", padding)
+	}
+	_, _ = fmt.Fprintf(r.output, "\r%s", text)
+
+	if total > 0 && currentPosition >= total {
+		r.finish()
+	}
+}
+
+// finish ends the current line, if there is one
+func (r *terminalProgressReporter) finish() {
+	if r.operation != "" && !r.finished {
+		_, _ = fmt.Fprintln(r.output)
+		r.finished = true
+		r.lineLength = 0
+	}
+}
+
+// formatBytes formats a number of bytes for people to read, such as "12.3 MB"
+func formatBytes(bytes int64) string {
+	if bytes < 1024 {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	value := float64(bytes) / 1024
+	for _, suffix := range []string{"KB", "MB", "GB"} {
+		if value < 1024 {
+			return fmt.Sprintf("%.1f %s", value, suffix)
+		}
+		value /= 1024
+	}
+	return fmt.Sprintf("%.1f TB", value)
+}
@@ -0,0 +1,143 @@
+package octodiff
+
+import (
+	"bytes"
+	"github.com/OctopusDeploy/go-octodiff/pkg/test"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+type progressReport struct {
+	operation string
+	position  int64
+	total     int64
+}
+
+type recordingProgressReporter struct {
+	reports []progressReport
+}
+
+func (r *recordingProgressReporter) ReportProgress(operation string, currentPosition int64, total int64) {
+	r.reports = append(r.reports, progressReport{operation, currentPosition, total})
+}
+
+// fakeClock returns a func for the `now` fields of the progress reporters, and a func to move it on
+func fakeClock() (func() time.Time, func(time.Duration)) {
+	now := time.Date(2023, 3, 1, 0, 0, 0, 0, time.UTC)
+	return func() time.Time { return now }, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestThrottledProgressReporter(t *testing.T) {
+	recorder := &recordingProgressReporter{}
+	reporter := NewThrottledProgressReporter(recorder, time.Second).(*throttledProgressReporter)
+	now, advance := fakeClock()
+	reporter.now = now
+
+	reporter.ReportProgress("Building delta", 0, 100) // the start of an operation
+	advance(500 * time.Millisecond)
+	reporter.ReportProgress("Building delta", 10, 100)
+	advance(500 * time.Millisecond)
+	reporter.ReportProgress("Building delta", 20, 100) // a second since the last one
+	reporter.ReportProgress("Building delta", 30, 100)
+	reporter.ReportProgress("Building delta", 100, 100) // the end of an operation
+	reporter.ReportProgress("Creating chunk map", 0, 5) // another operation
+
+	assert.Equal(t, []progressReport{
+		{"Building delta", 0, 100},
+		{"Building delta", 20, 100},
+		{"Building delta", 100, 100},
+		{"Creating chunk map", 0, 5},
+	}, recorder.reports)
+}
+
+func TestProgressEvents(t *testing.T) {
+	var events []ProgressEvent
+	reporter := NewProgressEventReporter(func(event ProgressEvent) { events = append(events, event) }).(*progressEventReporter)
+	now, advance := fakeClock()
+	reporter.tracker.now = now
+
+	reporter.ReportProgress("Building delta", 0, 1000)
+	advance(2 * time.Second)
+	reporter.ReportProgress("Building delta", 250, 1000)
+	advance(time.Second)
+	reporter.ReportProgress("Reading signature", 10, -1)
+
+	assert.Equal(t, []ProgressEvent{
+		{Operation: "Building delta", Position: 0, Total: 1000, Elapsed: 0, Rate: 0, Remaining: -1},
+		{Operation: "Building delta", Position: 250, Total: 1000, Elapsed: 2 * time.Second, Rate: 125, Remaining: 6 * time.Second},
+		{Operation: "Reading signature", Position: 10, Total: -1, Elapsed: 0, Rate: 0, Remaining: -1},
+	}, events)
+	assert.Equal(t, 25.0, events[1].Percent())
+	assert.Equal(t, -1.0, events[2].Percent())
+}
+
+func TestJSONProgressReporter(t *testing.T) {
+	var output bytes.Buffer
+	reporter := NewJSONProgressReporter(&output).(*progressEventReporter)
+	now, advance := fakeClock()
+	reporter.tracker.now = now
+
+	reporter.ReportProgress("Building delta", 0, 1000)
+	advance(2 * time.Second)
+	reporter.ReportProgress("Building delta", 500, 1000)
+	reporter.ReportProgress("Reading signature", 10, -1)
+
+	assert.Equal(t, `{"operation":"Building delta","position":0,"total":1000,"percent":0,"elapsedSeconds":0,"ratePerSecond":0,"remainingSeconds":null}
+{"operation":"Building delta","position":500,"total":1000,"percent":50,"elapsedSeconds":2,"ratePerSecond":250,"remainingSeconds":2}
+{"operation":"Reading signature","position":10,"total":null,"percent":null,"elapsedSeconds":0,"ratePerSecond":0,"remainingSeconds":null}
+`, output.String())
+}
+
+func TestTerminalProgressReporter(t *testing.T) {
+	var output bytes.Buffer
+	r, finish := NewTerminalProgressReporter(&output)
+	reporter := r.(*terminalProgressReporter)
+	now, advance := fakeClock()
+	reporter.tracker.now = now
+
+	reporter.ReportProgress("Building delta", 0, 4096)
+	advance(time.Second)
+	reporter.ReportProgress("Building delta", 2048, 4096)
+	advance(time.Second)
+	reporter.ReportProgress("Building delta", 4096, 4096)
+	reporter.ReportProgress("Building delta", 4096, 4096) // already finished
+	reporter.ReportProgress("Reading signature", 3*1024*1024, -1)
+	finish()
+
+	assert.Equal(t, ""+
+		"\rBuilding delta       [>                             ]   0%"+
+		"\rBuilding delta       [===============>              ]  50%  ETA 1s  2.0 KB/s"+
+		"\rBuilding delta       [==============================] 100%  2.0 KB/s        \n"+
+		"\rReading signature    3.0 MB\n", output.String())
+}
+
+func TestFormatBytes(t *testing.T) {
+	assert.Equal(t, "0 B", formatBytes(0))
+	assert.Equal(t, "1023 B", formatBytes(1023))
+	assert.Equal(t, "1.5 KB", formatBytes(1536))
+	assert.Equal(t, "12.3 MB", formatBytes(12900000))
+	assert.Equal(t, "2048.0 TB", formatBytes(2<<50))
+}
+
+func TestDeltaBuilderReportsProgressPerBuffer(t *testing.T) {
+	newFile := test.GenerateTestData(3*defaultReadBufferSize + 100)
+	var signature bytes.Buffer
+	err := NewSignatureBuilder().Build(bytes.NewReader(newFile), int64(len(newFile)), &signature)
+	assert.Nil(t, err)
+
+	recorder := &recordingProgressReporter{}
+	builder := NewDeltaBuilder()
+	builder.ProgressReporter = recorder
+	err = builder.Build(bytes.NewReader(newFile), int64(len(newFile)), bytes.NewReader(signature.Bytes()), int64(signature.Len()), NewBinaryDeltaWriter(&bytes.Buffer{}))
+	assert.Nil(t, err)
+
+	var deltaReports []progressReport
+	for _, report := range recorder.reports {
+		if report.operation == "Building delta" {
+			deltaReports = append(deltaReports, report)
+		}
+	}
+	assert.Less(t, len(deltaReports), 10)
+	assert.Equal(t, progressReport{"Building delta", int64(len(newFile)), int64(len(newFile))}, deltaReports[len(deltaReports)-1])
+}
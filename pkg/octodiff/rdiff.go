@@ -0,0 +1,197 @@
+package octodiff
+
+import (
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/md4"
+	"hash"
+	"io"
+)
+
+// Constants and algorithms for the signature and delta formats used by librsync and its rdiff tool
+
+const (
+	RdiffMd4SignatureMagic          = uint32(0x72730136) // "rs\x016"; MD4 strong sums with the rollsum weak sum
+	RdiffBlake2SignatureMagic       = uint32(0x72730137) // "rs\x017"; BLAKE2 strong sums with the rollsum weak sum
+	RdiffRabinKarpMd4SignatureMagic = uint32(0x72730146) // "rs\x01F"; MD4 strong sums with the RabinKarp weak sum
+	RdiffRabinKarpSignatureMagic    = uint32(0x72730147) // "rs\x01G"; BLAKE2 strong sums with the RabinKarp weak sum. The librsync default
+	RdiffDeltaMagic                 = uint32(0x72730236) // "rs\x026"
+)
+
+const (
+	RdiffDefaultBlockLength = 2048
+
+	rdiffMd4Length    = md4.Size
+	rdiffBlake2Length = blake2b.Size256
+)
+
+// delta command opcodes
+const (
+	rdiffOpEnd           = 0x00
+	rdiffOpLiteral1      = 0x01 // 0x01 to 0x40 are literals with the length in the opcode itself
+	rdiffOpLiteral64     = 0x40
+	rdiffOpLiteralN1     = 0x41 // 0x41 to 0x44 are literals followed by a 1, 2, 4 or 8 byte length
+	rdiffOpLiteralN8     = 0x44
+	rdiffOpCopyN1N1      = 0x45 // 0x45 to 0x54 are copies followed by a 1, 2, 4 or 8 byte offset and then a 1, 2, 4 or 8 byte length
+	rdiffOpCopyN8N8      = 0x54
+	rdiffCharOffset      = 31
+	rdiffRabinKarpMult   = uint32(0x08104225)
+	rdiffRabinKarpAdj    = uint32(0x08104224) // (mult - 1) * seed; used to remove the seed's contribution when rolling
+	rdiffRabinKarpSeed   = uint32(1)
+	rdiffRollsumName     = "RdiffRollsum"
+	rdiffRabinKarpName   = "RdiffRabinKarp"
+	rdiffStrongMd4Name   = "MD4"
+	rdiffStrongBlakeName = "BLAKE2"
+)
+
+// ----------------------------------------------------------------------------
+
+// RdiffRollsum is librsync's original weak checksum; an Adler32 variant which adds 31 to every byte
+type RdiffRollsum struct{}
+
+var _ RollingChecksum = (*RdiffRollsum)(nil)
+
+func (_ *RdiffRollsum) Name() string {
+	return rdiffRollsumName
+}
+
+func (_ *RdiffRollsum) Calculate(block []byte) uint32 {
+	s1 := uint32(0)
+	s2 := uint32(0)
+	for _, z := range block {
+		s1 += uint32(z)
+		s2 += s1
+	}
+	n := uint32(len(block))
+	s1 += n * rdiffCharOffset
+	s2 += ((n * (n + 1)) / 2) * rdiffCharOffset
+	return (s2&0xffff)<<16 | s1&0xffff
+}
+
+func (_ *RdiffRollsum) Rotate(checksum uint32, remove byte, add byte, chunkSize int) uint32 {
+	s1 := checksum & 0xffff
+	s2 := checksum >> 16
+
+	s1 += uint32(add) - uint32(remove)
+	s2 += s1 - uint32(chunkSize)*(uint32(remove)+rdiffCharOffset)
+
+	return (s2&0xffff)<<16 | s1&0xffff
+}
+
+// ----------------------------------------------------------------------------
+
+// RdiffRabinKarp is the polynomial rolling hash used by default since librsync 2.2
+type RdiffRabinKarp struct{}
+
+var _ RollingChecksum = (*RdiffRabinKarp)(nil)
+
+func (_ *RdiffRabinKarp) Name() string {
+	return rdiffRabinKarpName
+}
+
+func (_ *RdiffRabinKarp) Calculate(block []byte) uint32 {
+	h := rdiffRabinKarpSeed
+	for _, z := range block {
+		h = h*rdiffRabinKarpMult + uint32(z)
+	}
+	return h
+}
+
+func (_ *RdiffRabinKarp) Rotate(checksum uint32, remove byte, add byte, chunkSize int) uint32 {
+	// mult^chunkSize, by repeated squaring
+	mult := uint32(1)
+	base := rdiffRabinKarpMult
+	for n := chunkSize; n > 0; n >>= 1 {
+		if n&1 != 0 {
+			mult *= base
+		}
+		base *= base
+	}
+	return checksum*rdiffRabinKarpMult + uint32(add) - mult*(uint32(remove)+rdiffRabinKarpAdj)
+}
+
+// ----------------------------------------------------------------------------
+
+// rdiffStrongSum is a HashAlgorithm producing librsync's strong sums, which may be truncated to save space
+type rdiffStrongSum struct {
+	name    string
+	newHash func() hash.Hash
+	length  int
+}
+
+var _ HashAlgorithm = (*rdiffStrongSum)(nil)
+
+func newRdiffStrongSum(magic uint32, length int) *rdiffStrongSum {
+	if magic == RdiffMd4SignatureMagic || magic == RdiffRabinKarpMd4SignatureMagic {
+		return &rdiffStrongSum{name: rdiffStrongMd4Name, newHash: md4.New, length: length}
+	}
+	return &rdiffStrongSum{
+		name: rdiffStrongBlakeName,
+		newHash: func() hash.Hash {
+			h, _ := blake2b.New256(nil) // only fails with an oversized key
+			return h
+		},
+		length: length,
+	}
+}
+
+func (s *rdiffStrongSum) Name() string {
+	return s.name
+}
+
+func (s *rdiffStrongSum) HashLength() int {
+	return s.length
+}
+
+func (s *rdiffStrongSum) HashOverData(data []byte) []byte {
+	h := s.newHash()
+	h.Write(data)
+	return h.Sum(nil)[:s.length]
+}
+
+func (s *rdiffStrongSum) HashOverReader(reader io.Reader) ([]byte, error) {
+	h := s.newHash()
+	iter := NewReaderIteratorSize(reader, 1024)
+	for iter.Next() {
+		h.Write(iter.Current)
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil)[:s.length], nil
+}
+
+func (s *rdiffStrongSum) streamingHash() hash.Hash {
+	return s.newHash()
+}
+
+// newFileHashAlgorithm returns the algorithm deltas built from `signature` use to hash the new file. That's the signature's
+// own hash algorithm, except for rdiff signatures: their strong sums are only for matching chunks, and readers of
+// octodiff and VCDIFF deltas only accept the default hash algorithm (rdiff deltas carry no hash at all).
+func newFileHashAlgorithm(signature *Signature) HashAlgorithm {
+	if _, ok := signature.HashAlgorithm.(*rdiffStrongSum); ok {
+		return DefaultHashAlgorithm
+	}
+	return signature.HashAlgorithm
+}
+
+func rdiffRollingChecksum(magic uint32) RollingChecksum {
+	if magic == RdiffRabinKarpSignatureMagic || magic == RdiffRabinKarpMd4SignatureMagic {
+		return &RdiffRabinKarp{}
+	}
+	return &RdiffRollsum{}
+}
+
+func isRdiffSignatureMagic(magic uint32) bool {
+	switch magic {
+	case RdiffMd4SignatureMagic, RdiffBlake2SignatureMagic, RdiffRabinKarpMd4SignatureMagic, RdiffRabinKarpSignatureMagic:
+		return true
+	}
+	return false
+}
+
+func rdiffMaxStrongSumLength(magic uint32) int {
+	if magic == RdiffMd4SignatureMagic || magic == RdiffRabinKarpMd4SignatureMagic {
+		return rdiffMd4Length
+	}
+	return rdiffBlake2Length
+}
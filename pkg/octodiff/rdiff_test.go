@@ -0,0 +1,149 @@
+package octodiff_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"github.com/OctopusDeploy/go-octodiff/pkg/octodiff"
+	"github.com/OctopusDeploy/go-octodiff/pkg/test"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestRdiffRollingChecksumsRotateConsistently(t *testing.T) {
+	data := test.GenerateTestData(4096)
+	const chunkSize = 700
+
+	for _, algorithm := range []octodiff.RollingChecksum{&octodiff.RdiffRollsum{}, &octodiff.RdiffRabinKarp{}} {
+		t.Run(algorithm.Name(), func(t *testing.T) {
+			checksum := algorithm.Calculate(data[0:chunkSize])
+			for i := 1; i+chunkSize <= len(data); i++ {
+				checksum = algorithm.Rotate(checksum, data[i-1], data[i+chunkSize-1], chunkSize)
+				if !assert.Equal(t, algorithm.Calculate(data[i:i+chunkSize]), checksum, "at offset %d", i) {
+					return
+				}
+			}
+		})
+	}
+}
+
+func TestRdiffRollsumKnownValues(t *testing.T) {
+	rollsum := &octodiff.RdiffRollsum{}
+	assert.Equal(t, uint32(0), rollsum.Calculate(nil))
+	// s1 = 'a' + 31 = 128, s2 = 128
+	assert.Equal(t, uint32(0x00800080), rollsum.Calculate([]byte("a")))
+}
+
+func buildRdiffSignature(input []byte, magic uint32) []byte {
+	var output bytes.Buffer
+	builder := octodiff.NewRdiffSignatureBuilder()
+	builder.Magic = magic
+	builder.BlockLength = 256
+	builder.StrongSumLength = 8
+	err := builder.Build(bytes.NewReader(input), int64(len(input)), &output)
+	if err != nil {
+		panic(err) // should never fail under tests
+	}
+	return output.Bytes()
+}
+
+func TestRdiffSignatureFormat(t *testing.T) {
+	signature := buildRdiffSignature(test.GenerateTestData(300), octodiff.RdiffMd4SignatureMagic)
+
+	// header, then two blocks of 4 byte weak sum and 8 byte truncated MD4
+	assert.Equal(t, "72730136"+"00000100"+"00000008", hex.EncodeToString(signature[:12]))
+	assert.Equal(t, 12+2*(4+8), len(signature))
+}
+
+func TestReadsRdiffSignature(t *testing.T) {
+	input := test.GenerateTestData(600)
+	for _, magic := range []uint32{octodiff.RdiffMd4SignatureMagic, octodiff.RdiffBlake2SignatureMagic, octodiff.RdiffRabinKarpMd4SignatureMagic, octodiff.RdiffRabinKarpSignatureMagic} {
+		signatureFile := buildRdiffSignature(input, magic)
+
+		signature, err := readSignature(signatureFile)
+		assert.Nil(t, err)
+		assert.Equal(t, 3, len(signature.Chunks))
+		assert.Equal(t, int64(512), signature.Chunks[2].StartOffset)
+		assert.Equal(t, uint16(256), signature.Chunks[2].Length)
+		assert.Equal(t, signature.RollingChecksumAlgorithm.Calculate(input[256:512]), signature.Chunks[1].RollingChecksum)
+		assert.Equal(t, signature.HashAlgorithm.HashOverData(input[256:512]), signature.Chunks[1].Hash)
+		assert.Equal(t, 8, len(signature.Chunks[1].Hash))
+	}
+}
+
+func TestRdiffDeltaRoundTrip(t *testing.T) {
+	original, newFile := largeFileWithDisjointChanges()
+	signature := buildRdiffSignature(original, octodiff.RdiffRabinKarpSignatureMagic)
+
+	var deltaFile bytes.Buffer
+	err := octodiff.NewDeltaBuilder().Build(bytes.NewReader(newFile), int64(len(newFile)), bytes.NewReader(signature), int64(len(signature)), octodiff.NewRdiffDeltaWriter(&deltaFile))
+	assert.Nil(t, err)
+	assert.Less(t, deltaFile.Len(), len(newFile)/4)
+
+	var output bytes.Buffer
+	reader := octodiff.NewRdiffDeltaReader(bytes.NewReader(deltaFile.Bytes()))
+	err = octodiff.ApplyDelta(bytes.NewReader(original), reader, &output)
+	assert.Nil(t, err)
+	assert.Equal(t, newFile, output.Bytes())
+
+	_, err = reader.ExpectedHash()
+	assert.True(t, errors.Is(err, octodiff.ErrNoExpectedHash))
+}
+
+// octodiff and VCDIFF deltas record a hash of the new file, which has to be one their readers accept
+// whatever the strong sums in the rdiff signature they were built from
+func TestOctodiffAndVcdiffDeltasFromRdiffSignatures(t *testing.T) {
+	original, newFile := largeFileWithDisjointChanges()
+	for _, magic := range []uint32{octodiff.RdiffMd4SignatureMagic, octodiff.RdiffRabinKarpSignatureMagic} {
+		signature := buildRdiffSignature(original, magic)
+
+		var binaryDelta bytes.Buffer
+		err := octodiff.NewDeltaBuilder().Build(bytes.NewReader(newFile), int64(len(newFile)), bytes.NewReader(signature), int64(len(signature)), octodiff.NewBinaryDeltaWriter(&binaryDelta))
+		assert.Nil(t, err)
+		output, err := applyAndVerify(original, octodiff.NewBinaryDeltaReader(bytes.NewReader(binaryDelta.Bytes())))
+		assert.Nil(t, err)
+		assert.Equal(t, newFile, output)
+
+		var vcdiffDelta bytes.Buffer
+		err = octodiff.NewDeltaBuilder().Build(bytes.NewReader(newFile), int64(len(newFile)), bytes.NewReader(signature), int64(len(signature)), octodiff.NewVcdiffDeltaWriter(&vcdiffDelta))
+		assert.Nil(t, err)
+		output, err = applyAndVerify(original, octodiff.NewVcdiffDeltaReader(bytes.NewReader(vcdiffDelta.Bytes())))
+		assert.Nil(t, err)
+		assert.Equal(t, newFile, output)
+
+		streamingDelta := buildStreamingDelta(bytes.NewReader(newFile), signature, 64*1024)
+		output, err = applyAndVerify(original, octodiff.NewBinaryDeltaReader(bytes.NewReader(streamingDelta)))
+		assert.Nil(t, err)
+		assert.Equal(t, newFile, output)
+	}
+}
+
+func TestRdiffDeltaWriterEncoding(t *testing.T) {
+	var output bytes.Buffer
+	writer := octodiff.NewRdiffDeltaWriter(&output)
+
+	assert.Nil(t, writer.WriteMetadata(octodiff.DefaultHashAlgorithm, nil))
+	assert.Nil(t, writer.WriteCopyCommand(0, 100))
+	assert.Nil(t, writer.WriteCopyCommand(100, 200)) // merged
+	assert.Nil(t, writer.WriteDataCommand(bytes.NewReader([]byte("abc")), 0, 3))
+	assert.Nil(t, writer.WriteDataCommand(bytes.NewReader(make([]byte, 65)), 0, 65))
+	assert.Nil(t, writer.WriteCopyCommand(70000, 5))
+	assert.Nil(t, writer.Flush())
+
+	expected := "72730236" +
+		"46" + "00" + "012c" + // copy, 1 byte offset, 2 byte length
+		"03" + "616263" + // 3 byte literal
+		"41" + "41" + hex.EncodeToString(make([]byte, 65)) + // literal with 1 byte length
+		"4d" + "00011170" + "05" + // copy, 4 byte offset, 1 byte length
+		"00"
+	assert.Equal(t, expected, hex.EncodeToString(output.Bytes()))
+}
+
+func TestRdiffDeltaReaderRequiresEndMarker(t *testing.T) {
+	input, _ := hex.DecodeString("72730236" + "4500ff")
+	reader := octodiff.NewRdiffDeltaReader(bytes.NewReader(input))
+
+	err := reader.Apply(func([]byte) error { return nil }, func(int64, int64) error { return nil })
+	assertFormatError(t, err, "delta", 7, octodiff.ErrTruncatedChunk)
+	assert.EqualError(t, err, "the rdiff delta appears to be truncated; it has no end marker (at offset 7)")
+}
@@ -0,0 +1,140 @@
+package octodiff
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// RdiffDeltaReader reads deltas in librsync's format, as produced by `rdiff delta`.
+// These deltas don't contain a hash of the new file, so ExpectedHash and HashAlgorithm return ErrNoExpectedHash.
+type RdiffDeltaReader struct {
+	input           *countingReader
+	hasReadMetadata bool
+
+	ProgressReporter ProgressReporter
+	// DeltaLength is the size of the delta, which progress through it is reported against; -1 or zero if it isn't known
+	DeltaLength int64
+}
+
+var _ DeltaReader = (*RdiffDeltaReader)(nil)
+
+func NewRdiffDeltaReader(input io.Reader) *RdiffDeltaReader {
+	return &RdiffDeltaReader{
+		input:            &countingReader{reader: input},
+		ProgressReporter: NopProgressReporter(),
+	}
+}
+
+func (r *RdiffDeltaReader) ExpectedHash() ([]byte, error) {
+	err := r.ensureMetadata()
+	if err != nil {
+		return nil, err
+	}
+	return nil, ErrNoExpectedHash
+}
+
+func (r *RdiffDeltaReader) HashAlgorithm() (HashAlgorithm, error) {
+	err := r.ensureMetadata()
+	if err != nil {
+		return nil, err
+	}
+	return nil, ErrNoExpectedHash
+}
+
+func (r *RdiffDeltaReader) ensureMetadata() error {
+	if r.hasReadMetadata {
+		return nil
+	}
+	var magic uint32
+	err := binary.Read(r.input, binary.BigEndian, &magic)
+	if err != nil {
+		return truncatedOrError(err, "delta", r.input.offset)
+	}
+	if magic != RdiffDeltaMagic {
+		return newFormatError("delta", 0, ErrCorruptHeader, "the delta file is not an rdiff delta")
+	}
+	r.hasReadMetadata = true
+	return nil
+}
+
+func (r *RdiffDeltaReader) Apply(writeData func([]byte) error, copyData func(int64, int64) error) error {
+	err := r.ensureMetadata()
+	if err != nil {
+		return err
+	}
+
+	buffer := make([]byte, defaultReadBufferSize)
+	opcode := make([]byte, 1)
+	for {
+		r.ProgressReporter.ReportProgress("Applying delta", r.input.offset, r.DeltaLength)
+		commandOffset := r.input.offset
+		_, err = io.ReadFull(r.input, opcode)
+		if err == io.EOF {
+			return newFormatError("delta", commandOffset, ErrTruncatedChunk, "the rdiff delta appears to be truncated; it has no end marker")
+		}
+		if err != nil {
+			return err
+		}
+
+		op := int(opcode[0])
+		switch {
+		case op == rdiffOpEnd:
+			r.ProgressReporter.ReportProgress("Applying delta", r.input.offset, r.DeltaLength)
+			return nil
+		case op >= rdiffOpLiteral1 && op <= rdiffOpLiteralN8:
+			length := int64(op - rdiffOpLiteral1 + 1)
+			if op >= rdiffOpLiteralN1 {
+				length, err = r.readInt(op - rdiffOpLiteralN1)
+				if err != nil {
+					return err
+				}
+			}
+			if length <= 0 {
+				return newFormatError("delta", commandOffset, ErrCorruptCommand, "the rdiff delta appears to be corrupt; literal has an invalid length")
+			}
+			iter := NewReaderIteratorBufferNBytes(r.input, buffer, length)
+			for iter.Next() {
+				err = writeData(iter.Current)
+				if err != nil {
+					return err
+				}
+			}
+			err = iter.Err()
+			if err != nil {
+				return truncatedOrError(err, "delta", r.input.offset)
+			}
+		case op >= rdiffOpCopyN1N1 && op <= rdiffOpCopyN8N8:
+			offset, err := r.readInt((op - rdiffOpCopyN1N1) / 4)
+			if err != nil {
+				return err
+			}
+			length, err := r.readInt((op - rdiffOpCopyN1N1) % 4)
+			if err != nil {
+				return err
+			}
+			if offset < 0 || length <= 0 {
+				return newFormatError("delta", commandOffset, ErrCorruptCommand, "the rdiff delta appears to be corrupt; copy has an invalid offset or length")
+			}
+			err = copyData(offset, length)
+			if err != nil {
+				return err
+			}
+		default:
+			return newFormatError("delta", commandOffset, ErrCorruptCommand, "unexpected command byte 0x%02x in rdiff delta", op)
+		}
+	}
+}
+
+// readInt reads a big-endian integer of 1, 2, 4 or 8 bytes, for width 0, 1, 2 or 3
+func (r *RdiffDeltaReader) readInt(width int) (int64, error) {
+	b := make([]byte, 1<<width)
+	_, err := io.ReadFull(r.input, b)
+	if err != nil {
+		return 0, truncatedOrError(err, "delta", r.input.offset)
+	}
+	value := int64(0)
+	for _, x := range b {
+		value = value<<8 | int64(x)
+	}
+	return value, nil
+}
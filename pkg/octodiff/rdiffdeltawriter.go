@@ -0,0 +1,123 @@
+package octodiff
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// RdiffDeltaWriter writes deltas in librsync's format, which can be applied with `rdiff patch`.
+// The format has no place for the hash of the new file, so it is discarded, and the delta cannot be verified when applied.
+// Flush writes the end-of-delta marker, so must only be called once, after the last command.
+type RdiffDeltaWriter struct {
+	Output             io.Writer
+	bufferedCopyOffset int64
+	bufferedCopyLength int64
+
+	buffer []byte // for reading data commands from the new file; allocated on first use
+}
+
+var _ DeltaWriter = (*RdiffDeltaWriter)(nil)
+
+func NewRdiffDeltaWriter(output io.Writer) *RdiffDeltaWriter {
+	return &RdiffDeltaWriter{
+		Output: output,
+	}
+}
+
+func (w *RdiffDeltaWriter) WriteMetadata(hashAlgorithm HashAlgorithm, expectedNewFileHash []byte) error {
+	return binary.Write(w.Output, binary.BigEndian, RdiffDeltaMagic)
+}
+
+// WriteCopyCommand buffers the copy, merging it with the previous one if they are sequential
+func (w *RdiffDeltaWriter) WriteCopyCommand(offset int64, length int64) error {
+	if w.bufferedCopyLength != 0 && w.bufferedCopyOffset+w.bufferedCopyLength == offset {
+		w.bufferedCopyLength += length
+		return nil
+	}
+	err := w.flushCopy()
+	w.bufferedCopyOffset = offset
+	w.bufferedCopyLength = length
+	return err
+}
+
+func (w *RdiffDeltaWriter) WriteDataCommand(source io.ReadSeeker, offset int64, length int64) error {
+	err := w.flushCopy()
+	if err != nil {
+		return err
+	}
+	if length == 0 {
+		return nil
+	}
+
+	var header []byte
+	if length <= rdiffOpLiteral64 {
+		header = []byte{byte(rdiffOpLiteral1 - 1 + length)}
+	} else {
+		width := rdiffIntWidth(length)
+		header = appendRdiffInt([]byte{byte(rdiffOpLiteralN1 + width)}, length, width)
+	}
+	_, err = w.Output.Write(header)
+	if err != nil {
+		return err
+	}
+
+	return readSourceRange(source, offset, length, w.dataBuffer(), func(data []byte) error {
+		_, err := w.Output.Write(data)
+		return err
+	})
+}
+
+// Flush writes any buffered copy, followed by the end-of-delta marker
+func (w *RdiffDeltaWriter) Flush() error {
+	err := w.flushCopy()
+	if err != nil {
+		return err
+	}
+	_, err = w.Output.Write([]byte{rdiffOpEnd})
+	return err
+}
+
+func (w *RdiffDeltaWriter) flushCopy() error {
+	if w.bufferedCopyLength == 0 {
+		return nil
+	}
+	offsetWidth := rdiffIntWidth(w.bufferedCopyOffset)
+	lengthWidth := rdiffIntWidth(w.bufferedCopyLength)
+	command := []byte{byte(rdiffOpCopyN1N1 + offsetWidth*4 + lengthWidth)}
+	command = appendRdiffInt(command, w.bufferedCopyOffset, offsetWidth)
+	command = appendRdiffInt(command, w.bufferedCopyLength, lengthWidth)
+
+	w.bufferedCopyOffset = 0
+	w.bufferedCopyLength = 0
+	_, err := w.Output.Write(command)
+	return err
+}
+
+// rdiffIntWidth returns 0, 1, 2 or 3 for values needing 1, 2, 4 or 8 bytes
+func rdiffIntWidth(value int64) int {
+	switch {
+	case value <= 0xff:
+		return 0
+	case value <= 0xffff:
+		return 1
+	case value <= 0xffffffff:
+		return 2
+	default:
+		return 3
+	}
+}
+
+func appendRdiffInt(buffer []byte, value int64, width int) []byte {
+	size := 1 << width
+	for i := size - 1; i >= 0; i-- {
+		buffer = append(buffer, byte(value>>(8*i)))
+	}
+	return buffer
+}
+
+func (w *RdiffDeltaWriter) dataBuffer() []byte {
+	if w.buffer == nil {
+		w.buffer = make([]byte, defaultDataCommandBufferSize)
+	}
+	return w.buffer
+}
@@ -0,0 +1,133 @@
+package octodiff
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// RdiffSignatureBuilder creates signatures in librsync's format, as produced by `rdiff signature`
+type RdiffSignatureBuilder struct {
+	Magic            uint32 // one of the Rdiff...SignatureMagic values, selecting the weak and strong sums
+	BlockLength      int
+	StrongSumLength  int              // strong sums are truncated to this many bytes; zero means the full length
+	ProgressReporter ProgressReporter // must be non-null
+}
+
+func NewRdiffSignatureBuilder() *RdiffSignatureBuilder {
+	return &RdiffSignatureBuilder{
+		Magic:            RdiffRabinKarpSignatureMagic,
+		BlockLength:      RdiffDefaultBlockLength,
+		ProgressReporter: NopProgressReporter(),
+	}
+}
+
+func (s *RdiffSignatureBuilder) Build(input io.Reader, inputLength int64, output io.Writer) error {
+	if !isRdiffSignatureMagic(s.Magic) {
+		return fmt.Errorf("unknown rdiff signature magic %#x", s.Magic)
+	}
+	if s.BlockLength < 1 || s.BlockLength > math.MaxUint16 {
+		return errors.New("RdiffSignatureBuilder BlockLength is out of range")
+	}
+	strongSumLength := s.StrongSumLength
+	if strongSumLength == 0 {
+		strongSumLength = rdiffMaxStrongSumLength(s.Magic)
+	}
+	if strongSumLength < 1 || strongSumLength > rdiffMaxStrongSumLength(s.Magic) {
+		return errors.New("RdiffSignatureBuilder StrongSumLength is out of range")
+	}
+
+	header := make([]byte, 12)
+	binary.BigEndian.PutUint32(header[0:], s.Magic)
+	binary.BigEndian.PutUint32(header[4:], uint32(s.BlockLength))
+	binary.BigEndian.PutUint32(header[8:], uint32(strongSumLength))
+	_, err := output.Write(header)
+	if err != nil {
+		return err
+	}
+
+	checksumAlgorithm := rdiffRollingChecksum(s.Magic)
+	hashAlgorithm := newRdiffStrongSum(s.Magic, strongSumLength)
+
+	s.ProgressReporter.ReportProgress("Building signatures", 0, inputLength)
+
+	start := int64(0)
+	weakSum := make([]byte, 4)
+	iter := NewReaderIteratorSize(input, s.BlockLength)
+	for iter.Next() {
+		binary.BigEndian.PutUint32(weakSum, checksumAlgorithm.Calculate(iter.Current))
+		_, err = output.Write(weakSum)
+		if err != nil {
+			return err
+		}
+		_, err = output.Write(hashAlgorithm.HashOverData(iter.Current))
+		if err != nil {
+			return err
+		}
+
+		start += int64(len(iter.Current))
+		s.ProgressReporter.ReportProgress("Building signatures", start, inputLength)
+	}
+	return iter.Err()
+}
+
+// readRdiffSignature reads the remainder of an rdiff signature, after the 4-byte `magic`.
+// rdiff signatures don't record the length of the last block, so all blocks are assumed to be full length;
+// this means the last block of the basis file will only match if it happens to be a full block.
+func readRdiffSignature(input io.Reader, magic uint32, remainingLength int64) (*Signature, error) {
+	header := make([]byte, 8)
+	_, err := io.ReadFull(input, header)
+	if err != nil {
+		return nil, truncatedOrError(err, "signature", 4)
+	}
+	blockLength := binary.BigEndian.Uint32(header[0:])
+	strongSumLength := binary.BigEndian.Uint32(header[4:])
+	if blockLength < 1 || blockLength > math.MaxUint16 {
+		return nil, newFormatError("signature", 4, ErrUnsupportedAlgorithm, "rdiff signature block length of %d is not supported", blockLength)
+	}
+	if strongSumLength < 1 || int(strongSumLength) > rdiffMaxStrongSumLength(magic) {
+		return nil, newFormatError("signature", 8, ErrCorruptHeader, "the signature file appears to be corrupt; strong sum length is out of range")
+	}
+
+	signatureSize := 4 + int(strongSumLength)
+	remainingLength -= int64(len(header))
+	if remainingLength < 0 || remainingLength%int64(signatureSize) != 0 {
+		return nil, newFormatError("signature", 12, ErrTruncatedChunk, "the signature file appears to be corrupt; at least one chunk has data missing")
+	}
+
+	expectedNumberOfChunks := remainingLength / int64(signatureSize)
+	if expectedNumberOfChunks > maxPreallocatedChunks {
+		expectedNumberOfChunks = maxPreallocatedChunks
+	}
+
+	chunks := make([]*ChunkSignature, 0, expectedNumberOfChunks)
+	chunkStart := int64(0)
+	block := make([]byte, signatureSize)
+	for {
+		blockBytesRead, err := io.ReadFull(input, block)
+		if err == io.EOF {
+			break // no more chunks
+		}
+		if err == io.ErrUnexpectedEOF {
+			return nil, newFormatError("signature", 12+int64(len(chunks)*signatureSize), ErrTruncatedChunk, "expecting to read %d bytes for ChunkSignature but only got %d", signatureSize, blockBytesRead)
+		}
+		if err != nil {
+			return nil, err
+		}
+		chunks = append(chunks, &ChunkSignature{
+			StartOffset:     chunkStart,
+			Length:          uint16(blockLength),
+			RollingChecksum: binary.BigEndian.Uint32(block),
+			Hash:            append([]byte(nil), block[4:]...),
+		})
+		chunkStart += int64(blockLength)
+	}
+
+	return &Signature{
+		HashAlgorithm:            newRdiffStrongSum(magic, int(strongSumLength)),
+		RollingChecksumAlgorithm: rdiffRollingChecksum(magic),
+		Chunks:                   chunks,
+	}, nil
+}
@@ -83,13 +83,14 @@ func NewReaderIteratorBuffer(reader io.Reader, buffer []byte) ReaderIterator {
 	return NewReaderIteratorBufferNBytes(reader, buffer, -1)
 }
 
-// NewReaderIteratorBufferNBytes creates an iterator that will stop after reading `nBytesToRead` bytes, referencing an already-allocated buffer
+// NewReaderIteratorBufferNBytes creates an iterator that will stop after reading `nBytesToRead` bytes, referencing an already-allocated buffer.
+// A negative `nBytesToRead` reads until EOF; zero reads nothing.
 func NewReaderIteratorBufferNBytes(reader io.Reader, buffer []byte, nBytesToRead int64) ReaderIterator {
 	return ReaderIterator{
 		reader:       reader,
 		buffer:       buffer,
 		nBytesToRead: nBytesToRead,
-		isCompleted:  false,
+		isCompleted:  nBytesToRead == 0,
 		err:          nil,
 		Current:      nil,
 	}
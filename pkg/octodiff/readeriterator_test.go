@@ -542,3 +542,12 @@ func TestReaderIterator_FailureWhileAlsoProvidingDataNBytes(t *testing.T) {
 
 	assert.True(t, reader.AllCallbacksConsumed())
 }
+
+func TestReaderIterator_ZeroNBytes(t *testing.T) {
+	reader := newMockReader() // reading at all would fail
+
+	iter := NewReaderIteratorSizeNBytes(reader, 5, 0)
+	assert.False(t, iter.Next())
+	assert.Nil(t, iter.Err())
+	assert.Empty(t, iter.Current)
+}
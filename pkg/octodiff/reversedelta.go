@@ -0,0 +1,144 @@
+package octodiff
+
+import (
+	"bufio"
+	"io"
+	"sort"
+)
+
+// BuildReverseDelta creates the inverse of `deltaReader`: a delta which turns the new file back into `basisFile`.
+// Only the positions of copy commands are needed from the delta, so data commands are not held in memory.
+// Any part of the basis file that the delta didn't copy is written into the reverse delta as data.
+func BuildReverseDelta(basisFile io.ReadSeeker, deltaReader DeltaReader, reverseDeltaWriter DeltaWriter) error {
+	recorder := &reverseDeltaRecorder{}
+	err := deltaReader.Apply(recorder.recordData, recorder.recordCopy)
+	if err != nil {
+		return err
+	}
+	hashAlgorithm, err := deltaReader.HashAlgorithm()
+	if err != nil {
+		return err
+	}
+	return recorder.writeReverseDelta(basisFile, hashAlgorithm, reverseDeltaWriter)
+}
+
+// ApplyDeltaAndReverse builds the new file exactly as ApplyDelta does, and then writes the reverse delta
+// (new file back to basis file) to `reverseDeltaWriter`, so a rollback artifact is produced as a by-product of patching.
+func ApplyDeltaAndReverse(basisFile io.ReadSeeker, deltaReader DeltaReader, output io.Writer, reverseDeltaWriter DeltaWriter) error {
+	recorder := &reverseDeltaRecorder{}
+	err := ApplyDelta(basisFile, &recordingDeltaReader{DeltaReader: deltaReader, recorder: recorder}, output)
+	if err != nil {
+		return err
+	}
+	hashAlgorithm, err := deltaReader.HashAlgorithm()
+	if err != nil {
+		return err
+	}
+	return recorder.writeReverseDelta(basisFile, hashAlgorithm, reverseDeltaWriter)
+}
+
+type reverseCopy struct {
+	basisOffset   int64
+	newFileOffset int64
+	length        int64
+}
+
+// reverseDeltaRecorder tracks where each copy command placed basis file data in the new file
+type reverseDeltaRecorder struct {
+	copies        []reverseCopy
+	newFileOffset int64
+}
+
+func (r *reverseDeltaRecorder) recordData(data []byte) error {
+	r.newFileOffset += int64(len(data))
+	return nil
+}
+
+func (r *reverseDeltaRecorder) recordCopy(offset int64, length int64) error {
+	if length > 0 && offset >= 0 {
+		r.copies = append(r.copies, reverseCopy{basisOffset: offset, newFileOffset: r.newFileOffset, length: length})
+	}
+	r.newFileOffset += length
+	return nil
+}
+
+func (r *reverseDeltaRecorder) writeReverseDelta(basisFile io.ReadSeeker, hashAlgorithm HashAlgorithm, output DeltaWriter) error {
+	basisFileLength, err := basisFile.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	_, err = basisFile.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	hash, err := hashAlgorithm.HashOverReader(bufio.NewReaderSize(basisFile, defaultReadBufferSize))
+	if err != nil {
+		return err
+	}
+	err = output.WriteMetadata(hashAlgorithm, hash)
+	if err != nil {
+		return err
+	}
+
+	copies := r.copies
+	sort.Slice(copies, func(i, j int) bool {
+		return copies[i].basisOffset < copies[j].basisOffset
+	})
+
+	// walk forward through the basis file. At each point, copy from whichever part of the new file
+	// covers the most basis data from here onwards; if nothing covers this point, write data up to the next copy.
+	pos := int64(0)
+	i := 0
+	for pos < basisFileLength {
+		var best *reverseCopy
+		bestEnd := pos
+		for ; i < len(copies) && copies[i].basisOffset <= pos; i++ {
+			if end := copies[i].basisOffset + copies[i].length; end > bestEnd {
+				best = &copies[i]
+				bestEnd = end
+			}
+		}
+		if bestEnd > basisFileLength {
+			bestEnd = basisFileLength
+		}
+
+		if best != nil {
+			err = output.WriteCopyCommand(best.newFileOffset+(pos-best.basisOffset), bestEnd-pos)
+			if err != nil {
+				return err
+			}
+			pos = bestEnd
+			continue
+		}
+
+		next := basisFileLength
+		if i < len(copies) && copies[i].basisOffset < next {
+			next = copies[i].basisOffset
+		}
+		err = output.WriteDataCommand(basisFile, pos, next-pos)
+		if err != nil {
+			return err
+		}
+		pos = next
+	}
+
+	return output.Flush()
+}
+
+// recordingDeltaReader passes commands through to the caller of Apply, recording the copy commands as they go past
+type recordingDeltaReader struct {
+	DeltaReader
+	recorder *reverseDeltaRecorder
+}
+
+func (r *recordingDeltaReader) Apply(writeData func([]byte) error, copyData func(int64, int64) error) error {
+	return r.DeltaReader.Apply(
+		func(data []byte) error {
+			_ = r.recorder.recordData(data)
+			return writeData(data)
+		},
+		func(offset int64, length int64) error {
+			_ = r.recorder.recordCopy(offset, length)
+			return copyData(offset, length)
+		})
+}
@@ -0,0 +1,63 @@
+package octodiff_test
+
+import (
+	"bytes"
+	"github.com/OctopusDeploy/go-octodiff/pkg/octodiff"
+	"github.com/stretchr/testify/assert"
+	"math/rand"
+	"testing"
+)
+
+func applyDeltaFile(basis []byte, deltaFile []byte) []byte {
+	var output bytes.Buffer
+	err := octodiff.ApplyDelta(bytes.NewReader(basis), octodiff.NewBinaryDeltaReader(bytes.NewReader(deltaFile)), &output)
+	if err != nil {
+		panic(err) // should never fail under tests
+	}
+	return output.Bytes()
+}
+
+func TestBuildReverseDelta(t *testing.T) {
+	// our usual test data repeats every 520 bytes, so copies would all point at the start of the basis file.
+	// Use random data so that each part of the basis is unique
+	a := make([]byte, 64*1024)
+	rand.New(rand.NewSource(1)).Read(a)
+	c := append([]byte{0x01, 0x02}, a[:40000]...) // c has a section removed relative to a, so the reverse needs data as well as copies
+	c = append(c, a[45000:]...)
+
+	deltaFile := buildDelta(c, buildSignatureWithChunkSize(a, octodiff.SignatureMinimumChunkSize))
+
+	var reverseDeltaFile bytes.Buffer
+	err := octodiff.BuildReverseDelta(bytes.NewReader(a), octodiff.NewBinaryDeltaReader(bytes.NewReader(deltaFile)), octodiff.NewBinaryDeltaWriter(&reverseDeltaFile))
+	assert.Nil(t, err)
+
+	rolledBack := applyDeltaFile(c, reverseDeltaFile.Bytes())
+	assert.Equal(t, a, rolledBack)
+	assert.Nil(t, octodiff.VerifyNewFile(bytes.NewReader(rolledBack), octodiff.NewBinaryDeltaReader(bytes.NewReader(reverseDeltaFile.Bytes()))))
+
+	// the reverse delta should mostly be copies, not a full copy of the basis file
+	assert.Less(t, reverseDeltaFile.Len(), len(a)/4)
+}
+
+func TestApplyDeltaAndReverse(t *testing.T) {
+	a, b, _ := threeVersions()
+	deltaFile := buildDelta(b, buildSignature(a))
+
+	var output, reverseDeltaFile bytes.Buffer
+	err := octodiff.ApplyDeltaAndReverse(bytes.NewReader(a), octodiff.NewBinaryDeltaReader(bytes.NewReader(deltaFile)), &output, octodiff.NewBinaryDeltaWriter(&reverseDeltaFile))
+	assert.Nil(t, err)
+	assert.Equal(t, b, output.Bytes())
+
+	assert.Equal(t, a, applyDeltaFile(b, reverseDeltaFile.Bytes()))
+}
+
+func TestBuildReverseDeltaForFullDelta(t *testing.T) {
+	a, b, _ := threeVersions()
+	deltaFile := buildDelta(b, buildSignature(nil)) // no copy commands at all
+
+	var reverseDeltaFile bytes.Buffer
+	err := octodiff.BuildReverseDelta(bytes.NewReader(a), octodiff.NewBinaryDeltaReader(bytes.NewReader(deltaFile)), octodiff.NewBinaryDeltaWriter(&reverseDeltaFile))
+	assert.Nil(t, err)
+
+	assert.Equal(t, a, applyDeltaFile(b, reverseDeltaFile.Bytes()))
+}
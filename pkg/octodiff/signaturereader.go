@@ -2,8 +2,7 @@ package octodiff
 
 import (
 	"bytes"
-	"errors"
-	"fmt"
+	"encoding/binary"
 	"io"
 )
 
@@ -17,56 +16,64 @@ func NewSignatureReader() *SignatureReader {
 	}
 }
 
+// ReadSignature reads an OCTOSIG signature file, or a librsync signature as produced by `rdiff signature`
 func (s *SignatureReader) ReadSignature(input io.Reader, inputLength int64) (*Signature, error) {
 	pos := int64(0)
 	s.ProgressReporter.ReportProgress("Reading signature", pos, inputLength)
 
 	headerBytes := make([]byte, len(BinarySignatureHeader))
-	bytesRead, err := input.Read(headerBytes)
+	bytesRead, err := io.ReadFull(input, headerBytes)
+	if bytesRead >= 4 && isRdiffSignatureMagic(binary.BigEndian.Uint32(headerBytes)) {
+		// this is a librsync signature. Give back the bytes we read past its magic number and read it in that format
+		rest := io.MultiReader(bytes.NewReader(headerBytes[4:bytesRead]), input)
+		return readRdiffSignature(rest, binary.BigEndian.Uint32(headerBytes), inputLength-4)
+	}
 	if err != nil {
-		return nil, err
+		return nil, truncatedOrError(err, "signature", int64(bytesRead))
 	}
-	if bytesRead != len(BinarySignatureHeader) || !bytes.Equal(headerBytes, BinarySignatureHeader) {
-		return nil, errors.New("the signature file appears to be corrupt")
+	if !bytes.Equal(headerBytes, BinarySignatureHeader) {
+		return nil, newFormatError("signature", 0, ErrCorruptHeader, "the signature file appears to be corrupt")
 	}
 	pos += int64(bytesRead)
 
 	var versionBytes = make([]byte, len(BinaryVersion))
-	bytesRead, err = input.Read(versionBytes)
+	bytesRead, err = io.ReadFull(input, versionBytes)
 	if err != nil {
-		return nil, err
+		return nil, truncatedOrError(err, "signature", pos+int64(bytesRead))
 	}
-	if bytesRead != len(BinaryVersion) || !bytes.Equal(versionBytes, BinaryVersion) {
-		return nil, errors.New("the signature file uses a newer file format than this program can handle")
+	if !bytes.Equal(versionBytes, BinaryVersion) {
+		return nil, newFormatError("signature", pos, ErrUnsupportedVersion, "the signature file uses a newer file format than this program can handle")
 	}
 	pos += int64(bytesRead)
 
-	hashAlgorithmStr, bytesRead, err := readLengthPrefixedString(input)
+	hashAlgorithmOffset := pos
+	hashAlgorithmStr, bytesRead, err := readLengthPrefixedString(input, "signature", pos)
 	if err != nil {
 		return nil, err
 	}
 	pos += int64(bytesRead)
 
-	rollingChecksumAlgorithmStr, bytesRead, err := readLengthPrefixedString(input)
+	rollingChecksumAlgorithmOffset := pos
+	rollingChecksumAlgorithmStr, bytesRead, err := readLengthPrefixedString(input, "signature", pos)
 	if err != nil {
 		return nil, err
 	}
 	pos += int64(bytesRead)
 
 	var endBytes = make([]byte, len(BinaryEndOfMetadata))
-	bytesRead, err = input.Read(endBytes)
+	bytesRead, err = io.ReadFull(input, endBytes)
 	if err != nil {
-		return nil, err
+		return nil, truncatedOrError(err, "signature", pos+int64(bytesRead))
 	}
-	if bytesRead != len(endBytes) || !bytes.Equal(endBytes, BinaryEndOfMetadata) {
-		return nil, errors.New("the signature file appears to be corrupt")
+	if !bytes.Equal(endBytes, BinaryEndOfMetadata) {
+		return nil, newFormatError("signature", pos, ErrCorruptHeader, "the signature file appears to be corrupt")
 	}
 	pos += int64(bytesRead)
 
 	s.ProgressReporter.ReportProgress("Reading signature", pos, inputLength)
 
 	if hashAlgorithmStr != DefaultHashAlgorithm.Name() {
-		return nil, fmt.Errorf("signature uses unsupported hash algorithm %s", hashAlgorithmStr)
+		return nil, newFormatError("signature", hashAlgorithmOffset, ErrUnsupportedAlgorithm, "signature uses unsupported hash algorithm %s", hashAlgorithmStr)
 	}
 	hashAlgorithm := DefaultHashAlgorithm
 
@@ -77,28 +84,36 @@ func (s *SignatureReader) ReadSignature(input io.Reader, inputLength int64) (*Si
 	case Adler32RollingChecksumV2Name:
 		rollingChecksum = NewAdler32RollingChecksumV2()
 	default:
-		return nil, fmt.Errorf("signature uses unsupported rolling checksum algorithm %s", rollingChecksumAlgorithmStr)
+		return nil, newFormatError("signature", rollingChecksumAlgorithmOffset, ErrUnsupportedAlgorithm, "signature uses unsupported rolling checksum algorithm %s", rollingChecksumAlgorithmStr)
 	}
 
 	expectedHashLength := hashAlgorithm.HashLength()
 	remainingBytes := inputLength - pos
 	signatureSize := 2 + 4 + expectedHashLength
 
-	if remainingBytes%int64(signatureSize) != 0 {
-		return nil, errors.New("the signature file appears to be corrupt; at least one chunk has data missing")
+	if remainingBytes < 0 || remainingBytes%int64(signatureSize) != 0 {
+		return nil, newFormatError("signature", inputLength-(remainingBytes%int64(signatureSize)), ErrTruncatedChunk, "the signature file appears to be corrupt; at least one chunk has data missing")
 	}
 
 	expectedNumberOfChunks := remainingBytes / int64(signatureSize)
+	if expectedNumberOfChunks > maxPreallocatedChunks {
+		expectedNumberOfChunks = maxPreallocatedChunks
+	}
 
 	chunks := make([]*ChunkSignature, 0, expectedNumberOfChunks)
 
 	chunkStart := int64(0)
-	iter := NewReaderIteratorSize(input, signatureSize)
-	for iter.Next() {
-		block := iter.Current
-		blockBytesRead := len(iter.Current)
-		if blockBytesRead != signatureSize {
-			return nil, fmt.Errorf("expecting to read %d bytes for ChunkSignature but only got %d", signatureSize, blockBytesRead)
+	block := make([]byte, signatureSize)
+	for {
+		blockBytesRead, err := io.ReadFull(input, block)
+		if err == io.EOF {
+			break // no more chunks
+		}
+		if err == io.ErrUnexpectedEOF {
+			return nil, newFormatError("signature", pos, ErrTruncatedChunk, "expecting to read %d bytes for ChunkSignature but only got %d", signatureSize, blockBytesRead)
+		}
+		if err != nil {
+			return nil, err
 		}
 		pos += int64(blockBytesRead)
 
@@ -117,9 +132,6 @@ func (s *SignatureReader) ReadSignature(input io.Reader, inputLength int64) (*Si
 
 		s.ProgressReporter.ReportProgress("Reading signature", pos, inputLength)
 	}
-	if err = iter.Err(); err != nil {
-		return nil, err
-	}
 
 	return &Signature{
 		HashAlgorithm:            hashAlgorithm,
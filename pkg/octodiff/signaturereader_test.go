@@ -6,6 +6,7 @@ import (
 	"github.com/OctopusDeploy/go-octodiff/pkg/octodiff"
 	"github.com/stretchr/testify/assert"
 	"testing"
+	"testing/iotest"
 )
 
 func readSignature(input []byte) (*octodiff.Signature, error) {
@@ -74,3 +75,12 @@ func TestReadsLargeChunkSizeSignatureOverLargeFile(t *testing.T) {
 	assertChunk(t, s.Chunks[2], 63488, 1591746682, 31744, "c605af9c2fd5a61b60f65600f5849f6ce1c53cf1")
 	assertChunk(t, s.Chunks[3], 95232, 4058619052, 7168, "94d25de18f219fa7832df14593cade50d8b0d2a2")
 }
+
+func TestReadsSignatureOneByteAtATime(t *testing.T) {
+	input, _ := hex.DecodeString("4f43544f5349470104534841310741646c657233323e3e3e0802f79fa2f0330bd06982d3b5dbda6c1a6ad16687a0cdb03c0d")
+
+	s, err := octodiff.NewSignatureReader().ReadSignature(iotest.OneByteReader(bytes.NewReader(input)), int64(len(input)))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(s.Chunks))
+	assertChunk(t, s.Chunks[0], 0, 4037189623, 520, "330bd06982d3b5dbda6c1a6ad16687a0cdb03c0d")
+}
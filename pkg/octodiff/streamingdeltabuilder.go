@@ -0,0 +1,214 @@
+package octodiff
+
+import (
+	"bytes"
+	"errors"
+	"io"
+)
+
+// StreamingDeltaBuilder creates deltas like DeltaBuilder, but reads the new file exactly once from a plain io.Reader,
+// so it can be used with pipes and network streams. At most BufferSize bytes of the new file (plus one chunk) are held
+// in memory; literal data is written out in pieces whenever the buffer fills up.
+//
+// The hash of the new file isn't known until all of it has been read, but delta formats put it at the start.
+// So the metadata is written with a placeholder hash of zeros, and Build returns the real hash for the caller to fill in,
+// such as with BinaryDeltaWriter.WriteExpectedHash. Until that's done the delta can only be applied without verification.
+type StreamingDeltaBuilder struct {
+	ProgressReporter ProgressReporter
+	BufferSize       int
+}
+
+func NewStreamingDeltaBuilder() *StreamingDeltaBuilder {
+	return &StreamingDeltaBuilder{
+		ProgressReporter: NopProgressReporter(),
+		BufferSize:       defaultReadBufferSize,
+	}
+}
+
+// Build creates a delta, writing it out using `deltaWriter`, and returns the hash of the new file.
+// newFileLength is only used to report progress, and may be -1 if it isn't known.
+func (d *StreamingDeltaBuilder) Build(newFile io.Reader, newFileLength int64, signatureFile io.Reader, signatureFileLength int64, deltaWriter DeltaWriter) ([]byte, error) {
+	if d.BufferSize < 1 {
+		return nil, errors.New("StreamingDeltaBuilder BufferSize must be positive")
+	}
+
+	signatureReader := NewSignatureReader()
+	signatureReader.ProgressReporter = d.ProgressReporter
+
+	signature, err := signatureReader.ReadSignature(signatureFile, signatureFileLength)
+	if err != nil {
+		return nil, err
+	}
+
+	hashAlgorithm := newFileHashAlgorithm(signature)
+	hasher := newStreamingHasher(hashAlgorithm)
+	defer hasher.close()
+
+	err = deltaWriter.WriteMetadata(hashAlgorithm, make([]byte, hashAlgorithm.HashLength()))
+	if err != nil {
+		return nil, err
+	}
+
+	chunks := signature.Chunks
+	sortChunkSignatures(chunks)
+	chunkMap, minChunkSize, maxChunkSize := createChunkMap(chunks, d.ProgressReporter)
+
+	scanner := &streamingDeltaScanner{
+		input:       io.TeeReader(newFile, hasher),
+		window:      make([]byte, 0, d.BufferSize+maxChunkSize),
+		deltaWriter: deltaWriter,
+	}
+	d.ProgressReporter.ReportProgress("Building delta", 0, newFileLength)
+
+	for {
+		available := scanner.available()
+		if available < int64(maxChunkSize) && !scanner.eof {
+			err = scanner.fill()
+			if err != nil {
+				return nil, err
+			}
+			d.ProgressReporter.ReportProgress("Building delta", scanner.position, newFileLength)
+			continue
+		}
+
+		// near the end of the file, look for the last chunk of the basis file, which may be shorter than the others
+		chunkSize := maxChunkSize
+		if available < int64(maxChunkSize) {
+			chunkSize = minChunkSize
+		}
+		if len(chunks) == 0 || available < int64(chunkSize) {
+			break
+		}
+
+		matched, err := scanner.match(chunkSize, signature, chunks, chunkMap)
+		if err != nil {
+			return nil, err
+		}
+		if !matched {
+			scanner.position++
+		}
+	}
+
+	// everything that's left is literal data
+	for {
+		scanner.position = scanner.windowStart + int64(len(scanner.window))
+		if scanner.eof {
+			break
+		}
+		err = scanner.fill()
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = scanner.writeLiteral()
+	if err != nil {
+		return nil, err
+	}
+	d.ProgressReporter.ReportProgress("Building delta", scanner.position, newFileLength)
+
+	err = deltaWriter.Flush()
+	if err != nil {
+		return nil, err
+	}
+	return hasher.sum()
+}
+
+// streamingDeltaScanner holds the part of the new file which is still needed: any literal data which hasn't been
+// written yet, followed by the data at the current position which might match a chunk.
+type streamingDeltaScanner struct {
+	input       io.Reader
+	eof         bool
+	window      []byte
+	windowStart int64 // the offset in the new file of window[0]
+	position    int64 // the offset in the new file we're looking for a matching chunk at
+	literalFrom int64 // data between here and position hasn't matched anything, and hasn't been written yet
+
+	checksum     uint32
+	checksumSize int // the chunk size that checksum was calculated over, at position-1. Zero if there isn't one
+	deltaWriter  DeltaWriter
+}
+
+func (s *streamingDeltaScanner) available() int64 {
+	return s.windowStart + int64(len(s.window)) - s.position
+}
+
+// fill reads more of the input into the window, first writing out any pending literal data and discarding
+// everything before the current position if the window is full.
+func (s *streamingDeltaScanner) fill() error {
+	if len(s.window) == cap(s.window) {
+		err := s.writeLiteral()
+		if err != nil {
+			return err
+		}
+		n := copy(s.window, s.window[s.position-s.windowStart:])
+		s.window = s.window[:n]
+		s.windowStart = s.position
+		s.checksumSize = 0 // the byte before position has gone, so the checksum can't be rotated
+	}
+
+	for len(s.window) < cap(s.window) {
+		n, err := s.input.Read(s.window[len(s.window):cap(s.window)])
+		s.window = s.window[:len(s.window)+n]
+		if err == io.EOF {
+			s.eof = true
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeLiteral writes any data that didn't match a chunk, up to the current position
+func (s *streamingDeltaScanner) writeLiteral() error {
+	if s.position == s.literalFrom {
+		return nil
+	}
+	data := s.window[s.literalFrom-s.windowStart : s.position-s.windowStart]
+	err := s.deltaWriter.WriteDataCommand(bytes.NewReader(data), 0, int64(len(data)))
+	s.literalFrom = s.position
+	return err
+}
+
+// match checks whether the `chunkSize` bytes at the current position match a chunk in the signature,
+// and if so writes the copy command and moves past them
+func (s *streamingDeltaScanner) match(chunkSize int, signature *Signature, chunks []*ChunkSignature, chunkMap map[uint32]int) (bool, error) {
+	i := s.position - s.windowStart
+	block := s.window[i : i+int64(chunkSize)]
+	if s.checksumSize == chunkSize {
+		s.checksum = signature.RollingChecksumAlgorithm.Rotate(s.checksum, s.window[i-1], block[chunkSize-1], chunkSize)
+	} else {
+		s.checksum = signature.RollingChecksumAlgorithm.Calculate(block)
+		s.checksumSize = chunkSize
+	}
+
+	startIndex, ok := chunkMap[s.checksum]
+	if !ok {
+		return false, nil
+	}
+
+	var hash []byte
+	for j := startIndex; j < len(chunks) && chunks[j].RollingChecksum == s.checksum; j++ {
+		if hash == nil {
+			hash = signature.HashAlgorithm.HashOverData(block)
+		}
+		if !bytes.Equal(hash, chunks[j].Hash) {
+			continue
+		}
+
+		err := s.writeLiteral()
+		if err != nil {
+			return false, err
+		}
+		err = s.deltaWriter.WriteCopyCommand(chunks[j].StartOffset, int64(chunks[j].Length))
+		if err != nil {
+			return false, err
+		}
+		s.position += int64(chunkSize)
+		s.literalFrom = s.position
+		s.checksumSize = 0 // we've jumped forward, so the next checksum has to be calculated from scratch
+		return true, nil
+	}
+	return false, nil
+}
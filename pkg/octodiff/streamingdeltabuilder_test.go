@@ -0,0 +1,89 @@
+package octodiff_test
+
+import (
+	"bytes"
+	"github.com/OctopusDeploy/go-octodiff/pkg/octodiff"
+	"github.com/OctopusDeploy/go-octodiff/pkg/test"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"testing"
+	"testing/iotest"
+)
+
+// buildStreamingDelta builds a delta from `newFile` read through a plain io.Reader, and fills in the expected hash
+func buildStreamingDelta(newFile io.Reader, signature []byte, bufferSize int) []byte {
+	builder := octodiff.NewStreamingDeltaBuilder()
+	builder.BufferSize = bufferSize
+
+	var output bytes.Buffer
+	writer := octodiff.NewBinaryDeltaWriter(&output)
+	hash, err := builder.Build(newFile, -1, bytes.NewReader(signature), int64(len(signature)), writer)
+	if err != nil {
+		panic(err) // should never fail under tests
+	}
+
+	file := &memoryFile{data: output.Bytes()}
+	err = writer.WriteExpectedHash(file, hash)
+	if err != nil {
+		panic(err) // should never fail under tests
+	}
+	return file.data
+}
+
+func dataLength(delta *octodiff.Delta) int64 {
+	length := int64(0)
+	for _, cmd := range delta.Commands {
+		if cmd.Type == octodiff.DeltaCommandData {
+			length += cmd.Length
+		}
+	}
+	return length
+}
+
+func TestStreamingDeltaBuilder(t *testing.T) {
+	original, newFile := largeFileWithDisjointChanges()
+
+	tests := []struct {
+		name       string
+		newFile    io.Reader
+		bufferSize int
+	}{
+		{"default buffer", bytes.NewReader(newFile), octodiff.NewStreamingDeltaBuilder().BufferSize},
+		{"small buffer", bytes.NewReader(newFile), 5000},
+		{"tiny buffer", bytes.NewReader(newFile), 1},
+		{"short reads", iotest.HalfReader(bytes.NewReader(newFile)), 5000},
+		{"one byte reads", iotest.OneByteReader(bytes.NewReader(newFile)), 30000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deltaFile := buildStreamingDelta(tt.newFile, buildSignature(original), tt.bufferSize)
+
+			output, err := applyAndVerify(original, octodiff.NewBinaryDeltaReader(bytes.NewReader(deltaFile)))
+			assert.Nil(t, err)
+			assert.Equal(t, newFile, output)
+
+			// no more literal data than the seeking DeltaBuilder would send
+			assert.LessOrEqual(t, dataLength(readDelta(deltaFile)), dataLength(readDelta(buildDelta(newFile, buildSignature(original)))))
+		})
+	}
+}
+
+func TestStreamingDeltaBuilderMatchesShortLastChunk(t *testing.T) {
+	original := test.GenerateTestData(10000) // the last chunk is shorter than the rest
+	newFile := append([]byte("prefix"), original...)
+
+	deltaFile := buildStreamingDelta(bytes.NewReader(newFile), buildSignatureWithChunkSize(original, 1024), 4096)
+	delta := readDelta(deltaFile)
+	assert.Equal(t, 2, len(delta.Commands))
+	assert.Equal(t, octodiff.NewDataCommand([]byte("prefix")), delta.Commands[0])
+	assert.Equal(t, octodiff.NewCopyCommand(0, 10000), delta.Commands[1])
+}
+
+func TestStreamingDeltaBuilderWithEmptySignature(t *testing.T) {
+	newFile := test.GenerateTestData(10000)
+
+	deltaFile := buildStreamingDelta(bytes.NewReader(newFile), buildSignature(nil), 4096)
+	output, err := applyAndVerify(nil, octodiff.NewBinaryDeltaReader(bytes.NewReader(deltaFile)))
+	assert.Nil(t, err)
+	assert.Equal(t, newFile, output)
+}
@@ -0,0 +1,60 @@
+// Regenerates the signatures and deltas in the directory above using the C# OctoDiff library.
+// The basis and new files in each case directory are inputs, and are left as they are.
+// Run from this directory with: dotnet run -- ..
+
+using System.Security.Cryptography;
+using System.Text;
+using Octodiff.Core;
+using Octodiff.Diagnostics;
+
+var root = args.Length > 0 ? args[0] : "..";
+
+var cases = new (string Name, short ChunkSize, IRollingChecksum RollingChecksum)[]
+{
+    ("same-file", SignatureBuilder.DefaultChunkSize, new Adler32RollingChecksum()),
+    ("empty-basis", SignatureBuilder.DefaultChunkSize, new Adler32RollingChecksum()),
+    ("changed-bytes", SignatureBuilder.MinimumChunkSize, new Adler32RollingChecksum()),
+    ("prepended-byte", SignatureBuilder.MinimumChunkSize, new Adler32RollingChecksum()),
+    ("large-file-disjoint-changes", SignatureBuilder.DefaultChunkSize, new Adler32RollingChecksum()),
+    ("adler32v2", SignatureBuilder.MinimumChunkSize, new Adler32RollingChecksumV2()),
+};
+
+foreach (var (name, chunkSize, rollingChecksum) in cases)
+{
+    var dir = Path.Combine(root, name);
+    WriteSignature(dir, new SignatureBuilder { ChunkSize = chunkSize, RollingChecksumAlgorithm = rollingChecksum });
+    if (File.Exists(Path.Combine(dir, "new")))
+    {
+        WriteDelta(dir);
+    }
+}
+
+// a hash algorithm name longer than 127 bytes, which needs two bytes for its length
+WriteSignature(Path.Combine(root, "long-algorithm-name"), new SignatureBuilder { HashAlgorithm = new RenamedSha1(new string('A', 200)) });
+
+static void WriteSignature(string dir, SignatureBuilder builder)
+{
+    using var basis = File.OpenRead(Path.Combine(dir, "basis"));
+    using var signature = File.Create(Path.Combine(dir, "basis.octosig"));
+    builder.ProgressReporter = new NullProgressReporter();
+    builder.Build(basis, new SignatureWriter(signature));
+}
+
+static void WriteDelta(string dir)
+{
+    using var newFile = File.OpenRead(Path.Combine(dir, "new"));
+    using var signature = File.OpenRead(Path.Combine(dir, "basis.octosig"));
+    using var delta = File.Create(Path.Combine(dir, "new.octodelta"));
+    var builder = new DeltaBuilder { ProgressReporter = new NullProgressReporter() };
+    builder.BuildDelta(newFile, new SignatureReader(signature, new NullProgressReporter()), new AggregateCopyOperationsDecorator(new BinaryDeltaWriter(delta)));
+}
+
+class RenamedSha1 : IHashAlgorithm
+{
+    public RenamedSha1(string name) => Name = name;
+
+    public string Name { get; }
+    public int HashLength => 20;
+    public byte[] ComputeHash(Stream stream) => SHA1.HashData(stream);
+    public byte[] ComputeHash(byte[] buffer, int offset, int length) => SHA1.HashData(buffer.AsSpan(offset, length));
+}
@@ -1,43 +1,116 @@
 package octodiff
 
 import (
-	"encoding/binary"
-	"fmt"
 	"io"
 )
 
 // There are a number of places where we allocate buffers to read files, which use this value
 const defaultReadBufferSize = 4 * 1024 * 1024
 
-// returns the string, how many bytes we read in order to get it, and an error
-func readLengthPrefixedString(input io.Reader) (string, int, error) {
-	// C# BinaryWriter prefixes strings with their length using a single byte for small strings, or 4 bytes for larger
-	// We only handle small strings here
-	var contentLen uint8
-	err := binary.Read(input, binary.LittleEndian, &contentLen)
+// maxPreallocatedChunks limits how many chunk signatures we make room for up front, based on the length of a signature
+// file. The length is only a hint (and a hostile one could be huge), so beyond this the slice grows as chunks are read.
+const maxPreallocatedChunks = 64 * 1024
+
+// maxLengthPrefixedStringLength limits the strings we'll read from a file. They're only ever algorithm names,
+// so anything longer means the file is corrupt, and we shouldn't allocate however much it claims to need.
+const maxLengthPrefixedStringLength = 1024
+
+// readLengthPrefixedString reads a string written by C# BinaryWriter, where `offset` is the position of the string
+// in `file` for reporting errors. Returns the string, how many bytes we read in order to get it, and an error
+func readLengthPrefixedString(input io.Reader, file string, offset int64) (string, int, error) {
+	return readBoundedLengthPrefixedString(input, file, offset, maxLengthPrefixedStringLength)
+}
+
+// readBoundedLengthPrefixedString is readLengthPrefixedString for strings of up to `maxLength` bytes
+func readBoundedLengthPrefixedString(input io.Reader, file string, offset int64, maxLength int) (string, int, error) {
+	contentLen, lengthBytesRead, err := read7BitEncodedInt(input)
 	if err != nil {
-		return "", 0, err
+		return "", lengthBytesRead, truncatedOrError(err, file, offset+int64(lengthBytesRead))
+	}
+	if contentLen < 0 || contentLen > maxLength {
+		return "", lengthBytesRead, newFormatError(file, offset, ErrCorruptHeader, "the %s file appears to be corrupt; string length %d is out of range", file, contentLen)
 	}
 
 	var content = make([]byte, contentLen)
-	bytesRead, err := input.Read(content)
-	if err != nil {
-		return "", 1 + bytesRead, err
+	bytesRead, err := io.ReadFull(input, content)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF // we've read the length, so the file ended part way through the string
 	}
-	if bytesRead != int(contentLen) {
-		return "", 1 + bytesRead, fmt.Errorf("Binary format indicates string length to read of %d but only %d bytes were read", contentLen, bytesRead)
+	if err != nil {
+		return "", lengthBytesRead + bytesRead, truncatedOrError(err, file, offset+int64(lengthBytesRead+bytesRead))
 	}
-	return string(content), 1 + bytesRead, nil
+	return string(content), lengthBytesRead + bytesRead, nil
+}
+
+// lengthPrefixedStringSize returns the number of bytes writeLengthPrefixedString writes for `str`
+func lengthPrefixedStringSize(str string) int {
+	return len(append7BitEncodedInt(nil, len(str))) + len(str)
 }
 
+// writeLengthPrefixedString writes a string the way C# BinaryWriter does, prefixed with its length in bytes
 func writeLengthPrefixedString(output io.Writer, str string) error {
-	// C# BinaryWriter prefixes strings with their length using a single byte for small strings, or 4 bytes for larger
-	// We only handle small strings here
-	strBytes := []byte(str)
-	_, err := output.Write([]byte{byte(len(strBytes))})
+	_, err := output.Write(append(append7BitEncodedInt(nil, len(str)), str...))
+	return err
+}
+
+// read7BitEncodedInt reads an int written by C# BinaryWriter.Write7BitEncodedInt: 7 bits per byte, least significant
+// first, with the top bit set on every byte but the last. Returns the value, how many bytes were read, and an error
+func read7BitEncodedInt(input io.Reader) (int, int, error) {
+	var value uint32
+	b := make([]byte, 1)
+	for i := 0; i < 5; i++ { // a 32-bit value takes at most 5 bytes
+		_, err := io.ReadFull(input, b)
+		if err != nil {
+			if i > 0 && err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, i, err
+		}
+		value |= uint32(b[0]&0x7f) << (7 * i)
+		if b[0]&0x80 == 0 {
+			return int(int32(value)), i + 1, nil
+		}
+	}
+	return -1, 5, nil // more than 5 bytes isn't valid, and .NET refuses to read it; report it as out of range
+}
+
+// append7BitEncodedInt appends `value` to `buffer` in the format read by read7BitEncodedInt
+func append7BitEncodedInt(buffer []byte, value int) []byte {
+	v := uint32(value)
+	for v >= 0x80 {
+		buffer = append(buffer, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(buffer, byte(v))
+}
+
+// readSourceRange reads `length` bytes from `source` starting at `offset`, passing them to `fn` in chunks read into `buffer`.
+// `source` is seeked back to its original position afterwards, so callers can use this in the middle of reading the same file.
+func readSourceRange(source io.ReadSeeker, offset int64, length int64, buffer []byte, fn func([]byte) error) (err error) {
+	var originalPosition int64
+	originalPosition, err = source.Seek(0, io.SeekCurrent) // doing a no-op seek is how you find out the current position of a Go reader
 	if err != nil {
-		return err
+		return
 	}
-	_, err = output.Write(strBytes)
-	return err
+	// we need to ensure we seek back to originalPosition before exiting the function.
+	defer func() {
+		_, seekBackErr := source.Seek(originalPosition, io.SeekStart)
+		if seekBackErr != nil && err == nil {
+			err = seekBackErr // this causes readSourceRange to return this error
+		}
+	}()
+
+	_, err = source.Seek(offset, io.SeekStart)
+	if err != nil {
+		return
+	}
+
+	iter := NewReaderIteratorBufferNBytes(source, buffer, length)
+	for iter.Next() {
+		err = fn(iter.Current)
+		if err != nil {
+			return
+		}
+	}
+	return iter.Err()
 }
@@ -0,0 +1,49 @@
+package octodiff
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestLengthPrefixedStringUses7BitEncodedLength(t *testing.T) {
+	// the same as the .NET BinaryWriter.Write(string) produces
+	for _, tc := range []struct {
+		length int
+		prefix []byte
+	}{
+		{0, []byte{0x00}},
+		{4, []byte{0x04}},
+		{127, []byte{0x7f}},
+		{128, []byte{0x80, 0x01}},
+		{200, []byte{0xc8, 0x01}},
+		{1000, []byte{0xe8, 0x07}},
+	} {
+		str := strings.Repeat("x", tc.length)
+		var buffer bytes.Buffer
+		err := writeLengthPrefixedString(&buffer, str)
+		assert.Nil(t, err)
+		assert.Equal(t, append(tc.prefix, str...), buffer.Bytes())
+		assert.Equal(t, buffer.Len(), lengthPrefixedStringSize(str))
+
+		read, bytesRead, err := readLengthPrefixedString(&buffer, "signature", 0)
+		assert.Nil(t, err)
+		assert.Equal(t, str, read)
+		assert.Equal(t, len(tc.prefix)+tc.length, bytesRead)
+	}
+}
+
+func TestReadLengthPrefixedStringRejectsBadLengths(t *testing.T) {
+	_, _, err := readLengthPrefixedString(bytes.NewReader([]byte{0xff, 0xff, 0xff, 0xff, 0x07}), "delta", 10) // 2GB
+	assert.ErrorIs(t, err, ErrCorruptHeader)
+
+	_, _, err = readLengthPrefixedString(bytes.NewReader([]byte{0x80, 0x80, 0x80, 0x80, 0x80, 0x01}), "delta", 10) // more than 5 bytes
+	assert.ErrorIs(t, err, ErrCorruptHeader)
+
+	_, _, err = readLengthPrefixedString(bytes.NewReader([]byte{0x80}), "delta", 10)
+	assert.ErrorIs(t, err, ErrTruncatedChunk)
+
+	_, _, err = readLengthPrefixedString(bytes.NewReader([]byte{0x80, 0x01, 'x'}), "delta", 10)
+	assert.ErrorIs(t, err, ErrTruncatedChunk)
+}
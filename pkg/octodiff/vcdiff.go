@@ -0,0 +1,215 @@
+package octodiff
+
+import (
+	"io"
+)
+
+// Constants and helpers for the VCDIFF generic differencing and compression data format, RFC 3284
+
+var VcdiffHeader = []byte{0xd6, 0xc3, 0xc4, 0x00} // 'V' 'C' 'D' with their high bits set, then version 0
+
+const (
+	// Hdr_Indicator bits
+	vcdDecompress = 0x01
+	vcdCodeTable  = 0x02
+	vcdAppHeader  = 0x04 // not in RFC 3284, but widely used by xdelta3
+
+	// Win_Indicator bits
+	vcdSource   = 0x01
+	vcdTarget   = 0x02
+	vcdAdler32  = 0x04 // not in RFC 3284; a checksum of the target window written by xdelta3 and open-vcdiff
+	vcdNearSize = 4
+	vcdSameSize = 3
+
+	// we refuse to hold windows larger than this in memory
+	vcdiffMaxWindowSize = 64 * 1024 * 1024
+
+	// nor windows which take more than this many commands to apply, which overlapping copies of copied bytes can
+	// otherwise make one command per byte of
+	vcdiffMaxWindowCommands = 1024 * 1024
+)
+
+const (
+	vcdNoop = iota
+	vcdAdd
+	vcdRun
+	vcdCopy
+)
+
+type vcdiffInstruction struct {
+	instType byte
+	size     byte
+	mode     byte
+}
+
+// vcdiffDefaultCodeTable is the default instruction code table from RFC 3284 section 5.6.
+// Each opcode expands into up to two instructions
+var vcdiffDefaultCodeTable = buildVcdiffDefaultCodeTable()
+
+func buildVcdiffDefaultCodeTable() [256][2]vcdiffInstruction {
+	var table [256][2]vcdiffInstruction
+	i := 0
+	table[i][0] = vcdiffInstruction{instType: vcdRun}
+	i++
+	for size := 0; size <= 17; size++ {
+		table[i][0] = vcdiffInstruction{instType: vcdAdd, size: byte(size)}
+		i++
+	}
+	for mode := 0; mode <= 8; mode++ {
+		table[i][0] = vcdiffInstruction{instType: vcdCopy, mode: byte(mode)}
+		i++
+		for size := 4; size <= 18; size++ {
+			table[i][0] = vcdiffInstruction{instType: vcdCopy, size: byte(size), mode: byte(mode)}
+			i++
+		}
+	}
+	for mode := 0; mode <= 5; mode++ {
+		for addSize := 1; addSize <= 4; addSize++ {
+			for copySize := 4; copySize <= 6; copySize++ {
+				table[i][0] = vcdiffInstruction{instType: vcdAdd, size: byte(addSize)}
+				table[i][1] = vcdiffInstruction{instType: vcdCopy, size: byte(copySize), mode: byte(mode)}
+				i++
+			}
+		}
+	}
+	for mode := 6; mode <= 8; mode++ {
+		for addSize := 1; addSize <= 4; addSize++ {
+			table[i][0] = vcdiffInstruction{instType: vcdAdd, size: byte(addSize)}
+			table[i][1] = vcdiffInstruction{instType: vcdCopy, size: 4, mode: byte(mode)}
+			i++
+		}
+	}
+	for mode := 0; mode <= 8; mode++ {
+		table[i][0] = vcdiffInstruction{instType: vcdCopy, size: 4, mode: byte(mode)}
+		table[i][1] = vcdiffInstruction{instType: vcdAdd, size: 1}
+		i++
+	}
+	return table
+}
+
+// vcdiffAddressCache implements the "near" and "same" address caches from RFC 3284 section 5.1
+type vcdiffAddressCache struct {
+	near     [vcdNearSize]int64
+	nextSlot int
+	same     [vcdSameSize * 256]int64
+}
+
+func (c *vcdiffAddressCache) update(addr int64) {
+	c.near[c.nextSlot] = addr
+	c.nextSlot = (c.nextSlot + 1) % vcdNearSize
+	c.same[addr%(vcdSameSize*256)] = addr
+}
+
+// decode reads an address encoded with `mode` from `addresses`, where `here` is the current position in the window's address space
+func (c *vcdiffAddressCache) decode(addresses *vcdiffSection, mode byte, here int64) (int64, error) {
+	addressOffset := addresses.fileOffset()
+	var addr int64
+	switch {
+	case mode == 0: // VCD_SELF
+		v, err := addresses.readVarint()
+		if err != nil {
+			return 0, err
+		}
+		addr = v
+	case mode == 1: // VCD_HERE
+		v, err := addresses.readVarint()
+		if err != nil {
+			return 0, err
+		}
+		addr = here - v
+	case mode < 2+vcdNearSize:
+		v, err := addresses.readVarint()
+		if err != nil {
+			return 0, err
+		}
+		addr = c.near[mode-2] + v
+	default:
+		b, err := addresses.readByte()
+		if err != nil {
+			return 0, err
+		}
+		addr = c.same[int(mode-(2+vcdNearSize))*256+int(b)]
+	}
+	if addr < 0 || addr >= here {
+		return 0, newFormatError("delta", addressOffset, ErrCorruptCommand, "the VCDIFF delta appears to be corrupt; copy address is out of range")
+	}
+	c.update(addr)
+	return addr, nil
+}
+
+// vcdiffSection reads through one of the data, instructions or addresses sections of a window
+type vcdiffSection struct {
+	data   []byte
+	pos    int
+	offset int64 // of data[0] in the delta file, for errors
+}
+
+// fileOffset returns how far through the delta file the next byte of the section is
+func (s *vcdiffSection) fileOffset() int64 {
+	return s.offset + int64(s.pos)
+}
+
+func (s *vcdiffSection) remaining() int {
+	return len(s.data) - s.pos
+}
+
+func (s *vcdiffSection) readByte() (byte, error) {
+	if s.pos >= len(s.data) {
+		return 0, newFormatError("delta", s.fileOffset(), ErrCorruptCommand, "the VCDIFF delta appears to be corrupt; a window section is too short")
+	}
+	b := s.data[s.pos]
+	s.pos++
+	return b, nil
+}
+
+func (s *vcdiffSection) readBytes(n int64) ([]byte, error) {
+	if n < 0 || n > int64(s.remaining()) {
+		return nil, newFormatError("delta", s.fileOffset(), ErrCorruptCommand, "the VCDIFF delta appears to be corrupt; a window section is too short")
+	}
+	b := s.data[s.pos : s.pos+int(n)]
+	s.pos += int(n)
+	return b, nil
+}
+
+func (s *vcdiffSection) readVarint() (int64, error) {
+	return readVcdiffVarint(s.readByte, s.fileOffset(), ErrCorruptCommand)
+}
+
+// readVcdiffVarint reads a base-128 big-endian integer, where every byte but the last has its high bit set.
+// `offset` is where it starts in the delta file, and `kind` the kind of FormatError if it's too large
+func readVcdiffVarint(readByte func() (byte, error), offset int64, kind error) (int64, error) {
+	var result int64
+	for i := 0; i < 9; i++ { // 9 bytes of 7 bits covers a 63-bit integer
+		b, err := readByte()
+		if err != nil {
+			return 0, err
+		}
+		result = result<<7 | int64(b&0x7f)
+		if b&0x80 == 0 {
+			return result, nil
+		}
+	}
+	return 0, newFormatError("delta", offset, kind, "the VCDIFF delta appears to be corrupt; integer is too large")
+}
+
+func appendVcdiffVarint(buffer []byte, value int64) []byte {
+	var tmp [10]byte
+	i := len(tmp) - 1
+	tmp[i] = byte(value & 0x7f)
+	for value >>= 7; value > 0; value >>= 7 {
+		i--
+		tmp[i] = byte(value&0x7f) | 0x80
+	}
+	return append(buffer, tmp[i:]...)
+}
+
+func readVcdiffVarintFrom(input *countingReader, kind error) (int64, error) {
+	b := make([]byte, 1)
+	return readVcdiffVarint(func() (byte, error) {
+		_, err := io.ReadFull(input, b)
+		if err != nil {
+			return 0, truncatedOrError(err, "delta", input.offset)
+		}
+		return b[0], nil
+	}, input.offset, kind)
+}
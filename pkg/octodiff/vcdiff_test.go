@@ -0,0 +1,187 @@
+package octodiff_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"github.com/OctopusDeploy/go-octodiff/pkg/octodiff"
+	"github.com/stretchr/testify/assert"
+	"math"
+	"testing"
+)
+
+func TestVcdiffReaderDecodesRfcExample(t *testing.T) {
+	// Window 1 is the example from RFC 3284 section 4.3, encoded by hand using VCD_SELF, VCD_HERE and near cache addressing,
+	// including a copy which overlaps the data it is producing, and a RUN.
+	// Window 2 uses a source segment from offset 4, a combined ADD+COPY opcode, and same cache addressing.
+	input, _ := hex.DecodeString("d6c3c40000" +
+		"01100013" + "1c00050603" + "7778797a7a" + "1405243c0004" + "001418" +
+		"0104040a" + "0900010202" + "21" + "a374" + "0000")
+	basis := []byte("abcdefghijklmnop")
+
+	var output bytes.Buffer
+	err := octodiff.ApplyDelta(bytes.NewReader(basis), octodiff.NewVcdiffDeltaReader(bytes.NewReader(input)), &output)
+	assert.Nil(t, err)
+	assert.Equal(t, "abcdwxyzefghefghefghefghzzzz!efghefgh", output.String())
+
+	// copies from earlier in the target window are resolved back to the basis file
+	reader := octodiff.NewVcdiffDeltaReader(bytes.NewReader(input))
+	actions := make([]string, 0)
+	err = reader.Apply(func(b []byte) error {
+		actions = append(actions, "write "+string(b))
+		return nil
+	}, func(offset int64, length int64) error {
+		actions = append(actions, "copy "+string(basis[offset:offset+length]))
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{
+		"copy abcd", "write wxyz", "copy efgh", "copy efgh", "copy efgh", "copy efgh", "write zzzz",
+		"write !", "copy efgh", "copy efgh",
+	}, actions)
+
+	// there was no application header, so there's nothing to verify against
+	_, err = reader.ExpectedHash()
+	assert.True(t, errors.Is(err, octodiff.ErrNoExpectedHash))
+}
+
+func appendVarint(b []byte, value int64) []byte {
+	var digits []byte
+	for {
+		digits = append([]byte{byte(value & 0x7f)}, digits...)
+		value >>= 7
+		if value == 0 {
+			break
+		}
+	}
+	for i := 0; i < len(digits)-1; i++ {
+		digits[i] |= 0x80
+	}
+	return append(b, digits...)
+}
+
+// overlappingCopyDelta encodes a VCDIFF window which produces one byte, then copies it `size` more times with a copy
+// from the target window which overlaps itself. With `fromSource` the first byte is copied from the basis file;
+// otherwise it is data.
+func overlappingCopyDelta(size int64, fromSource bool) []byte {
+	window := []byte{0} // no source segment
+	var data, instructions, addresses []byte
+	targetStart := int64(0) // the address of the target window, which follows the source segment
+	if fromSource {
+		window = []byte{0x01, 1, 0}                  // VCD_SOURCE: one byte from offset zero
+		instructions = appendVarint([]byte{0x13}, 1) // COPY of 1 byte, VCD_SELF mode
+		addresses = appendVarint(addresses, 0)
+		targetStart = 1
+	} else {
+		data = []byte("a")
+		instructions = []byte{0x02} // ADD of 1 byte
+	}
+	instructions = appendVarint(append(instructions, 0x13), size)
+	addresses = appendVarint(addresses, targetStart)
+	return vcdiffDelta(window, size+1, data, instructions, addresses)
+}
+
+// hugeRunDelta returns a VCDIFF delta with a window of 2 bytes, which adds one byte and then runs for 2^63-1 more
+func hugeRunDelta() []byte {
+	instructions := []byte{0x02}                                           // ADD of 1 byte
+	instructions = appendVarint(append(instructions, 0x00), math.MaxInt64) // RUN, with its size given separately
+	return vcdiffDelta([]byte{0}, 2, []byte("ab"), instructions, nil)
+}
+
+// vcdiffDelta returns a VCDIFF delta made up of one window, with the given window indicator and source segment
+func vcdiffDelta(window []byte, targetLength int64, data, instructions, addresses []byte) []byte {
+	encoding := appendVarint(nil, targetLength)
+	encoding = append(encoding, 0)
+	encoding = appendVarint(encoding, int64(len(data)))
+	encoding = appendVarint(encoding, int64(len(instructions)))
+	encoding = appendVarint(encoding, int64(len(addresses)))
+	encoding = append(append(append(encoding, data...), instructions...), addresses...)
+
+	delta := append([]byte(nil), octodiff.VcdiffHeader...)
+	delta = append(delta, 0) // no header indicators
+	delta = append(delta, window...)
+	delta = appendVarint(delta, int64(len(encoding)))
+	return append(delta, encoding...)
+}
+
+func TestVcdiffReaderRepeatsOverlappingCopiesOfData(t *testing.T) {
+	const size = 16*1024*1024 - 1
+	reader := octodiff.NewVcdiffDeltaReader(bytes.NewReader(overlappingCopyDelta(size, false)))
+
+	var writes [][]byte
+	err := reader.Apply(func(b []byte) error {
+		writes = append(writes, b)
+		return nil
+	}, func(offset int64, length int64) error {
+		t.Fatalf("unexpected copy")
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(writes)) // the data, then all the repeats at once
+	assert.True(t, bytes.Equal(bytes.Repeat([]byte("a"), size+1), bytes.Join(writes, nil)))
+}
+
+func TestVcdiffReaderLimitsOverlappingCopiesOfCopies(t *testing.T) {
+	// each repeat is a copy of the basis file, so has to be a command of its own
+	copies := 0
+	apply := func(size int64) error {
+		copies = 0
+		reader := octodiff.NewVcdiffDeltaReader(bytes.NewReader(overlappingCopyDelta(size, true)))
+		return reader.Apply(func(b []byte) error {
+			t.Fatalf("unexpected data")
+			return nil
+		}, func(offset int64, length int64) error {
+			copies++
+			return nil
+		})
+	}
+
+	err := apply(1000)
+	assert.Nil(t, err)
+	assert.Equal(t, 1001, copies)
+
+	err = apply(16 * 1024 * 1024)
+	assert.EqualError(t, err, "the VCDIFF delta has a window of more than 1048576 commands, which is more than this program will handle")
+}
+
+func TestVcdiffReaderRejectsHugeInstructionSizes(t *testing.T) {
+	reader := octodiff.NewVcdiffDeltaReader(bytes.NewReader(hugeRunDelta()))
+	err := reader.Apply(func(b []byte) error { return nil }, func(offset int64, length int64) error { return nil })
+	assertFormatError(t, err, "delta", 15, octodiff.ErrCorruptCommand) // the RUN
+	assert.EqualError(t, err, "the VCDIFF delta appears to be corrupt; instructions produce more data than the target window size (at offset 15)")
+}
+
+func TestVcdiffRoundTrip(t *testing.T) {
+	original, newFile := largeFileWithDisjointChanges()
+	signature := buildSignature(original)
+
+	var deltaFile bytes.Buffer
+	writer := octodiff.NewVcdiffDeltaWriter(&deltaFile)
+	writer.WindowSize = 16 * 1024 // force lots of windows, and splitting commands across them
+	err := octodiff.NewDeltaBuilder().Build(bytes.NewReader(newFile), int64(len(newFile)), bytes.NewReader(signature), int64(len(signature)), writer)
+	assert.Nil(t, err)
+
+	var output bytes.Buffer
+	reader := octodiff.NewVcdiffDeltaReader(bytes.NewReader(deltaFile.Bytes()))
+	err = octodiff.ApplyDelta(bytes.NewReader(original), reader, &output)
+	assert.Nil(t, err)
+	assert.Equal(t, newFile, output.Bytes())
+
+	assert.Nil(t, octodiff.VerifyNewFile(bytes.NewReader(output.Bytes()), reader))
+}
+
+func TestVcdiffWriterEncoding(t *testing.T) {
+	var output bytes.Buffer
+	writer := octodiff.NewVcdiffDeltaWriter(&output)
+	writer.OmitExpectedHash = true
+
+	assert.Nil(t, writer.WriteMetadata(octodiff.DefaultHashAlgorithm, make([]byte, 20)))
+	assert.Nil(t, writer.WriteCopyCommand(100, 4))
+	assert.Nil(t, writer.WriteCopyCommand(104, 4)) // merged with the previous copy
+	assert.Nil(t, writer.WriteDataCommand(bytes.NewReader([]byte("xyz")), 0, 3))
+	assert.Nil(t, writer.WriteCopyCommand(50, 200))
+	assert.Nil(t, writer.Flush())
+
+	// one window with a source segment of 200 bytes from offset 50, containing COPY 8 @50, ADD 3, COPY 200 @0
+	assert.Equal(t, "d6c3c40000"+"01814832"+"10"+"8153000305"+"02"+"78797a"+"1804138148"+"3200", hex.EncodeToString(output.Bytes()))
+}
@@ -0,0 +1,349 @@
+package octodiff
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+)
+
+// ErrNoExpectedHash is returned by VcdiffDeltaReader when the delta doesn't say what the new file's hash should be,
+// which is normal for VCDIFF files produced by other tools. Such files can only be applied without verification.
+var ErrNoExpectedHash = errors.New("the delta does not contain a hash of the new file, so the result cannot be verified")
+
+// VcdiffDeltaReader reads deltas in the VCDIFF format (RFC 3284).
+// Secondary compression, custom code tables, and windows which copy from the target file (VCD_TARGET) are not supported.
+// Copies from earlier in the same target window are resolved back to the basis file or literal data,
+// so the caller only ever sees copies from the basis file.
+type VcdiffDeltaReader struct {
+	input *countingReader
+
+	expectedHash    []byte
+	hashAlgorithm   HashAlgorithm
+	hasReadMetadata bool
+
+	ProgressReporter ProgressReporter
+	// DeltaLength is the size of the delta, which progress through it is reported against; -1 or zero if it isn't known
+	DeltaLength int64
+}
+
+var _ DeltaReader = (*VcdiffDeltaReader)(nil)
+
+func NewVcdiffDeltaReader(input io.Reader) *VcdiffDeltaReader {
+	return &VcdiffDeltaReader{
+		input:            &countingReader{reader: input},
+		ProgressReporter: NopProgressReporter(),
+	}
+}
+
+func (v *VcdiffDeltaReader) ExpectedHash() ([]byte, error) {
+	err := v.ensureMetadata()
+	if err != nil {
+		return nil, err
+	}
+	if v.expectedHash == nil {
+		return nil, ErrNoExpectedHash
+	}
+	return v.expectedHash, nil
+}
+
+func (v *VcdiffDeltaReader) HashAlgorithm() (HashAlgorithm, error) {
+	err := v.ensureMetadata()
+	if err != nil {
+		return nil, err
+	}
+	if v.hashAlgorithm == nil {
+		return nil, ErrNoExpectedHash
+	}
+	return v.hashAlgorithm, nil
+}
+
+func (v *VcdiffDeltaReader) ensureMetadata() error {
+	if v.hasReadMetadata {
+		return nil
+	}
+
+	header := make([]byte, len(VcdiffHeader)+1)
+	_, err := io.ReadFull(v.input, header)
+	if err != nil {
+		return truncatedOrError(err, "delta", v.input.offset)
+	}
+	if !bytes.Equal(header[:3], VcdiffHeader[:3]) {
+		return newFormatError("delta", 0, ErrCorruptHeader, "the delta file is not a VCDIFF file")
+	}
+	if header[3] != VcdiffHeader[3] {
+		return newFormatError("delta", 3, ErrUnsupportedVersion, "the VCDIFF delta uses a version this program can't handle")
+	}
+	indicator := header[4]
+	if indicator&vcdDecompress != 0 {
+		return newFormatError("delta", 4, ErrUnsupportedAlgorithm, "the VCDIFF delta uses secondary compression, which is not supported")
+	}
+	if indicator&vcdCodeTable != 0 {
+		return newFormatError("delta", 4, ErrUnsupportedAlgorithm, "the VCDIFF delta uses a custom code table, which is not supported")
+	}
+	if indicator&vcdAppHeader != 0 {
+		appHeaderOffset := v.input.offset
+		appHeaderLength, err := readVcdiffVarintFrom(v.input, ErrCorruptHeader)
+		if err != nil {
+			return err
+		}
+		if appHeaderLength > 1024 {
+			return newFormatError("delta", appHeaderOffset, ErrCorruptHeader, "the VCDIFF delta appears to be corrupt; application header is too large")
+		}
+		appHeader := make([]byte, appHeaderLength)
+		_, err = io.ReadFull(v.input, appHeader)
+		if err != nil {
+			return truncatedOrError(err, "delta", v.input.offset)
+		}
+		v.parseAppHeader(string(appHeader))
+	}
+
+	v.hasReadMetadata = true
+	return nil
+}
+
+// parseAppHeader picks up the hash written by VcdiffDeltaWriter.
+// Other tools put their own things in the application header, which we ignore
+func (v *VcdiffDeltaReader) parseAppHeader(appHeader string) {
+	name, hexHash, ok := strings.Cut(appHeader, ":")
+	if !ok || name != DefaultHashAlgorithm.Name() {
+		return
+	}
+	hash, err := hex.DecodeString(hexHash)
+	if err != nil || len(hash) != DefaultHashAlgorithm.HashLength() {
+		return
+	}
+	v.hashAlgorithm = DefaultHashAlgorithm
+	v.expectedHash = hash
+}
+
+func (v *VcdiffDeltaReader) Apply(writeData func([]byte) error, copyData func(int64, int64) error) error {
+	err := v.ensureMetadata()
+	if err != nil {
+		return err
+	}
+
+	indicator := make([]byte, 1)
+	for {
+		v.ProgressReporter.ReportProgress("Applying delta", v.input.offset, v.DeltaLength)
+		_, err = io.ReadFull(v.input, indicator)
+		if err == io.EOF {
+			return nil // all done, no more windows
+		}
+		if err != nil {
+			return truncatedOrError(err, "delta", v.input.offset)
+		}
+		err = v.applyWindow(indicator[0], writeData, copyData)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// applyWindow applies the window whose indicator byte has just been read.
+// Corruption anywhere in a window is reported as ErrCorruptCommand, with the offset of the part of the window at fault
+func (v *VcdiffDeltaReader) applyWindow(winIndicator byte, writeData func([]byte) error, copyData func(int64, int64) error) error {
+	windowOffset := v.input.offset - 1
+	if winIndicator&vcdTarget != 0 {
+		return newFormatError("delta", windowOffset, ErrUnsupportedAlgorithm, "the VCDIFF delta copies from the target file (VCD_TARGET), which is not supported")
+	}
+	var sourceLength, sourcePosition int64
+	var err error
+	if winIndicator&vcdSource != 0 {
+		sourceLength, err = readVcdiffVarintFrom(v.input, ErrCorruptCommand)
+		if err != nil {
+			return err
+		}
+		sourcePosition, err = readVcdiffVarintFrom(v.input, ErrCorruptCommand)
+		if err != nil {
+			return err
+		}
+		if sourcePosition > math.MaxInt64-sourceLength {
+			return newFormatError("delta", windowOffset, ErrCorruptCommand, "the VCDIFF delta appears to be corrupt; the source segment is out of range")
+		}
+	}
+
+	deltaEncodingOffset := v.input.offset
+	deltaEncodingLength, err := readVcdiffVarintFrom(v.input, ErrCorruptCommand)
+	if err != nil {
+		return err
+	}
+	if deltaEncodingLength > 3*vcdiffMaxWindowSize {
+		return newFormatError("delta", deltaEncodingOffset, ErrCorruptCommand, "the VCDIFF delta contains a window of %d bytes, which is larger than this program will handle", deltaEncodingLength)
+	}
+	deltaEncoding := make([]byte, deltaEncodingLength)
+	header := &vcdiffSection{data: deltaEncoding, offset: v.input.offset}
+	_, err = io.ReadFull(v.input, deltaEncoding)
+	if err != nil {
+		return truncatedOrError(err, "delta", v.input.offset)
+	}
+
+	targetLengthOffset := header.fileOffset()
+	targetLength, err := header.readVarint()
+	if err != nil {
+		return err
+	}
+	if targetLength > vcdiffMaxWindowSize {
+		return newFormatError("delta", targetLengthOffset, ErrCorruptCommand, "the VCDIFF delta contains a target window of %d bytes, which is larger than this program will handle", targetLength)
+	}
+	deltaIndicatorOffset := header.fileOffset()
+	deltaIndicator, err := header.readByte()
+	if err != nil {
+		return err
+	}
+	if deltaIndicator != 0 {
+		return newFormatError("delta", deltaIndicatorOffset, ErrUnsupportedAlgorithm, "the VCDIFF delta uses secondary compression, which is not supported")
+	}
+	var sectionLengths [3]int64
+	for i := range sectionLengths {
+		sectionLengths[i], err = header.readVarint()
+		if err != nil {
+			return err
+		}
+	}
+	if winIndicator&vcdAdler32 != 0 {
+		_, err = header.readBytes(4) // the checksum covers the target window; verification is done over the whole new file instead
+		if err != nil {
+			return err
+		}
+	}
+	var sections [3]*vcdiffSection
+	for i, length := range sectionLengths {
+		offset := header.fileOffset()
+		b, err := header.readBytes(length)
+		if err != nil {
+			return err
+		}
+		sections[i] = &vcdiffSection{data: b, offset: offset}
+	}
+	data, instructions, addresses := sections[0], sections[1], sections[2]
+
+	// everything produced so far in this window, so later copies from the target window can be resolved
+	target := newDeltaIndex(nil)
+	checkCommandCount := func() error {
+		if len(target.commands) >= vcdiffMaxWindowCommands {
+			return fmt.Errorf("the VCDIFF delta has a window of more than %d commands, which is more than this program will handle", vcdiffMaxWindowCommands)
+		}
+		return nil
+	}
+	emitData := func(b []byte) error {
+		err := checkCommandCount()
+		if err != nil {
+			return err
+		}
+		target.add(NewDataCommand(b))
+		return writeData(b)
+	}
+	emitCopy := func(offset int64, length int64) error {
+		err := checkCommandCount()
+		if err != nil {
+			return err
+		}
+		target.add(NewCopyCommand(offset, length))
+		return copyData(offset, length)
+	}
+
+	cache := &vcdiffAddressCache{}
+	for instructions.remaining() > 0 {
+		instructionOffset := instructions.fileOffset()
+		opcode, _ := instructions.readByte()
+		for _, inst := range vcdiffDefaultCodeTable[opcode] {
+			if inst.instType == vcdNoop {
+				continue
+			}
+			size := int64(inst.size)
+			if size == 0 {
+				size, err = instructions.readVarint()
+				if err != nil {
+					return err
+				}
+			}
+			if size < 0 || size > targetLength-target.length { // not target.length+size, which a huge size overflows
+				return newFormatError("delta", instructionOffset, ErrCorruptCommand, "the VCDIFF delta appears to be corrupt; instructions produce more data than the target window size")
+			}
+
+			switch inst.instType {
+			case vcdAdd:
+				b, err := data.readBytes(size)
+				if err != nil {
+					return err
+				}
+				err = emitData(b)
+				if err != nil {
+					return err
+				}
+			case vcdRun:
+				b, err := data.readByte()
+				if err != nil {
+					return err
+				}
+				err = emitData(bytes.Repeat([]byte{b}, int(size)))
+				if err != nil {
+					return err
+				}
+			case vcdCopy:
+				here := sourceLength + target.length
+				addr, err := cache.decode(addresses, inst.mode, here)
+				if err != nil {
+					return err
+				}
+				if addr < sourceLength { // copy from the source segment, which may run on into the target window
+					n := sourceLength - addr
+					if n > size {
+						n = size
+					}
+					err = emitCopy(sourcePosition+addr, n)
+					if err != nil {
+						return err
+					}
+					addr += n
+					size -= n
+				}
+				// copy from earlier in the target window. The copy may overlap the data it is producing, so
+				// copy in pieces that only read what has already been produced
+				for size > 0 {
+					targetAddr := addr - sourceLength
+					n := target.length - targetAddr
+					if n < size {
+						// the copy repeats the last n bytes. When they are all data, write the repeats out in one go,
+						// rather than a piece (and a command in `target`) for every n bytes
+						if period, ok := target.dataAt(targetAddr, n); ok {
+							err = emitData(repeatBytes(period, size))
+							if err != nil {
+								return err
+							}
+							break
+						}
+					}
+					if n > size {
+						n = size
+					}
+					err = target.visit(targetAddr, n, emitData, emitCopy)
+					if err != nil {
+						return err
+					}
+					addr += n
+					size -= n
+				}
+			}
+		}
+	}
+
+	if target.length != targetLength {
+		return newFormatError("delta", windowOffset, ErrCorruptCommand, "the VCDIFF delta appears to be corrupt; instructions do not produce the target window size")
+	}
+	return nil
+}
+
+// repeatBytes returns `length` bytes made up of `pattern` repeated
+func repeatBytes(pattern []byte, length int64) []byte {
+	result := make([]byte, length)
+	filled := int64(copy(result, pattern))
+	for filled < length {
+		filled += int64(copy(result[filled:], result[:filled])) // filled is always a multiple of len(pattern)
+	}
+	return result
+}
@@ -0,0 +1,195 @@
+package octodiff
+
+import (
+	"encoding/hex"
+	"io"
+)
+
+// VcdiffDefaultWindowSize is the default maximum number of new file bytes described by each VCDIFF window
+const VcdiffDefaultWindowSize = 4 * 1024 * 1024
+
+// VcdiffDeltaWriter writes deltas in the VCDIFF format (RFC 3284), as used by xdelta3 and open-vcdiff.
+// Commands are buffered in memory until a window of WindowSize bytes of the new file is described, then
+// written as a single VCDIFF window whose source segment covers all the copies in that window.
+type VcdiffDeltaWriter struct {
+	Output io.Writer
+
+	// VCDIFF has no place for a hash of the whole new file, so by default we write "<algorithm>:<hex hash>"
+	// into the xdelta3-style application header, which VcdiffDeltaReader reads back for verification.
+	// Some decoders (notably open-vcdiff) reject files with an application header; set this to leave it out.
+	OmitExpectedHash bool
+
+	WindowSize int
+
+	window     []*DeltaCommand
+	windowSize int64
+
+	buffer []byte // for reading data commands from the new file; allocated on first use
+}
+
+var _ DeltaWriter = (*VcdiffDeltaWriter)(nil)
+
+func NewVcdiffDeltaWriter(output io.Writer) *VcdiffDeltaWriter {
+	return &VcdiffDeltaWriter{
+		Output:     output,
+		WindowSize: VcdiffDefaultWindowSize,
+	}
+}
+
+func (w *VcdiffDeltaWriter) WriteMetadata(hashAlgorithm HashAlgorithm, expectedNewFileHash []byte) error {
+	header := append([]byte(nil), VcdiffHeader...)
+	if w.OmitExpectedHash {
+		header = append(header, 0)
+	} else {
+		appHeader := hashAlgorithm.Name() + ":" + hex.EncodeToString(expectedNewFileHash)
+		header = append(header, vcdAppHeader)
+		header = appendVcdiffVarint(header, int64(len(appHeader)))
+		header = append(header, appHeader...)
+	}
+	_, err := w.Output.Write(header)
+	return err
+}
+
+func (w *VcdiffDeltaWriter) WriteCopyCommand(offset int64, length int64) error {
+	for length > 0 {
+		n, err := w.reserve(length)
+		if err != nil {
+			return err
+		}
+		if last := w.lastCommand(); last != nil && last.Type == DeltaCommandCopy && last.Offset+last.Length == offset {
+			last.Length += n // merge sequential copies
+		} else {
+			w.window = append(w.window, NewCopyCommand(offset, n))
+		}
+		offset += n
+		length -= n
+	}
+	return nil
+}
+
+func (w *VcdiffDeltaWriter) WriteDataCommand(source io.ReadSeeker, offset int64, length int64) error {
+	return readSourceRange(source, offset, length, w.dataBuffer(), func(data []byte) error {
+		for len(data) > 0 {
+			n, err := w.reserve(int64(len(data)))
+			if err != nil {
+				return err
+			}
+			if last := w.lastCommand(); last != nil && last.Type == DeltaCommandData {
+				last.Data = append(last.Data, data[:n]...)
+				last.Length = int64(len(last.Data))
+			} else {
+				w.window = append(w.window, NewDataCommand(append([]byte(nil), data[:n]...)))
+			}
+			data = data[n:]
+		}
+		return nil
+	})
+}
+
+// Flush writes out any buffered commands as a window
+func (w *VcdiffDeltaWriter) Flush() error {
+	if len(w.window) == 0 {
+		return nil
+	}
+	err := w.writeWindow()
+	w.window = w.window[:0]
+	w.windowSize = 0
+	return err
+}
+
+func (w *VcdiffDeltaWriter) lastCommand() *DeltaCommand {
+	if len(w.window) == 0 {
+		return nil
+	}
+	return w.window[len(w.window)-1]
+}
+
+// reserve returns how many of `length` bytes fit in the current window, writing out the window first if it is full
+func (w *VcdiffDeltaWriter) reserve(length int64) (int64, error) {
+	windowSize := int64(w.WindowSize)
+	if windowSize <= 0 {
+		windowSize = VcdiffDefaultWindowSize
+	}
+	if w.windowSize >= windowSize {
+		err := w.Flush()
+		if err != nil {
+			return 0, err
+		}
+	}
+	n := windowSize - w.windowSize
+	if n > length {
+		n = length
+	}
+	w.windowSize += n
+	return n, nil
+}
+
+func (w *VcdiffDeltaWriter) writeWindow() error {
+	// the source segment spans every copy in this window
+	sourceStart, sourceEnd := int64(-1), int64(0)
+	for _, cmd := range w.window {
+		if cmd.Type != DeltaCommandCopy {
+			continue
+		}
+		if sourceStart < 0 || cmd.Offset < sourceStart {
+			sourceStart = cmd.Offset
+		}
+		if end := cmd.Offset + cmd.Length; end > sourceEnd {
+			sourceEnd = end
+		}
+	}
+
+	var data, instructions, addresses []byte
+	for _, cmd := range w.window {
+		if cmd.Type == DeltaCommandCopy {
+			// we always use VCD_SELF addressing, and the single-instruction COPY opcodes for mode 0
+			if cmd.Length >= 4 && cmd.Length <= 18 {
+				instructions = append(instructions, byte(19+cmd.Length-3))
+			} else {
+				instructions = append(instructions, 19)
+				instructions = appendVcdiffVarint(instructions, cmd.Length)
+			}
+			addresses = appendVcdiffVarint(addresses, cmd.Offset-sourceStart)
+		} else {
+			if cmd.Length <= 17 {
+				instructions = append(instructions, byte(1+cmd.Length))
+			} else {
+				instructions = append(instructions, 1)
+				instructions = appendVcdiffVarint(instructions, cmd.Length)
+			}
+			data = append(data, cmd.Data...)
+		}
+	}
+
+	var deltaEncodingHeader []byte
+	deltaEncodingHeader = appendVcdiffVarint(deltaEncodingHeader, w.windowSize)
+	deltaEncodingHeader = append(deltaEncodingHeader, 0) // Delta_Indicator; no compression
+	deltaEncodingHeader = appendVcdiffVarint(deltaEncodingHeader, int64(len(data)))
+	deltaEncodingHeader = appendVcdiffVarint(deltaEncodingHeader, int64(len(instructions)))
+	deltaEncodingHeader = appendVcdiffVarint(deltaEncodingHeader, int64(len(addresses)))
+
+	var windowHeader []byte
+	if sourceStart >= 0 {
+		windowHeader = append(windowHeader, vcdSource)
+		windowHeader = appendVcdiffVarint(windowHeader, sourceEnd-sourceStart)
+		windowHeader = appendVcdiffVarint(windowHeader, sourceStart)
+	} else {
+		windowHeader = append(windowHeader, 0)
+	}
+	windowHeader = appendVcdiffVarint(windowHeader, int64(len(deltaEncodingHeader)+len(data)+len(instructions)+len(addresses)))
+
+	for _, b := range [][]byte{windowHeader, deltaEncodingHeader, data, instructions, addresses} {
+		_, err := w.Output.Write(b)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *VcdiffDeltaWriter) dataBuffer() []byte {
+	if w.buffer == nil {
+		w.buffer = make([]byte, defaultDataCommandBufferSize)
+	}
+	return w.buffer
+}
@@ -0,0 +1,94 @@
+package octodiff
+
+import (
+	"bufio"
+	"io"
+	"os"
+)
+
+// applyDeltaFileToFile applies a delta for ApplyDeltaWithOptions when both files are *os.File, doing copy commands
+// with (*os.File).ReadFrom so they can use copy_file_range, and coalescing sequential copies so there are as few
+// calls as possible. Data commands are buffered in between. The new file is written from the current position of
+// `output`. Returns the length of the new file.
+func applyDeltaFileToFile(basisFile *os.File, deltaReader DeltaReader, output *os.File, limits *applyLimits) (int64, error) {
+	outputStream := bufio.NewWriterSize(output, fileOutputBufferSize)
+	newFileLength := int64(0)
+
+	var copyOffset, copyLength int64 // the copy waiting to be done, if copyLength isn't zero
+	flushCopy := func() error {
+		if copyLength == 0 {
+			return nil
+		}
+		_, err := basisFile.Seek(copyOffset, io.SeekStart)
+		if err != nil {
+			return err
+		}
+		// copied bytes go straight to the file, so anything before them must be written first
+		err = outputStream.Flush()
+		if err != nil {
+			return err
+		}
+		// a short basis file gives a short copy without an error, the same as ApplyDelta's own copies
+		copied, err := output.ReadFrom(&io.LimitedReader{R: basisFile, N: copyLength})
+		newFileLength += copied
+		copyLength = 0
+		return err
+	}
+
+	err := deltaReader.Apply(
+		func(bytes []byte) error {
+			err := limits.checkData(bytes)
+			if err != nil {
+				return err
+			}
+			err = flushCopy()
+			if err != nil {
+				return err
+			}
+			written, err := outputStream.Write(bytes)
+			newFileLength += int64(written)
+			return err
+		},
+		func(offset int64, length int64) error {
+			err := limits.checkCopy(offset, length)
+			if err != nil {
+				return err
+			}
+			if copyLength != 0 && copyOffset+copyLength == offset {
+				copyLength += length
+				return nil
+			}
+			err = flushCopy()
+			copyOffset, copyLength = offset, length
+			return err
+		})
+	if err == nil {
+		err = flushCopy()
+	}
+	if err == nil {
+		err = outputStream.Flush()
+	}
+	return newFileLength, err
+}
+
+// canZeroCopy reports whether copies between `basisFile` and `output` can use applyDeltaFileToFile.
+// copy_file_range only works between regular files, and when verifying, the output must be readable to hash it.
+func canZeroCopy(basisFile io.ReadSeeker, output io.Writer, verify bool) (*os.File, *os.File, bool) {
+	if !zeroCopySupported {
+		return nil, nil, false
+	}
+	basisFileOnDisk, basisOk := basisFile.(*os.File)
+	outputOnDisk, outputOk := output.(*os.File)
+	if !basisOk || !outputOk || !isRegularFile(basisFileOnDisk) || !isRegularFile(outputOnDisk) {
+		return nil, nil, false
+	}
+	if verify && !isReadable(outputOnDisk) {
+		return nil, nil, false
+	}
+	return basisFileOnDisk, outputOnDisk, true
+}
+
+func isRegularFile(file *os.File) bool {
+	info, err := file.Stat()
+	return err == nil && info.Mode().IsRegular()
+}
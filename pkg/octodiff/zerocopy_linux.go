@@ -0,0 +1,24 @@
+package octodiff
+
+import (
+	"os"
+	"syscall"
+)
+
+// zeroCopySupported is whether (*os.File).ReadFrom copies between files without reading them into user space.
+// On Linux it uses copy_file_range, which on filesystems such as XFS and Btrfs may share the blocks rather than copy them.
+const zeroCopySupported = true
+
+// isReadable reports whether `file` was opened for reading, and not just for writing.
+func isReadable(file *os.File) bool {
+	conn, err := file.SyscallConn()
+	if err != nil {
+		return false
+	}
+	var flags uintptr
+	var errno syscall.Errno
+	err = conn.Control(func(fd uintptr) {
+		flags, _, errno = syscall.Syscall(syscall.SYS_FCNTL, fd, syscall.F_GETFL, 0)
+	})
+	return err == nil && errno == 0 && flags&syscall.O_ACCMODE != syscall.O_WRONLY
+}
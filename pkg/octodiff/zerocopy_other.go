@@ -0,0 +1,14 @@
+//go:build !linux
+
+package octodiff
+
+import "os"
+
+// zeroCopySupported is whether (*os.File).ReadFrom copies between files without reading them into user space.
+// Elsewhere it may just copy through a small buffer, which is slower than our own buffered copies.
+const zeroCopySupported = false
+
+// isReadable reports whether `file` was opened for reading; only needed where zeroCopySupported.
+func isReadable(file *os.File) bool {
+	return false
+}
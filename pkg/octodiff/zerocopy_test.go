@@ -0,0 +1,139 @@
+package octodiff_test
+
+import (
+	"bytes"
+	"github.com/OctopusDeploy/go-octodiff/pkg/octodiff"
+	"github.com/OctopusDeploy/go-octodiff/pkg/test"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// applyFileToFile applies a delta with both the basis file and the new file on disk, which on Linux uses copy_file_range
+// unless verifying a new file opened write-only with `newFileFlag`, which can't be read back to hash it. The new file
+// starts with `prefix`, to check that the new file is written, and read back, from the current position of the output.
+func applyFileToFile(t *testing.T, basis []byte, deltaReader octodiff.DeltaReader, prefix []byte, newFileFlag int, options octodiff.ApplyDeltaOptions) ([]byte, error) {
+	basisFile, err := os.Open(writeTempFile(t, "basis", basis))
+	assert.Nil(t, err)
+	defer func() { _ = basisFile.Close() }()
+
+	newFilePath := filepath.Join(t.TempDir(), "new")
+	newFile, err := os.OpenFile(newFilePath, newFileFlag|os.O_CREATE|os.O_TRUNC, 0600)
+	assert.Nil(t, err)
+	defer func() { _ = newFile.Close() }()
+	_, err = newFile.Write(prefix)
+	assert.Nil(t, err)
+
+	err = octodiff.ApplyDeltaWithOptions(basisFile, deltaReader, newFile, options)
+	output, readErr := os.ReadFile(newFilePath)
+	assert.Nil(t, readErr)
+	return output, err
+}
+
+func TestApplyDeltaFileToFile(t *testing.T) {
+	original, newFile := largeFileWithDisjointChanges()
+	deltaFile := buildDelta(newFile, buildSignature(original))
+
+	// the basis file has changed since the signature was taken, in a part which the delta copies
+	changedOriginal := append([]byte(nil), original...)
+	changedOriginal[64*1024]++
+
+	for _, newFileFlag := range []int{os.O_WRONLY, os.O_RDWR} {
+		for _, verify := range []bool{false, true} {
+			output, err := applyFileToFile(t, original, octodiff.NewBinaryDeltaReader(bytes.NewReader(deltaFile)), []byte("prefix"), newFileFlag, octodiff.ApplyDeltaOptions{Verify: verify})
+			assert.Nil(t, err)
+			assert.Equal(t, append([]byte("prefix"), newFile...), output)
+		}
+
+		_, err := applyFileToFile(t, changedOriginal, octodiff.NewBinaryDeltaReader(bytes.NewReader(deltaFile)), []byte("prefix"), newFileFlag, octodiff.ApplyDeltaOptions{Verify: true})
+		assert.ErrorIs(t, err, octodiff.ErrVerificationFailed)
+	}
+}
+
+func TestApplyDeltaFileToFileCoalescesCopies(t *testing.T) {
+	basis := test.GenerateTestData(1000)
+	delta := &octodiff.Delta{Commands: []*octodiff.DeltaCommand{
+		octodiff.NewCopyCommand(0, 10),
+		octodiff.NewCopyCommand(10, 20), // follows on from the previous copy
+		octodiff.NewCopyCommand(500, 0),
+		octodiff.NewDataCommand([]byte("between")),
+		octodiff.NewCopyCommand(30, 100),
+		octodiff.NewCopyCommand(900, 100),
+		octodiff.NewCopyCommand(0, 5),
+		octodiff.NewCopyCommand(990, 20), // runs off the end of the basis file, which gives a short copy
+		octodiff.NewDataCommand([]byte("end")),
+	}}
+
+	var expected bytes.Buffer
+	err := octodiff.ApplyDelta(bytes.NewReader(basis), delta.Reader(), &expected)
+	assert.Nil(t, err)
+
+	output, err := applyFileToFile(t, basis, delta.Reader(), nil, os.O_WRONLY, octodiff.ApplyDeltaOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, expected.Bytes(), output)
+	assert.Equal(t, 10+20+7+100+100+5+10+3, len(output))
+}
+
+func TestApplyDeltaFileToFileChecksLimits(t *testing.T) {
+	original, newFile := largeFileWithDisjointChanges()
+	deltaFile := buildDelta(newFile, buildSignature(original))
+
+	options := octodiff.ApplyDeltaOptions{Limits: &octodiff.DeltaLimits{MaxOutputSize: int64(len(newFile) - 1)}}
+	_, err := applyFileToFile(t, original, octodiff.NewBinaryDeltaReader(bytes.NewReader(deltaFile)), nil, os.O_WRONLY, options)
+	assert.ErrorIs(t, err, octodiff.ErrLimitExceeded)
+}
+
+// The benchmarks below compare applying a delta to a large, mostly unchanged file on disk
+// with copies through user space (BenchmarkApplyDeltaBuffered) and, on Linux, with copy_file_range.
+
+func benchmarkApplyDeltaToFile(b *testing.B, buffered bool) {
+	dir := b.TempDir()
+	original := test.GenerateTestData(32 * 1024 * 1024)
+	newFile := append([]byte(nil), original...)
+	newFile[1000]++
+	newFile[20*1024*1024]++
+	deltaFile := buildDelta(newFile, buildSignature(original))
+
+	basisPath := filepath.Join(dir, "basis")
+	err := os.WriteFile(basisPath, original, 0600)
+	if err != nil {
+		b.Fatal(err)
+	}
+	basisFile, err := os.Open(basisPath)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer func() { _ = basisFile.Close() }()
+	output, err := os.Create(filepath.Join(dir, "new"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer func() { _ = output.Close() }()
+
+	b.SetBytes(int64(len(newFile)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err = output.Seek(0, io.SeekStart)
+		if err != nil {
+			b.Fatal(err)
+		}
+		var w io.Writer = output
+		if buffered {
+			w = struct{ io.Writer }{output} // hides the *os.File
+		}
+		err = octodiff.ApplyDelta(basisFile, octodiff.NewBinaryDeltaReader(bytes.NewReader(deltaFile)), w)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkApplyDeltaBuffered(b *testing.B) {
+	benchmarkApplyDeltaToFile(b, true)
+}
+
+func BenchmarkApplyDeltaFileToFile(b *testing.B) {
+	benchmarkApplyDeltaToFile(b, false)
+}